@@ -0,0 +1,28 @@
+#!/bin/sh
+# Gera os certificados locais para testar TLS e mutual TLS
+set -e
+cd "$(dirname "$0")"
+
+rm -f *.pem *.srl
+
+# 1. chave privada e certificado auto-assinado da CA
+openssl req -x509 -newkey rsa:4096 -days 365 -nodes -keyout ca-key.pem -out ca-cert.pem \
+	-subj "/C=BR/O=PC Book/OU=Dev/CN=pcbook-ca"
+
+# 2. chave privada e CSR do servidor
+openssl req -newkey rsa:4096 -nodes -keyout server-key.pem -out server-req.pem \
+	-subj "/C=BR/O=PC Book/OU=Server/CN=localhost"
+
+# 3. certificado do servidor assinado pela CA
+openssl x509 -req -in server-req.pem -days 60 -CA ca-cert.pem -CAkey ca-key.pem -CAcreateserial \
+	-out server-cert.pem -extfile server-ext.cnf
+
+# 4. chave privada e CSR do cliente, o CN é mapeado para um usuário em policy.yaml
+openssl req -newkey rsa:4096 -nodes -keyout client-key.pem -out client-req.pem \
+	-subj "/C=BR/O=PC Book/OU=Client/CN=import-job"
+
+# 5. certificado do cliente assinado pela CA
+openssl x509 -req -in client-req.pem -days 60 -CA ca-cert.pem -CAkey ca-key.pem -CAcreateserial \
+	-out client-cert.pem -extfile client-ext.cnf
+
+rm -f *-req.pem
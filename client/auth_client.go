@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/pcbook-go/pb"
@@ -12,14 +13,23 @@ type AuthClient struct {
 	service  pb.AuthServiceClient
 	username string
 	password string
+
+	// secondFactor returns the TOTP code of users with a second factor
+	secondFactor func() (string, error)
 }
 
 func NewAuthClient(cc *grpc.ClientConn, username string, password string) *AuthClient {
 	service := pb.NewAuthServiceClient(cc)
-	return &AuthClient{service, username, password}
+	return &AuthClient{service: service, username: username, password: password}
+}
+
+// SetSecondFactor sets the function called to get a TOTP code when the server requires it at login
+func (client *AuthClient) SetSecondFactor(secondFactor func() (string, error)) {
+	client.secondFactor = secondFactor
 }
 
-func (client *AuthClient) Login() (string, error) {
+// Login logs the user in and returns a new access token and refresh token
+func (client *AuthClient) Login() (string, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -30,8 +40,61 @@ func (client *AuthClient) Login() (string, error) {
 
 	res, err := client.service.Login(ctx, req)
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	if !res.GetMfaRequired() {
+		return res.GetAccessToken(), res.GetRefreshToken(), nil
+	}
+
+	if client.secondFactor == nil {
+		return "", "", fmt.Errorf("user %s requires a second factor", client.username)
+	}
+
+	code, err := client.secondFactor()
+	if err != nil {
+		return "", "", fmt.Errorf("cannot get second factor: %w", err)
+	}
+
+	verifyReq := &pb.VerifySecondFactorRequest{
+		ChallengeToken: res.GetChallengeToken(),
+		Factor:         &pb.VerifySecondFactorRequest_Code{Code: code},
+	}
+
+	verifyRes, err := client.service.VerifySecondFactor(ctx, verifyReq)
+	if err != nil {
+		return "", "", err
+	}
+
+	return verifyRes.GetAccessToken(), verifyRes.GetRefreshToken(), nil
+}
+
+// RefreshToken exchanges a refresh token for a new access token and refresh token
+func (client *AuthClient) RefreshToken(refreshToken string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.RefreshTokenRequest{
+		RefreshToken: refreshToken,
+	}
+
+	res, err := client.service.RefreshToken(ctx, req)
+	if err != nil {
+		return "", "", err
+	}
+
+	return res.GetAccessToken(), res.GetRefreshToken(), nil
+}
+
+// Logout revokes the refresh token
+func (client *AuthClient) Logout(refreshToken string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req := &pb.LogoutRequest{
+		RefreshToken: refreshToken,
 	}
 
-	return res.GetAccessToken(), nil
+	_, err := client.service.Logout(ctx, req)
+	return err
 }
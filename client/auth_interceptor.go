@@ -2,27 +2,31 @@ package client
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// AuthInterceptor attaches the access token to every call. The server ignores it on the public methods,
+// so that the client does not need to know the access control policy of the server.
 type AuthInterceptor struct {
-	authClient  *AuthClient
-	authMethods map[string]bool
-	accessToken string
+	authClient   *AuthClient
+	mutex        sync.RWMutex
+	accessToken  string
+	refreshToken string
 }
 
 func NewAuthInterceptor(
 	authClient *AuthClient,
-	authMethods map[string]bool,
 	refreshDuration time.Duration,
 ) (*AuthInterceptor, error) {
 	interceptor := &AuthInterceptor{
-		authClient:  authClient,
-		authMethods: authMethods,
+		authClient: authClient,
 	}
 
 	err := interceptor.scheduleRefreshToken(refreshDuration)
@@ -33,20 +37,57 @@ func NewAuthInterceptor(
 	return interceptor, nil
 }
 
-func (interceptor *AuthInterceptor) refreshToken() error {
-	accessToken, err := interceptor.authClient.Login()
+// refreshTokens uses the refresh token to get a new access token,
+// falling back to a full login only when the server rejects the refresh token
+func (interceptor *AuthInterceptor) refreshTokens() error {
+	interceptor.mutex.RLock()
+	refreshToken := interceptor.refreshToken
+	interceptor.mutex.RUnlock()
+
+	if refreshToken != "" {
+		accessToken, newRefreshToken, err := interceptor.authClient.RefreshToken(refreshToken)
+		if err == nil {
+			interceptor.setTokens(accessToken, newRefreshToken)
+			return nil
+		}
+
+		// the server may just be unreachable: the refresh token is kept for the next attempt,
+		// instead of sending the password again
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+	}
+
+	accessToken, refreshToken, err := interceptor.authClient.Login()
 	if err != nil {
 		return err
 	}
 
+	interceptor.setTokens(accessToken, refreshToken)
+	return nil
+}
+
+func (interceptor *AuthInterceptor) setTokens(accessToken string, refreshToken string) {
+	interceptor.mutex.Lock()
 	interceptor.accessToken = accessToken
-	log.Printf("token refreshed: %v", accessToken)
+	interceptor.refreshToken = refreshToken
+	interceptor.mutex.Unlock()
 
-	return nil
+	slog.Debug("token refreshed")
+}
+
+// Logout revokes the current refresh token
+func (interceptor *AuthInterceptor) Logout() error {
+	interceptor.mutex.Lock()
+	refreshToken := interceptor.refreshToken
+	interceptor.refreshToken = ""
+	interceptor.mutex.Unlock()
+
+	return interceptor.authClient.Logout(refreshToken)
 }
 
 func (interceptor *AuthInterceptor) scheduleRefreshToken(refreshDuration time.Duration) error {
-	err := interceptor.refreshToken()
+	err := interceptor.refreshTokens()
 	if err != nil {
 		return err
 	}
@@ -55,8 +96,9 @@ func (interceptor *AuthInterceptor) scheduleRefreshToken(refreshDuration time.Du
 		wait := refreshDuration
 		for {
 			time.Sleep(wait)
-			err := interceptor.refreshToken()
+			err := interceptor.refreshTokens()
 			if err != nil {
+				slog.Warn("cannot refresh token, retrying", "error", err)
 				wait = time.Second
 			} else {
 				wait = refreshDuration
@@ -76,13 +118,7 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryClientInterceptor {
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
-		log.Printf("--> unary interceptor: %s", method)
-
-		if interceptor.authMethods[method] {
-			return invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
-		}
-
-		return invoker(ctx, method, req, reply, cc, opts...)
+		return invoker(interceptor.attachToken(ctx), method, req, reply, cc, opts...)
 	}
 }
 
@@ -95,16 +131,13 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
 		streamer grpc.Streamer,
 		opts ...grpc.CallOption,
 	) (grpc.ClientStream, error) {
-		log.Printf("--> stream interceptor: %s", method)
-
-		if interceptor.authMethods[method] {
-			return streamer(interceptor.attachToken(ctx), desc, cc, method, opts...)
-		}
-
-		return streamer(ctx, desc, cc, method, opts...)
+		return streamer(interceptor.attachToken(ctx), desc, cc, method, opts...)
 	}
 }
 
 func (interceptor *AuthInterceptor) attachToken(ctx context.Context) context.Context {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
 	return metadata.AppendToOutgoingContext(ctx, "authorization", interceptor.accessToken)
 }
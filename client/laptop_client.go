@@ -5,7 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"time"
@@ -25,7 +25,7 @@ func NewLaptopClient(cc *grpc.ClientConn) *LaptopClient {
 	return &LaptopClient{service}
 }
 
-func (laptopClient *LaptopClient) CreateLaptop(laptop *pb.Laptop) {
+func (laptopClient *LaptopClient) CreateLaptop(laptop *pb.Laptop) error {
 	req := &pb.CreateLaptopRequest{
 		Laptop: laptop,
 	}
@@ -38,18 +38,35 @@ func (laptopClient *LaptopClient) CreateLaptop(laptop *pb.Laptop) {
 	if err != nil {
 		st, ok := status.FromError(err)
 		if ok && st.Code() == codes.AlreadyExists {
-			log.Print("laptop já existe")
-		} else {
-			log.Fatal("não pode criar laptop: ", err)
+			slog.Warn("laptop already exists", "laptop_id", laptop.GetId())
+			return nil
 		}
-		return
+		return fmt.Errorf("não pode criar laptop: %w", err)
 	}
 
-	log.Printf("laptop criado com id: %s", res.Id)
+	slog.Info("laptop created", "laptop_id", res.Id)
+	return nil
 }
 
-func (laptopClient *LaptopClient) SearchLaptop(filter *pb.Filter) {
-	log.Print("filtro de pesquisa: ", filter)
+func (laptopClient *LaptopClient) UpdateLaptop(laptop *pb.Laptop) error {
+	req := &pb.UpdateLaptopRequest{
+		Laptop: laptop,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := laptopClient.service.UpdateLaptop(ctx, req)
+	if err != nil {
+		return fmt.Errorf("não pode atualizar laptop: %w", err)
+	}
+
+	slog.Info("laptop updated", "laptop_id", res.Id)
+	return nil
+}
+
+func (laptopClient *LaptopClient) SearchLaptop(filter *pb.Filter) error {
+	slog.Debug("search laptops", "filter", filter.String())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -57,34 +74,35 @@ func (laptopClient *LaptopClient) SearchLaptop(filter *pb.Filter) {
 	req := &pb.SearchLaptopRequest{Filter: filter}
 	stream, err := laptopClient.service.SearchLaptop(ctx, req)
 	if err != nil {
-		log.Fatal("erro ao buscar laptop: ", err)
+		return fmt.Errorf("erro ao buscar laptop: %w", err)
 	}
 
 	for {
 		res, err := stream.Recv()
 		if err == io.EOF {
-			return
+			return nil
 		}
 		if err != nil {
-			log.Fatal("erro ao receber resposta: ", err)
+			return fmt.Errorf("erro ao receber resposta: %w", err)
 		}
 
 		laptop := res.GetLaptop()
-		log.Print("- found: ", laptop.GetId())
-		log.Print("  + brand: ", laptop.GetBrand())
-		log.Print("  + name: ", laptop.GetName())
-		log.Print("  + cpu cores: ", laptop.GetCpu().GetNumberCores())
-		log.Print("  + cpu min ghz: ", laptop.GetCpu().GetMinGhz())
-		log.Print("  + ram: ", laptop.GetRam())
-		log.Print("  + price: ", laptop.GetPriceUsd())
-
+		slog.Info("laptop found",
+			"laptop_id", laptop.GetId(),
+			"brand", laptop.GetBrand(),
+			"name", laptop.GetName(),
+			"cpu_cores", laptop.GetCpu().GetNumberCores(),
+			"cpu_min_ghz", laptop.GetCpu().GetMinGhz(),
+			"ram", laptop.GetRam().String(),
+			"price_usd", laptop.GetPriceUsd(),
+		)
 	}
 }
 
-func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string) {
+func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string) error {
 	file, err := os.Open(imagePath)
 	if err != nil {
-		log.Fatal("erro ao abrir arquivo da imagem: ", err)
+		return fmt.Errorf("erro ao abrir arquivo da imagem: %w", err)
 	}
 	defer file.Close()
 
@@ -93,8 +111,7 @@ func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string)
 
 	stream, err := laptopClient.service.UploadImage(ctx)
 	if err != nil {
-		log.Fatal("erro ao carregar imagem: ", err)
-
+		return fmt.Errorf("erro ao carregar imagem: %w", err)
 	}
 
 	req := &pb.UploadImageRequest{
@@ -108,7 +125,7 @@ func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string)
 
 	err = stream.Send(req)
 	if err != nil {
-		log.Fatal("erro ao enviar imagem para o servidor: ", err, stream.RecvMsg(nil))
+		return fmt.Errorf("erro ao enviar imagem para o servidor: %v - %v", err, stream.RecvMsg(nil))
 	}
 
 	reader := bufio.NewReader(file)
@@ -121,7 +138,7 @@ func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string)
 		}
 
 		if err != nil {
-			log.Fatal("erro ao ler parte do buffer: ", err)
+			return fmt.Errorf("erro ao ler parte do buffer: %w", err)
 		}
 
 		req := &pb.UploadImageRequest{
@@ -132,16 +149,94 @@ func (laptopClient *LaptopClient) UploadImage(laptopID string, imagePath string)
 
 		err = stream.Send(req)
 		if err != nil {
-			log.Fatal("erro ao enviar parte da imagem ao servidor: ", err, stream.RecvMsg(nil))
+			return fmt.Errorf("erro ao enviar parte da imagem ao servidor: %v - %v", err, stream.RecvMsg(nil))
 		}
 	}
 
 	res, err := stream.CloseAndRecv()
 	if err != nil {
-		log.Fatal("erro ao receber resposta: ", err)
+		return fmt.Errorf("erro ao receber resposta: %w", err)
+	}
+
+	slog.Info("image uploaded", "laptop_id", laptopID, "image_id", res.GetId(), "size", res.GetSize())
+	return nil
+}
+
+// ImportLaptops envia os laptops em um único stream e retorna o resumo da importação
+func (laptopClient *LaptopClient) ImportLaptops(laptops []*pb.Laptop, mode pb.ImportOptions_Mode) (*pb.ImportLaptopsResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	stream, err := laptopClient.service.ImportLaptops(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao importar laptops: %w", err)
+	}
+
+	req := &pb.ImportLaptopsRequest{
+		Data: &pb.ImportLaptopsRequest_Options{
+			Options: &pb.ImportOptions{Mode: mode},
+		},
+	}
+
+	err = stream.Send(req)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao enviar opções: %v - %v", err, stream.RecvMsg(nil))
+	}
+
+	for _, laptop := range laptops {
+		req := &pb.ImportLaptopsRequest{
+			Data: &pb.ImportLaptopsRequest_Laptop{Laptop: laptop},
+		}
+
+		err := stream.Send(req)
+		if err != nil {
+			return nil, fmt.Errorf("erro ao enviar laptop: %v - %v", err, stream.RecvMsg(nil))
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, fmt.Errorf("erro ao receber resposta: %w", err)
+	}
+
+	for _, importErr := range res.GetErrors() {
+		slog.Warn("laptop not imported", "index", importErr.GetIndex(), "laptop_id", importErr.GetId(), "code", importErr.GetCode(), "error", importErr.GetMessage())
+	}
+
+	slog.Info("laptops imported", "received", res.GetReceived(), "imported", res.GetImported(), "failed", res.GetFailed())
+	return res, nil
+}
+
+// ExportLaptops grava em w o arquivo com os laptops do filtro no formato pedido
+func (laptopClient *LaptopClient) ExportLaptops(filter *pb.Filter, format pb.ExportLaptopsRequest_Format, w io.Writer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	req := &pb.ExportLaptopsRequest{Filter: filter, Format: format}
+	stream, err := laptopClient.service.ExportLaptops(ctx, req)
+	if err != nil {
+		return fmt.Errorf("erro ao exportar laptops: %w", err)
+	}
+
+	size := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("erro ao receber parte do arquivo: %w", err)
+		}
+
+		n, err := w.Write(res.GetChunkData())
+		if err != nil {
+			return fmt.Errorf("erro ao gravar parte do arquivo: %w", err)
+		}
+		size += n
 	}
 
-	log.Printf("imagem carregada com o id: %s, de tamanhao: %d", res.GetId(), res.GetSize())
+	slog.Info("laptops exported", "format", format.String(), "size", size)
+	return nil
 }
 
 func (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float64) error {
@@ -158,7 +253,7 @@ func (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float6
 		for {
 			res, err := stream.Recv()
 			if err == io.EOF {
-				log.Print("não há mais respostas")
+				slog.Debug("no more responses")
 				waitResponse <- nil
 				return
 			}
@@ -167,7 +262,7 @@ func (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float6
 				return
 			}
 
-			log.Print("resposta recebida: ", res)
+			slog.Info("rating received", "laptop_id", res.GetLaptopId(), "rated_count", res.GetRatedCount(), "average_score", res.GetAverageScore())
 		}
 	}()
 
@@ -182,7 +277,7 @@ func (laptopClient *LaptopClient) RateLaptop(laptopIDs []string, scores []float6
 		if err != nil {
 			return fmt.Errorf("erro ao enviar solicitação: %v - %v", err, stream.RecvMsg(nil))
 		}
-		log.Println("solicitação enviada: ", req)
+		slog.Debug("rating sent", "laptop_id", req.GetLaptopId(), "score", req.GetScore())
 	}
 
 	err = stream.CloseSend()
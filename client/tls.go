@@ -0,0 +1,57 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// LoadTLSCredentials loads the CA certificate that signed the server certificate and,
+// if certFile and keyFile are not empty, the client certificate for mutual TLS
+func LoadTLSCredentials(caFile, certFile, keyFile string) (credentials.TransportCredentials, error) {
+	pemServerCA, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read server CA certificate: %w", err)
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemServerCA) {
+		return nil, fmt.Errorf("cannot add server CA certificate")
+	}
+
+	config := &tls.Config{
+		RootCAs:    certPool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+
+		config.Certificates = []tls.Certificate{clientCert}
+	}
+
+	return credentials.NewTLS(config), nil
+}
+
+// TransportOption returns the dial option to connect with TLS when caFile is set,
+// or in plaintext otherwise
+func TransportOption(caFile, certFile, keyFile string) (grpc.DialOption, error) {
+	if caFile == "" {
+		return grpc.WithTransportCredentials(insecure.NewCredentials()), nil
+	}
+
+	tlsCredentials, err := LoadTLSCredentials(caFile, certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return grpc.WithTransportCredentials(tlsCredentials), nil
+}
@@ -5,13 +5,17 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"os"
 	"strings"
 	"time"
 
 	"github.com/pcbook-go/client"
+	"github.com/pcbook-go/logging"
 	"github.com/pcbook-go/pb"
 	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/totp"
+	"github.com/pcbook-go/tracing"
 	"google.golang.org/grpc"
 )
 
@@ -29,7 +33,7 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 		for {
 			res, err := stream.Recv()
 			if err == io.EOF {
-				log.Print("não há mais respostas")
+				slog.Debug("no more responses")
 				waitResponse <- nil
 				return
 			}
@@ -38,7 +42,7 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 				return
 			}
 
-			log.Print("resposta recebida: ", res)
+			slog.Info("rating received", "laptop_id", res.GetLaptopId(), "rated_count", res.GetRatedCount(), "average_score", res.GetAverageScore())
 		}
 	}()
 
@@ -53,7 +57,7 @@ func rateLaptop(laptopClient pb.LaptopServiceClient, laptopIDs []string, scores
 		if err != nil {
 			return fmt.Errorf("erro ao enviar solicitação: %v - %v", err, stream.RecvMsg(nil))
 		}
-		log.Println("solicitação enviada: ", req)
+		slog.Debug("rating sent", "laptop_id", req.GetLaptopId(), "score", req.GetScore())
 	}
 
 	err = stream.CloseSend()
@@ -72,7 +76,10 @@ func testRateLaptop(laptopClient *client.LaptopClient) {
 	for i := 0; i < n; i++ {
 		laptop := sample.NewLaptop()
 		laptopIDs[i] = laptop.GetId()
-		laptopClient.CreateLaptop(laptop)
+		err := laptopClient.CreateLaptop(laptop)
+		if err != nil {
+			fatal("cannot create laptop", err)
+		}
 	}
 
 	scores := make([]float64, n)
@@ -91,18 +98,24 @@ func testRateLaptop(laptopClient *client.LaptopClient) {
 
 		err := laptopClient.RateLaptop(laptopIDs, scores)
 		if err != nil {
-			log.Fatal(err)
+			fatal("cannot rate laptops", err)
 		}
 	}
 }
 
 func testCreateLaptop(laptopClient *client.LaptopClient) {
-	laptopClient.CreateLaptop(sample.NewLaptop())
+	err := laptopClient.CreateLaptop(sample.NewLaptop())
+	if err != nil {
+		fatal("cannot create laptop", err)
+	}
 }
 
 func testSearchLaptop(laptopClient *client.LaptopClient) {
 	for i := 0; i < 10; i++ {
-		laptopClient.CreateLaptop(sample.NewLaptop())
+		err := laptopClient.CreateLaptop(sample.NewLaptop())
+		if err != nil {
+			fatal("cannot create laptop", err)
+		}
 	}
 
 	filter := &pb.Filter{
@@ -112,59 +125,135 @@ func testSearchLaptop(laptopClient *client.LaptopClient) {
 		MinRam:      &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE},
 	}
 
-	laptopClient.SearchLaptop(filter)
+	err := laptopClient.SearchLaptop(filter)
+	if err != nil {
+		fatal("cannot search laptops", err)
+	}
 }
 
 func testUploadImage(laptopClient *client.LaptopClient) {
 	laptop := sample.NewLaptop()
 
-	laptopClient.CreateLaptop(laptop)
+	err := laptopClient.CreateLaptop(laptop)
+	if err != nil {
+		fatal("cannot create laptop", err)
+	}
+
+	err = laptopClient.UploadImage(laptop.GetId(), "tmp/laptop.jpg")
+	if err != nil {
+		fatal("cannot upload image", err)
+	}
+}
+
+// newLogger returns the logger of the client, writing to the standard error
+func newLogger(levelName string, format string) (*slog.Logger, error) {
+	level, err := logging.ParseLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
 
-	laptopClient.UploadImage(laptop.GetId(), "tmp/laptop.jpg")
+	return logging.New(os.Stderr, format, level)
+}
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
 
 const (
 	username        = "user1"
-	password        = "secret"
+	password        = "User-Secret1"
 	refreshDuration = 30 * time.Second
 )
 
-func authMethods() map[string]bool {
-	const laptopServicePath = "/pcbook.LaptopService/"
-
-	return map[string]bool{
-		laptopServicePath + "CreateLaptop": true,
-		laptopServicePath + "UploadImage":  true,
-		laptopServicePath + "RateLaptop":   true,
-	}
-}
-
 func main() {
 	serverAddress := flag.String("address", "", "o endereço do servidor")
+	apiKey := flag.String("api-key", os.Getenv("PCBOOK_API_KEY"), "API key used instead of logging in as a user")
+	tlsCAFile := flag.String("tls-ca", "", "CA certificate file of the server, enables TLS")
+	tlsCertFile := flag.String("tls-cert", "", "client certificate file for mutual TLS")
+	tlsKeyFile := flag.String("tls-key", "", "client private key file for mutual TLS")
+	totpSecret := flag.String("totp-secret", os.Getenv("PCBOOK_TOTP_SECRET"), "TOTP secret of the user, if the second factor is enabled")
+	logLevel := flag.String("log-level", "info", "minimum level of the logs: debug, info, warn or error")
+	logFormat := flag.String("log-format", logging.TextFormat, "format of the logs: text or json")
+	traceExporter := flag.String("trace-exporter", tracing.NoExporter, "exporter of the traces: none, stdout or file")
+	traceFile := flag.String("trace-file", "client-traces.json", "file where the file exporter writes the spans")
 	flag.Parse()
-	log.Printf("servidor de discagem %s", *serverAddress)
 
-	cc1, err := grpc.Dial(*serverAddress, grpc.WithInsecure())
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		fatal("cannot create logger", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("dialing server", "address", *serverAddress, "tls", *tlsCAFile != "")
+
+	exporter, err := tracing.NewExporter(*traceExporter, *traceFile)
+	if err != nil {
+		fatal("cannot create trace exporter", err)
+	}
+	tracerProvider := tracing.NewTracerProvider("pcbook-client", exporter)
+	defer tracerProvider.Shutdown(context.Background())
+
+	// the tracing interceptor comes first, so that the logs of a call have its trace ID
+	tracingInterceptor := tracing.NewClientInterceptor(tracerProvider)
+	loggingInterceptor := logging.NewClientInterceptor(logger)
+
+	transportOption, err := client.TransportOption(*tlsCAFile, *tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		fatal("cannot load TLS credentials", err)
+	}
+
+	if *apiKey != "" {
+		apiKeyInterceptor := client.NewAPIKeyInterceptor(*apiKey)
+		cc, err := grpc.Dial(
+			*serverAddress,
+			transportOption,
+			grpc.WithChainUnaryInterceptor(tracingInterceptor.Unary(), loggingInterceptor.Unary(), apiKeyInterceptor.Unary()),
+			grpc.WithChainStreamInterceptor(tracingInterceptor.Stream(), loggingInterceptor.Stream(), apiKeyInterceptor.Stream()),
+		)
+		if err != nil {
+			fatal("cannot dial server", err)
+		}
+
+		testRateLaptop(client.NewLaptopClient(cc))
+		return
+	}
+
+	cc1, err := grpc.Dial(
+		*serverAddress,
+		transportOption,
+		grpc.WithChainUnaryInterceptor(tracingInterceptor.Unary(), loggingInterceptor.Unary()),
+	)
 	if err != nil {
-		log.Fatal("não pode discar para o servidor: ", err)
+		fatal("cannot dial server", err)
 	}
 
 	authClient := client.NewAuthClient(cc1, username, password)
-	interceptor, err := client.NewAuthInterceptor(authClient, authMethods(), refreshDuration)
+	if *totpSecret != "" {
+		authClient.SetSecondFactor(func() (string, error) {
+			return totp.Code(*totpSecret, time.Now())
+		})
+	}
+	interceptor, err := client.NewAuthInterceptor(authClient, refreshDuration)
 	if err != nil {
-		log.Fatal("cannot create auth interceptor: ", err)
+		fatal("cannot create auth interceptor", err)
 	}
 
 	cc2, err := grpc.Dial(
 		*serverAddress,
-		grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(interceptor.Unary()),
-		grpc.WithStreamInterceptor(interceptor.Stream()),
+		transportOption,
+		grpc.WithChainUnaryInterceptor(tracingInterceptor.Unary(), loggingInterceptor.Unary(), interceptor.Unary()),
+		grpc.WithChainStreamInterceptor(tracingInterceptor.Stream(), loggingInterceptor.Stream(), interceptor.Stream()),
 	)
 	if err != nil {
-		log.Fatal("cannot dial server: ", err)
+		fatal("cannot dial server", err)
 	}
 
 	laptopClient := client.NewLaptopClient(cc2)
 	testRateLaptop(laptopClient)
+
+	err = interceptor.Logout()
+	if err != nil {
+		slog.Warn("cannot logout", "error", err)
+	}
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pcbook-go/client"
+	"github.com/pcbook-go/gateway"
+	"github.com/pcbook-go/logging"
+	"github.com/pcbook-go/pb"
+	"google.golang.org/grpc"
+)
+
+// shutdownTimeout bounds how long the running requests can take to finish on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// newLogger returns the logger of the gateway, writing to the standard error
+func newLogger(levelName string, format string) (*slog.Logger, error) {
+	level, err := logging.ParseLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+
+	return logging.New(os.Stderr, format, level)
+}
+
+// fatal logs the error and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func main() {
+	serverAddress := flag.String("address", "", "address of the gRPC server")
+	port := flag.Int("port", 8081, "HTTP port of the gateway")
+	tlsCAFile := flag.String("tls-ca", "", "CA certificate file of the server, enables TLS")
+	tlsCertFile := flag.String("tls-cert", "", "client certificate file for mutual TLS")
+	tlsKeyFile := flag.String("tls-key", "", "client private key file for mutual TLS")
+	logLevel := flag.String("log-level", "info", "minimum level of the logs: debug, info, warn or error")
+	logFormat := flag.String("log-format", logging.TextFormat, "format of the logs: text or json")
+	flag.Parse()
+
+	logger, err := newLogger(*logLevel, *logFormat)
+	if err != nil {
+		fatal("cannot create logger", err)
+	}
+	slog.SetDefault(logger)
+
+	transportOption, err := client.TransportOption(*tlsCAFile, *tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		fatal("cannot load TLS credentials", err)
+	}
+
+	// the credentials of each call come from the HTTP request, not from the gateway
+	loggingInterceptor := logging.NewClientInterceptor(logger)
+	cc, err := grpc.Dial(
+		*serverAddress,
+		transportOption,
+		grpc.WithChainUnaryInterceptor(loggingInterceptor.Unary()),
+		grpc.WithChainStreamInterceptor(loggingInterceptor.Stream()),
+	)
+	if err != nil {
+		fatal("cannot dial server", err)
+	}
+	defer cc.Close()
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf("0.0.0.0:%d", *port),
+		Handler:           gateway.New(pb.NewLaptopServiceClient(cc)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+	slog.Info("serving gateway", "address", server.Addr, "server", *serverAddress, "tls", *tlsCAFile != "")
+
+	select {
+	case err := <-serveErr:
+		fatal("cannot serve gateway", err)
+	case <-ctx.Done():
+	}
+
+	stop()
+	slog.Info("shutting down gateway")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("cannot stop gateway", "error", err)
+	}
+}
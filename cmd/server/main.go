@@ -1,86 +1,570 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
+	"github.com/pcbook-go/config"
+	"github.com/pcbook-go/logging"
+	"github.com/pcbook-go/metrics"
 	"github.com/pcbook-go/pb"
 	"github.com/pcbook-go/service"
+	"github.com/pcbook-go/tracing"
+	"github.com/pcbook-go/web"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
-const (
-	secretKey     = "secret"
-	tokenDuration = 15 * time.Minute
-)
+// flagFields maps the command line flags to the configuration fields they override
+var flagFields = []struct {
+	name  string
+	field string
+	usage string
+}{
+	{"port", "server.port", "a porta do servidor"},
+	{"metrics-port", "server.metrics_port", "HTTP port serving the Prometheus metrics, disabled if 0"},
+	{"grpc-web-port", "server.grpc_web.port", "HTTP port serving the gRPC-Web calls of the browsers, disabled if 0"},
+	{"drain-timeout", "server.drain_timeout", "how long the running calls can take to finish on shutdown"},
+	{"tls-cert", "server.tls.cert_file", "server certificate file, enables TLS"},
+	{"tls-key", "server.tls.key_file", "server private key file"},
+	{"tls-client-ca", "server.tls.client_ca_file", "CA certificate file of client certificates, enables mutual TLS"},
+	{"log-level", "log.level", "minimum level of the logs: debug, info, warn or error"},
+	{"log-format", "log.format", "format of the logs: text or json"},
+	{"trace-exporter", "tracing.exporter", "exporter of the traces: none, stdout or file"},
+	{"trace-file", "tracing.file", "file where the file exporter writes the spans"},
+	{"jwt-signing-key", "auth.jwt_signing_key_file", "PEM file with the private key used to sign tokens (RSA, ECDSA or Ed25519)"},
+	{"jwt-verification-keys", "auth.jwt_verification_key_files", "comma-separated PEM files with public keys still accepted to verify tokens"},
+	{"policy", "auth.policy_file", "access control policy file (YAML or JSON)"},
+	{"password-hash", "auth.password_hash", "password hash algorithm: bcrypt or argon2id"},
+	{"bcrypt-cost", "auth.bcrypt_cost", "bcrypt cost of new password hashes"},
+	{"password-deny-list", "auth.password_deny_list_file", "file with passwords that are not accepted, one per line"},
+	{"users-file", "stores.users_file", "JSON file where users are persisted, users are kept in memory if empty"},
+	{"audit-log", "stores.audit_log", "append-only file where the mutating RPCs are recorded"},
+	{"rate-limits", "limits.rate_limits_file", "rate limit configuration file (YAML or JSON)"},
+}
 
-func createUser(userStore service.UserStore, username, password, role string) error {
-	user, err := service.NewUser(username, password, role)
+// loadConfig loads the configuration file, overridden by the environment variables,
+// then by the flags set on the command line
+func loadConfig(filename string, flags map[string]*string) (*config.Config, error) {
+	cfg, err := config.Load(filename, os.LookupEnv)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	for _, flagField := range flagFields {
+		value, ok := flags[flagField.name]
+		if !ok {
+			continue
+		}
+
+		err = cfg.Set(flagField.field, *value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flag -%s: %w", flagField.name, err)
+		}
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
 	}
-	return userStore.Save(user)
+
+	return cfg, nil
 }
 
-func seedUsers(userStore service.UserStore) error {
-	err := createUser(userStore, "admin1", "secret", "admin")
+func createUser(
+	userStore service.UserStore,
+	hasher service.PasswordHasher,
+	passwordPolicy *service.PasswordPolicy,
+	username, password, role, organization string,
+) error {
+	err := passwordPolicy.Validate(username, password)
+	if err != nil {
+		return err
+	}
+
+	user, err := service.NewUserWithHasher(username, password, role, hasher)
 	if err != nil {
 		return err
 	}
-	return createUser(userStore, "user1", "secret", "user")
+	user.Organization = organization
+
+	err = userStore.Save(user)
+	if errors.Is(err, service.ErrAlreadyExists) {
+		// the user has been persisted by a previous run
+		return nil
+	}
+	return err
+}
+
+func seedUsers(
+	userStore service.UserStore,
+	hasher service.PasswordHasher,
+	passwordPolicy *service.PasswordPolicy,
+	users []config.UserConfig,
+) error {
+	for _, user := range users {
+		err := createUser(userStore, hasher, passwordPolicy, user.Username, user.Password, user.Role, user.Organization)
+		if err != nil {
+			return fmt.Errorf("cannot create user %s: %w", user.Username, err)
+		}
+	}
+
+	return nil
+}
+
+// newPasswordHasher returns the password hashing policy for the given algorithm
+func newPasswordHasher(algorithm string, bcryptCost int) (service.PasswordHasher, error) {
+	switch algorithm {
+	case "bcrypt":
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("invalid bcrypt cost: %d", bcryptCost)
+		}
+		return &service.BcryptHasher{Cost: bcryptCost}, nil
+	case "argon2id":
+		return service.NewArgon2idHasher(), nil
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm: %s", algorithm)
+	}
+}
+
+// newUserStore returns a user store persisted to usersFile, or kept in memory if it is empty
+func newUserStore(usersFile string) (service.UserStore, error) {
+	if usersFile == "" {
+		return service.NewInMemoryUserStore(), nil
+	}
+
+	return service.NewFileUserStore(usersFile)
+}
+
+// newLogger returns the logger of the server, writing to the standard error
+func newLogger(levelName string, format string) (*slog.Logger, error) {
+	level, err := logging.ParseLevel(levelName)
+	if err != nil {
+		return nil, err
+	}
+
+	return logging.New(os.Stderr, format, level)
+}
+
+// serveMetrics starts serving the Prometheus metrics over HTTP, on a port separate from the gRPC server
+func serveMetrics(port int, registry *prometheus.Registry) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(registry))
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf("0.0.0.0:%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("serving metrics", "address", server.Addr)
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("cannot serve metrics", "error", err)
+		}
+	}()
+
+	return server
 }
 
-func accessibleRoles() map[string][]string {
-	const laptopServicePath = "/pcbook.LaptopService/"
+// serveGRPCWeb starts serving the gRPC-Web calls over HTTP, with TLS if certFile is set
+func serveGRPCWeb(port int, handler http.Handler, certFile string, keyFile string) *http.Server {
+	server := &http.Server{
+		Addr:              fmt.Sprintf("0.0.0.0:%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("serving gRPC-Web", "address", server.Addr, "tls", certFile != "")
+
+	go func() {
+		var err error
+		if certFile != "" {
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("cannot serve gRPC-Web", "error", err)
+		}
+	}()
+
+	return server
+}
+
+// gracefulStop stops accepting new calls and waits for the running ones, like UploadImage streams,
+// cancelling those still running after the drain timeout
+func gracefulStop(grpcServer *grpc.Server, drainTimeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+		slog.Info("all calls have been drained")
+	case <-timer.C:
+		slog.Warn("drain timeout exceeded, cancelling the remaining calls")
+		grpcServer.Stop()
+		<-stopped
+	}
+}
+
+// reloadPolicyOnSignal reloads the access control policy file whenever the server receives SIGHUP
+func reloadPolicyOnSignal(interceptor *service.AuthInterceptor, policyFile string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		policy, err := service.LoadPolicyFile(policyFile)
+		if err != nil {
+			slog.Error("cannot reload policy, keeping the current one", "error", err)
+			continue
+		}
 
-	return map[string][]string{
-		laptopServicePath + "CreateLaptop": {"admin"},
-		laptopServicePath + "UploadImage":  {"admin"},
-		laptopServicePath + "RateLaptop":   {"admin", "user"},
+		interceptor.SetPolicy(policy)
+		slog.Info("policy reloaded", "file", policyFile)
 	}
 }
 
+// loadKeySet loads the JWT signing key and the extra keys still accepted for verification.
+// Without a signing key file, tokens are signed with the HS256 secret key.
+func loadKeySet(secretKey string, signingKeyFile string, verificationKeyFiles []string) (*service.KeySet, error) {
+	signingKey := service.NewHMACKey("", []byte(secretKey))
+	if signingKeyFile != "" {
+		key, err := service.LoadPrivateKeyFile(signingKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signingKey = key
+	}
+
+	var verificationKeys []*service.SigningKey
+	for _, filename := range verificationKeyFiles {
+		key, err := service.LoadPublicKeyFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return service.NewKeySet(signingKey, verificationKeys...)
+}
+
 func main() {
-	port := flag.Int("port", 0, "a porta do servidor")
+	err := run()
+	if err != nil {
+		slog.Error("server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run serves until the server is stopped by a signal. The errors are returned instead of exiting,
+// so that the deferred calls, e.g. closing the audit log and flushing the traces, always run.
+func run() error {
+	configFile := flag.String("config", "", "YAML configuration file, the defaults are used if empty")
+	flags := make(map[string]*string)
+	for _, flagField := range flagFields {
+		flags[flagField.name] = flag.String(flagField.name, "", flagField.usage+", overrides "+flagField.field)
+	}
 	flag.Parse()
-	log.Printf("o servidor está na porta %d", *port)
 
-	userStore := service.NewInMemoryUserStore()
-	err := seedUsers(userStore)
+	// only the flags set on the command line override the configuration
+	setFlags := make(map[string]*string)
+	flag.Visit(func(f *flag.Flag) {
+		if value, ok := flags[f.Name]; ok {
+			setFlags[f.Name] = value
+		}
+	})
+
+	cfg, err := loadConfig(*configFile, setFlags)
+	if err != nil {
+		return fmt.Errorf("cannot load config: %w", err)
+	}
+
+	logger, err := newLogger(cfg.Log.Level, cfg.Log.Format)
+	if err != nil {
+		return fmt.Errorf("cannot create logger: %w", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("starting server", "port", cfg.Server.Port, "tls", cfg.Server.TLS.CertFile != "")
+	slog.Info("effective config", "config", cfg)
+
+	hasher, err := newPasswordHasher(cfg.Auth.PasswordHash, cfg.Auth.BcryptCost)
+	if err != nil {
+		return fmt.Errorf("cannot create password hasher: %w", err)
+	}
+
+	userStore, err := newUserStore(cfg.Stores.UsersFile)
 	if err != nil {
-		log.Fatal("cannot seed users: ", err)
+		return fmt.Errorf("cannot create user store: %w", err)
 	}
 
-	jwtManager := service.NewJWTManager(secretKey, tokenDuration)
-	authServer := service.NewAuthServer(userStore, jwtManager)
+	passwordPolicy := service.DefaultPasswordPolicy()
+	if cfg.Auth.PasswordDenyListFile != "" {
+		err = passwordPolicy.LoadDenyListFile(cfg.Auth.PasswordDenyListFile)
+		if err != nil {
+			return fmt.Errorf("cannot load password deny-list: %w", err)
+		}
+	}
+
+	policy, err := service.LoadPolicyFile(cfg.Auth.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load policy: %w", err)
+	}
 
-	laptopStore := service.NewInMemoryLaptopStore()
-	imageStore := service.NewDiskIMageStore("img")
-	ratiStore := service.NewInMemoryRatingStore()
-	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratiStore)
+	// the roles of the users are checked before they are created
+	err = cfg.ValidateRoles(policy.HasRole)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	err = seedUsers(userStore, hasher, passwordPolicy, cfg.Users)
+	if err != nil {
+		return fmt.Errorf("cannot seed users: %w", err)
+	}
+
+	revocationStore := service.NewInMemoryRevocationStore()
+	keySet, err := loadKeySet(cfg.Auth.JWTSecret, cfg.Auth.JWTSigningKeyFile, cfg.Auth.JWTVerificationKeyFiles)
+	if err != nil {
+		return fmt.Errorf("cannot load jwt keys: %w", err)
+	}
 
-	interceptor := service.NewAuthInterceptor(jwtManager, accessibleRoles())
-	grpcServer := grpc.NewServer(
-		grpc.UnaryInterceptor(interceptor.Unary()),
-		grpc.StreamInterceptor(interceptor.Stream()),
+	jwtManager := service.NewJWTManager(keySet, cfg.Auth.TokenDuration, cfg.Auth.RefreshTokenDuration, revocationStore)
+
+	exporter, err := tracing.NewExporter(cfg.Tracing.Exporter, cfg.Tracing.File)
+	if err != nil {
+		return fmt.Errorf("cannot create trace exporter: %w", err)
+	}
+	tracerProvider := tracing.NewTracerProvider("pcbook-server", exporter)
+	defer tracerProvider.Shutdown(context.Background())
+
+	memoryLaptopStore := service.NewInMemoryLaptopStore()
+	laptopEvents := service.NewLaptopEventBus(cfg.Limits.LaptopEventHistory)
+	laptopStore := service.NewTracedLaptopStore(service.NewEventLaptopStore(memoryLaptopStore, laptopEvents), tracerProvider)
+	imageStore := service.NewTracedImageStore(service.NewDiskIMageStore(cfg.Stores.ImageFolder), tracerProvider)
+	ratiStore := service.NewTracedRatingStore(service.NewInMemoryRatingStore(), tracerProvider)
+	webhookStore := service.NewInMemoryWebhookStore()
+	webhookDispatcher := service.NewWebhookDispatcher(
+		webhookStore,
+		service.WithWebhookTimeout(cfg.Webhooks.Timeout),
+		service.WithWebhookRetries(cfg.Webhooks.MaxAttempts, cfg.Webhooks.InitialBackoff, cfg.Webhooks.MaxBackoff),
+		service.WithWebhookWorkers(cfg.Webhooks.Workers, cfg.Webhooks.QueueSize),
+	)
+	laptopServerOptions := []service.LaptopServerOption{
+		service.WithMaxImageSize(cfg.Limits.MaxImageSize),
+		service.WithLaptopEvents(laptopEvents),
+		service.WithWebhooks(webhookDispatcher),
+		service.WithMaxImportLaptops(cfg.Limits.MaxImportLaptops),
+	}
+	if cfg.Auth.OrganizationSharing {
+		laptopServerOptions = append(laptopServerOptions, service.WithOrganizationSharing())
+	}
+	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratiStore, laptopServerOptions...)
+
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	interceptor := service.NewAuthInterceptor(jwtManager, apiKeyStore, policy)
+	go reloadPolicyOnSignal(interceptor, cfg.Auth.PolicyFile)
+
+	loginLimiter := service.NewLoginLimiter(
+		cfg.Auth.Login.MaxFailures,
+		cfg.Auth.Login.BackoffDelay,
+		cfg.Auth.Login.MaxBackoff,
+		cfg.Auth.Login.LockoutDuration,
+	)
+	authServer := service.NewAuthServer(
+		userStore,
+		jwtManager,
+		service.WithAPIKeys(apiKeyStore, interceptor),
+		service.WithLoginLimiter(loginLimiter),
+		service.WithPasswordHasher(hasher),
+		service.WithPasswordPolicy(passwordPolicy),
+		service.WithPasswordReset(service.NewInMemoryPasswordResetStore(), cfg.Auth.ResetTokenDuration, interceptor),
 	)
 
+	rateLimitConfig, err := service.LoadRateLimitFile(cfg.Limits.RateLimitsFile)
+	if err != nil {
+		return fmt.Errorf("cannot load rate limits: %w", err)
+	}
+	rateLimitInterceptor := service.NewRateLimitInterceptor(rateLimitConfig)
+
+	auditLog, err := service.NewFileAuditLog(cfg.Stores.AuditLog)
+	if err != nil {
+		return fmt.Errorf("cannot open audit log: %w", err)
+	}
+	// the head is logged when the log is opened and closed, so that a truncation of the file can be detected
+	logAuditHead := func(msg string) {
+		sequence, hash := auditLog.Head()
+		slog.Info(msg, "path", cfg.Stores.AuditLog, "head_sequence", sequence, "head_hash", hash)
+	}
+	logAuditHead("audit log opened")
+	defer func() {
+		auditLog.Close()
+		logAuditHead("audit log closed")
+	}()
+	auditInterceptor := service.NewAuditInterceptor(auditLog, service.AuditedMethods...)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	metricsInterceptor, err := metrics.NewServerInterceptor(registry)
+	if err != nil {
+		return fmt.Errorf("cannot register metrics: %w", err)
+	}
+
+	err = metrics.RegisterStoreGauges(registry, laptopStore, imageStore, ratiStore)
+	if err != nil {
+		return fmt.Errorf("cannot register metrics: %w", err)
+	}
+
+	var metricsServer *http.Server
+	if cfg.Server.MetricsPort != 0 {
+		metricsServer = serveMetrics(cfg.Server.MetricsPort, registry)
+	}
+
+	// the forwarded peer interceptor comes first, so that the others see the clients of the gateway instead of the gateway;
+	// then the tracing, logging, metrics and audit interceptors, to see the calls rejected by the others;
+	// the audit entries and the rate limits use the user found by the auth interceptor
+	forwardedPeerInterceptor := service.NewForwardedPeerInterceptor(cfg.Server.TLS.TrustedProxies...)
+	tracingInterceptor := tracing.NewServerInterceptor(tracerProvider)
+	loggingInterceptor := logging.NewServerInterceptor(logger)
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			forwardedPeerInterceptor.Unary(),
+			tracingInterceptor.Unary(),
+			loggingInterceptor.Unary(),
+			metricsInterceptor.Unary(),
+			auditInterceptor.Unary(),
+			interceptor.Unary(),
+			rateLimitInterceptor.Unary(),
+		),
+		grpc.ChainStreamInterceptor(
+			forwardedPeerInterceptor.Stream(),
+			tracingInterceptor.Stream(),
+			loggingInterceptor.Stream(),
+			metricsInterceptor.Stream(),
+			auditInterceptor.Stream(),
+			interceptor.Stream(),
+			rateLimitInterceptor.Stream(),
+		),
+	}
+
+	if cfg.Server.TLS.CertFile != "" {
+		tlsCredentials, err := service.LoadServerTLSCredentials(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile, cfg.Server.TLS.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("cannot load TLS credentials: %w", err)
+		}
+
+		serverOptions = append(serverOptions, grpc.Creds(tlsCredentials))
+	}
+
+	grpcServer := grpc.NewServer(serverOptions...)
+
 	pb.RegisterAuthServiceServer(grpcServer, authServer)
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	pb.RegisterAuditServiceServer(grpcServer, service.NewAuditServer(auditLog))
+	pb.RegisterWebhookServiceServer(grpcServer, service.NewWebhookServer(webhookStore, webhookDispatcher))
 	reflection.Register(grpcServer)
 
-	addres := fmt.Sprintf("0.0.0.0:%d", *port)
+	// the health checks use the untraced stores, to keep them out of the traces
+	healthServer := health.NewServer()
+	healthChecker := service.NewHealthChecker(healthServer)
+	laptopService := pb.LaptopService_ServiceDesc.ServiceName
+	authService := pb.AuthService_ServiceDesc.ServiceName
+	healthChecker.AddDependency("laptop-store", service.LaptopStoreCheck(memoryLaptopStore), laptopService)
+	healthChecker.AddDependency("image-folder", service.WritableFolderCheck(cfg.Stores.ImageFolder), laptopService)
+	healthChecker.AddDependency("user-store", service.UserStoreCheck(userStore), authService)
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go healthChecker.Run(ctx, cfg.Server.HealthCheckInterval)
+
+	addres := fmt.Sprintf("0.0.0.0:%d", cfg.Server.Port)
 	listener, err := net.Listen("tcp", addres)
 	if err != nil {
-		log.Fatal("não foi possivel inicar o servidor", err)
+		return fmt.Errorf("cannot start server: %w", err)
 	}
 
-	err = grpcServer.Serve(listener)
-	if err != nil {
-		log.Fatal("não foi possivel inicar o servidor", err)
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- grpcServer.Serve(listener)
+	}()
+
+	// the gRPC-Web calls are served by the same gRPC server, through the same interceptors
+	var grpcWebServer *http.Server
+	if cfg.Server.GRPCWeb.Port != 0 {
+		grpcWebHandler := web.NewGRPCWebHandler(grpcServer, cfg.Server.GRPCWeb.AllowedOrigins)
+		grpcWebServer = serveGRPCWeb(cfg.Server.GRPCWeb.Port, grpcWebHandler, cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+	}
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("cannot start server: %w", err)
+	case <-ctx.Done():
+	}
+
+	// a second signal kills the server without waiting
+	stop()
+	slog.Info("shutting down server", "drain_timeout", cfg.Server.DrainTimeout)
+
+	// the server stops being healthy first, so that load balancers stop sending new calls
+	healthChecker.Shutdown()
+
+	// the WatchLaptops streams never end by themselves, they are ended here; the events of another server
+	// have another epoch, so the watchers start over there instead of resuming
+	laptopEvents.Close()
+
+	// the gRPC-Web calls are not tracked by the gRPC server, so they are drained with the HTTP server
+	webStopped := make(chan struct{})
+	go func() {
+		defer close(webStopped)
+		if grpcWebServer == nil {
+			return
+		}
+
+		drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.DrainTimeout)
+		defer cancel()
+
+		err := grpcWebServer.Shutdown(drainCtx)
+		if err != nil {
+			slog.Warn("cannot drain gRPC-Web calls", "error", err)
+			grpcWebServer.Close()
+		}
+	}()
+
+	gracefulStop(grpcServer, cfg.Server.DrainTimeout)
+	<-webStopped
+
+	// the deliveries still waiting for a retry are abandoned
+	webhookDispatcher.Close()
+
+	if metricsServer != nil {
+		err = metricsServer.Shutdown(context.Background())
+		if err != nil {
+			slog.Error("cannot stop metrics server", "error", err)
+		}
 	}
+
+	slog.Info("server stopped")
+	return nil
 }
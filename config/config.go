@@ -0,0 +1,395 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pcbook-go/logging"
+	"github.com/pcbook-go/tracing"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix prefixes the environment variables overriding the configuration,
+// e.g. PCBOOK_AUTH_JWT_SECRET overrides auth.jwt_secret
+const EnvPrefix = "PCBOOK_"
+
+// Config is the configuration of the server
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Log      LogConfig      `yaml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Stores   StoresConfig   `yaml:"stores"`
+	Limits   LimitsConfig   `yaml:"limits"`
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+	// Users are the users created on startup, if they do not exist yet. There are none by default.
+	Users []UserConfig `yaml:"users"`
+}
+
+// ServerConfig configures the listeners of the server
+type ServerConfig struct {
+	Port                int           `yaml:"port"`
+	MetricsPort         int           `yaml:"metrics_port"`
+	DrainTimeout        time.Duration `yaml:"drain_timeout"`
+	HealthCheckInterval time.Duration `yaml:"health_check_interval"`
+	TLS                 TLSConfig     `yaml:"tls"`
+	GRPCWeb             GRPCWebConfig `yaml:"grpc_web"`
+}
+
+// TLSConfig enables TLS if CertFile is set, and mutual TLS if ClientCAFile is set too
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+	// TrustedProxies are the common names of the client certificates of the proxies, like the REST gateway,
+	// allowed to forward the address of their clients. It requires mutual TLS.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// GRPCWebConfig configures the HTTP listener serving the gRPC-Web calls of the browsers
+type GRPCWebConfig struct {
+	// Port is disabled if 0
+	Port int `yaml:"port"`
+	// AllowedOrigins are the websites allowed to call the server, "*" allows any website
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// LogConfig configures the logs of the server
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// TracingConfig configures the export of the traces
+type TracingConfig struct {
+	Exporter string `yaml:"exporter"`
+	File     string `yaml:"file"`
+}
+
+// AuthConfig configures the tokens, the passwords and the access control policy
+type AuthConfig struct {
+	// JWTSecret signs the tokens with HS256, unless JWTSigningKeyFile is set.
+	// It has no default, anyone knowing it could forge the tokens of any user.
+	JWTSecret               string        `yaml:"jwt_secret" secret:"true"`
+	JWTSigningKeyFile       string        `yaml:"jwt_signing_key_file"`
+	JWTVerificationKeyFiles []string      `yaml:"jwt_verification_key_files"`
+	TokenDuration           time.Duration `yaml:"token_duration"`
+	RefreshTokenDuration    time.Duration `yaml:"refresh_token_duration"`
+	ResetTokenDuration      time.Duration `yaml:"reset_token_duration"`
+	PolicyFile              string        `yaml:"policy_file"`
+	PasswordHash            string        `yaml:"password_hash"`
+	BcryptCost              int           `yaml:"bcrypt_cost"`
+	PasswordDenyListFile    string        `yaml:"password_deny_list_file"`
+	Login                   LoginConfig   `yaml:"login"`
+	// OrganizationSharing lets the users of the owner's organization modify the laptops too,
+	// otherwise only the owner or a superadmin can
+	OrganizationSharing bool `yaml:"organization_sharing"`
+}
+
+// LoginConfig configures the backoff and the lockout after failed logins
+type LoginConfig struct {
+	MaxFailures     int           `yaml:"max_failures"`
+	BackoffDelay    time.Duration `yaml:"backoff_delay"`
+	MaxBackoff      time.Duration `yaml:"max_backoff"`
+	LockoutDuration time.Duration `yaml:"lockout_duration"`
+}
+
+// StoresConfig configures where the data of the server is kept
+type StoresConfig struct {
+	// UsersFile persists the users, they are kept in memory if it is empty
+	UsersFile   string `yaml:"users_file"`
+	ImageFolder string `yaml:"image_folder"`
+	AuditLog    string `yaml:"audit_log"`
+}
+
+// LimitsConfig configures the limits of the calls
+type LimitsConfig struct {
+	RateLimitsFile string `yaml:"rate_limits_file"`
+	MaxImageSize   int    `yaml:"max_image_size"`
+	// LaptopEventHistory is the number of laptop events kept for the watchers resuming after a reconnection
+	LaptopEventHistory int `yaml:"laptop_event_history"`
+	// MaxImportLaptops is the maximum number of laptops of an ImportLaptops stream
+	MaxImportLaptops int `yaml:"max_import_laptops"`
+}
+
+// WebhooksConfig configures the deliveries of the webhooks
+type WebhooksConfig struct {
+	// MaxAttempts is the number of attempts of each delivery before it fails
+	MaxAttempts    int           `yaml:"max_attempts"`
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// Timeout bounds each attempt
+	Timeout time.Duration `yaml:"timeout"`
+	// Workers is the number of deliveries posted at the same time
+	Workers int `yaml:"workers"`
+	// QueueSize is the number of deliveries waiting for a worker, the next ones fail
+	QueueSize int `yaml:"queue_size"`
+}
+
+// UserConfig is a user created on startup
+type UserConfig struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password" secret:"true"`
+	Role         string `yaml:"role"`
+	Organization string `yaml:"organization"`
+}
+
+// Default returns the configuration used when neither the file nor the environment set a field
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:                8080,
+			DrainTimeout:        30 * time.Second,
+			HealthCheckInterval: 10 * time.Second,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: logging.TextFormat,
+		},
+		Tracing: TracingConfig{
+			Exporter: tracing.NoExporter,
+			File:     "traces.json",
+		},
+		Auth: AuthConfig{
+			TokenDuration:        15 * time.Minute,
+			RefreshTokenDuration: 7 * 24 * time.Hour,
+			ResetTokenDuration:   time.Hour,
+			PolicyFile:           "policy.yaml",
+			PasswordHash:         "bcrypt",
+			BcryptCost:           bcrypt.DefaultCost,
+			Login: LoginConfig{
+				MaxFailures:     5,
+				BackoffDelay:    time.Second,
+				MaxBackoff:      30 * time.Second,
+				LockoutDuration: 15 * time.Minute,
+			},
+		},
+		Stores: StoresConfig{
+			ImageFolder: "img",
+			AuditLog:    "audit.log",
+		},
+		Limits: LimitsConfig{
+			RateLimitsFile:     "rate_limits.yaml",
+			MaxImageSize:       1 << 20,
+			LaptopEventHistory: 1000,
+			MaxImportLaptops:   50000,
+		},
+		Webhooks: WebhooksConfig{
+			MaxAttempts:    5,
+			InitialBackoff: time.Second,
+			MaxBackoff:     time.Minute,
+			Timeout:        10 * time.Second,
+			Workers:        8,
+			QueueSize:      1000,
+		},
+	}
+}
+
+// Load returns the default configuration, overridden by the YAML file if filename is not empty,
+// then by the environment variables found by lookupEnv, usually os.LookupEnv.
+// The configuration is not validated, so that the caller can still override it.
+func Load(filename string, lookupEnv func(key string) (string, bool)) (*Config, error) {
+	config := Default()
+
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config file: %w", err)
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		err = decoder.Decode(config)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("cannot parse config file %s: %w", filename, err)
+		}
+	}
+
+	err := config.applyEnv(lookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// EnvName returns the environment variable overriding the field at path, like "server.tls.cert_file"
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// applyEnv overrides the fields which have an environment variable set.
+// The users can only be set by the file.
+func (config *Config) applyEnv(lookupEnv func(key string) (string, bool)) error {
+	var errs []error
+
+	walkFields(reflect.ValueOf(config).Elem(), "", func(path string, value reflect.Value) {
+		name := EnvName(path)
+		text, ok := lookupEnv(name)
+		if !ok {
+			return
+		}
+
+		err := setField(value, text)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+
+	return errors.Join(errs...)
+}
+
+// Set overrides the field at path, like "server.port", parsing value as the environment variables are parsed
+func (config *Config) Set(path string, value string) error {
+	var field reflect.Value
+
+	walkFields(reflect.ValueOf(config).Elem(), "", func(fieldPath string, value reflect.Value) {
+		if fieldPath == path {
+			field = value
+		}
+	})
+
+	if !field.IsValid() {
+		return fmt.Errorf("unknown config field: %s", path)
+	}
+
+	err := setField(field, value)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// walkFields calls visit with the path of each field of the struct which can be set from a string,
+// skipping the lists of structs like the users
+func walkFields(value reflect.Value, prefix string, visit func(path string, value reflect.Value)) {
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		path := yamlName(field)
+		if prefix != "" {
+			path = prefix + "." + path
+		}
+
+		fieldValue := value.Field(i)
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			walkFields(fieldValue, path, visit)
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() != reflect.String:
+			continue
+		default:
+			visit(path, fieldValue)
+		}
+	}
+}
+
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+
+	return name
+}
+
+// setField parses text according to the type of the field:
+// durations like "15m", numbers, booleans and comma-separated lists
+func setField(field reflect.Value, text string) error {
+	switch {
+	case field.Type() == durationType:
+		duration, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+	case field.Kind() == reflect.String:
+		field.SetString(text)
+	case field.Kind() == reflect.Int || field.Kind() == reflect.Int64:
+		number, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number: %s", text)
+		}
+		field.SetInt(number)
+	case field.Kind() == reflect.Bool:
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("invalid boolean: %s", text)
+		}
+		field.SetBool(value)
+	case field.Kind() == reflect.Slice:
+		var values []string
+		for _, value := range strings.Split(text, ",") {
+			value = strings.TrimSpace(value)
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported field type: %s", field.Type())
+	}
+
+	return nil
+}
+
+// redacted replaces the secret fields, like the JWT secret and the passwords, in the logs
+const redacted = "[REDACTED]"
+
+// LogValue logs the configuration as groups named after the YAML keys, with the secret fields redacted
+func (config *Config) LogValue() slog.Value {
+	attrs := structAttrs(reflect.ValueOf(config).Elem())
+
+	users := make([]slog.Attr, 0, len(config.Users))
+	for i := range config.Users {
+		users = append(users, slog.Attr{
+			Key:   strconv.Itoa(i),
+			Value: slog.GroupValue(structAttrs(reflect.ValueOf(config.Users[i]))...),
+		})
+	}
+	attrs = append(attrs, slog.Attr{Key: "users", Value: slog.GroupValue(users...)})
+
+	return slog.GroupValue(attrs...)
+}
+
+// structAttrs returns an attribute for each field of the struct which can be set from a string
+func structAttrs(value reflect.Value) []slog.Attr {
+	var attrs []slog.Attr
+	valueType := value.Type()
+
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		fieldValue := value.Field(i)
+		key := yamlName(field)
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			attrs = append(attrs, slog.Attr{Key: key, Value: slog.GroupValue(structAttrs(fieldValue)...)})
+		case field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() != reflect.String:
+			continue
+		case field.Tag.Get("secret") == "true" && !fieldValue.IsZero():
+			attrs = append(attrs, slog.String(key, redacted))
+		case field.Type == durationType:
+			attrs = append(attrs, slog.String(key, fieldValue.Interface().(time.Duration).String()))
+		case field.Type.Kind() == reflect.Slice:
+			attrs = append(attrs, slog.String(key, strings.Join(fieldValue.Interface().([]string), ",")))
+		default:
+			attrs = append(attrs, slog.Any(key, fieldValue.Interface()))
+		}
+	}
+
+	return attrs
+}
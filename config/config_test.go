@@ -0,0 +1,224 @@
+package config_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/config"
+	"github.com/pcbook-go/logging"
+	"github.com/stretchr/testify/require"
+)
+
+// env returns a lookup function reading the environment variables from a map
+func env(variables map[string]string) func(key string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := variables[key]
+		return value, ok
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	filename := filepath.Join(t.TempDir(), "server.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(content), 0o600))
+	return filename
+}
+
+func TestSampleConfig(t *testing.T) {
+	t.Parallel()
+
+	// o arquivo de exemplo documenta os valores padrão, o segredo é dado pelo ambiente
+	cfg, err := config.Load("../server.yaml", env(nil))
+	require.NoError(t, err)
+	require.ErrorContains(t, cfg.Validate(), "auth.jwt_secret:")
+
+	cfg, err = config.Load("../server.yaml", env(map[string]string{"PCBOOK_AUTH_JWT_SECRET": "env-secret"}))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	expected := config.Default()
+	expected.Server.MetricsPort = 9090
+	expected.Server.GRPCWeb = config.GRPCWebConfig{Port: 8082, AllowedOrigins: []string{"http://localhost:3000"}}
+	expected.Auth.JWTSecret = "env-secret"
+	expected.Server.TLS.TrustedProxies = []string{}
+	expected.Auth.JWTVerificationKeyFiles = []string{}
+	expected.Users = []config.UserConfig{
+		{Username: "admin1", Password: "Admin-Secret1", Role: "admin", Organization: "pcbook"},
+		{Username: "user1", Password: "User-Secret1", Role: "user", Organization: "pcbook"},
+	}
+	require.Equal(t, expected, cfg)
+
+	// nenhum superadmin é criado com uma senha publicada
+	for _, user := range cfg.Users {
+		require.NotEqual(t, "superadmin", user.Role)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	filename := writeConfig(t, `
+server:
+  port: 9000
+  tls:
+    cert_file: cert.pem
+    key_file: key.pem
+auth:
+  token_duration: 5m
+  login:
+    max_failures: 3
+users:
+  - username: admin2
+    password: Admin-Secret2
+    role: admin
+`)
+
+	cfg, err := config.Load(filename, env(map[string]string{
+		"PCBOOK_SERVER_PORT":                     "9001",
+		"PCBOOK_AUTH_JWT_SECRET":                 "env-secret",
+		"PCBOOK_AUTH_JWT_VERIFICATION_KEY_FILES": "old1.pem, old2.pem",
+		"PCBOOK_LIMITS_MAX_IMAGE_SIZE":           "2048",
+	}))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	// o ambiente sobrescreve o arquivo, que sobrescreve os valores padrão
+	require.Equal(t, 9001, cfg.Server.Port)
+	require.Equal(t, "cert.pem", cfg.Server.TLS.CertFile)
+	require.Equal(t, 5*time.Minute, cfg.Auth.TokenDuration)
+	require.Equal(t, 3, cfg.Auth.Login.MaxFailures)
+	require.Equal(t, 15*time.Minute, cfg.Auth.Login.LockoutDuration)
+	require.Equal(t, "env-secret", cfg.Auth.JWTSecret)
+	require.Equal(t, []string{"old1.pem", "old2.pem"}, cfg.Auth.JWTVerificationKeyFiles)
+	require.Equal(t, 2048, cfg.Limits.MaxImageSize)
+	require.Equal(t, []config.UserConfig{{Username: "admin2", Password: "Admin-Secret2", Role: "admin"}}, cfg.Users)
+
+	require.NoError(t, cfg.Set("auth.login.backoff_delay", "2s"))
+	require.Equal(t, 2*time.Second, cfg.Auth.Login.BackoffDelay)
+	require.Error(t, cfg.Set("auth.unknown", "1"))
+	require.Error(t, cfg.Set("server.port", "http"))
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := config.Load(filepath.Join(t.TempDir(), "missing.yaml"), env(nil))
+	require.Error(t, err)
+
+	// um campo desconhecido é um erro, e não é ignorado
+	_, err = config.Load(writeConfig(t, "server:\n  prot: 8080\n"), env(nil))
+	require.ErrorContains(t, err, "prot")
+
+	_, err = config.Load("", env(map[string]string{"PCBOOK_SERVER_DRAIN_TIMEOUT": "30"}))
+	require.ErrorContains(t, err, "PCBOOK_SERVER_DRAIN_TIMEOUT")
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	// o segredo não tem valor padrão
+	require.ErrorContains(t, config.Default().Validate(), "auth.jwt_secret:")
+
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "env-secret"
+	require.NoError(t, cfg.Validate())
+	require.Empty(t, cfg.Users)
+
+	// o segredo dos exemplos é recusado
+	cfg.Auth.JWTSecret = "secret"
+	require.ErrorContains(t, cfg.Validate(), "auth.jwt_secret:")
+
+	// uma chave de assinatura dispensa o segredo
+	cfg.Auth.JWTSecret = ""
+	cfg.Auth.JWTSigningKeyFile = "jwt-key.pem"
+	require.NoError(t, cfg.Validate())
+
+	// os proxies confiáveis são identificados pelo certificado de cliente
+	cfg.Server.TLS.TrustedProxies = []string{"gateway"}
+	require.ErrorContains(t, cfg.Validate(), "server.tls.trusted_proxies:")
+	cfg.Server.TLS = config.TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", ClientCAFile: "ca.pem", TrustedProxies: []string{"gateway"}}
+	require.NoError(t, cfg.Validate())
+
+	cfg = config.Default()
+	cfg.Auth.JWTSecret = "env-secret"
+	cfg.Server.Port = 70000
+	cfg.Server.TLS.KeyFile = "key.pem"
+	cfg.Log.Format = "xml"
+	cfg.Auth.RefreshTokenDuration = time.Minute
+	cfg.Auth.PasswordHash = "md5"
+	cfg.Limits.MaxImageSize = 0
+	cfg.Auth.Login.MaxFailures = 0
+	cfg.Users = []config.UserConfig{
+		{Username: "user1", Password: "User-Secret1", Role: "user"},
+		{Username: "user1", Password: "User-Secret2"},
+	}
+
+	err := cfg.Validate()
+	var validationErr *config.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+
+	// todos os campos inválidos são reportados de uma vez
+	for _, field := range []string{
+		"server.port",
+		"server.tls.cert_file",
+		"log.format",
+		"auth.refresh_token_duration",
+		"auth.password_hash",
+		"limits.max_image_size",
+		"auth.login.max_failures",
+		"users[1].username",
+		"users[1].role",
+	} {
+		require.ErrorContains(t, err, field+":")
+	}
+	require.Len(t, validationErr.Errors, 9)
+}
+
+func TestValidateRoles(t *testing.T) {
+	t.Parallel()
+
+	roles := map[string]bool{"user": true, "admin": true}
+	isRole := func(role string) bool { return roles[role] }
+
+	cfg := config.Default()
+	cfg.Users = []config.UserConfig{
+		{Username: "admin1", Password: "Admin-Secret1", Role: "admin"},
+		{Username: "root", Password: "Root-Secret1", Role: "superadmin"},
+	}
+
+	// os papéis dos usuários precisam existir na política
+	err := cfg.ValidateRoles(isRole)
+	var validationErr *config.ValidationError
+	require.True(t, errors.As(err, &validationErr))
+	require.Len(t, validationErr.Errors, 1)
+	require.ErrorContains(t, err, "users[1].role:")
+
+	roles["superadmin"] = true
+	require.NoError(t, cfg.ValidateRoles(isRole))
+}
+
+func TestLogValueRedaction(t *testing.T) {
+	t.Parallel()
+
+	var output bytes.Buffer
+	logger, err := logging.New(&output, logging.JSONFormat, slog.LevelInfo)
+	require.NoError(t, err)
+
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "very-secret-key"
+	cfg.Users = []config.UserConfig{{Username: "user1", Password: "User-Secret1", Role: "user"}}
+	logger.Info("effective config", "config", cfg)
+
+	// as senhas e o segredo nunca são escritos, mas os outros campos são
+	logs := output.String()
+	require.NotContains(t, logs, "very-secret-key")
+	require.NotContains(t, logs, "User-Secret1")
+	require.Contains(t, logs, `"jwt_secret":"[REDACTED]"`)
+	require.Contains(t, logs, `"username":"user1"`)
+	require.Contains(t, logs, `"token_duration":"15m0s"`)
+	require.Contains(t, logs, `"image_folder":"img"`)
+}
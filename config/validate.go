@@ -0,0 +1,233 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pcbook-go/logging"
+	"github.com/pcbook-go/tracing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ValidationError lists the invalid fields of a configuration
+type ValidationError struct {
+	Errors []error
+}
+
+func (err *ValidationError) Error() string {
+	messages := make([]string, 0, len(err.Errors))
+	for _, fieldErr := range err.Errors {
+		messages = append(messages, fieldErr.Error())
+	}
+
+	return "invalid config: " + strings.Join(messages, "; ")
+}
+
+func (err *ValidationError) Unwrap() []error {
+	return err.Errors
+}
+
+// wellKnownJWTSecrets are the secrets published in the examples, which must never sign real tokens
+var wellKnownJWTSecrets = map[string]bool{
+	"secret": true,
+}
+
+// Validate checks the configuration, returning a ValidationError with an error for each invalid field
+func (config *Config) Validate() error {
+	var errs []error
+	invalid := func(path string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+	}
+
+	server := config.Server
+	if server.Port < 0 || server.Port > 65535 {
+		invalid("server.port", "must be between 0 and 65535, got %d", server.Port)
+	}
+	if server.MetricsPort < 0 || server.MetricsPort > 65535 {
+		invalid("server.metrics_port", "must be between 0 and 65535, got %d", server.MetricsPort)
+	}
+	if server.MetricsPort != 0 && server.MetricsPort == server.Port {
+		invalid("server.metrics_port", "must differ from server.port")
+	}
+	if server.GRPCWeb.Port < 0 || server.GRPCWeb.Port > 65535 {
+		invalid("server.grpc_web.port", "must be between 0 and 65535, got %d", server.GRPCWeb.Port)
+	}
+	if server.GRPCWeb.Port != 0 && (server.GRPCWeb.Port == server.Port || server.GRPCWeb.Port == server.MetricsPort) {
+		invalid("server.grpc_web.port", "must differ from server.port and server.metrics_port")
+	}
+	for i, origin := range server.GRPCWeb.AllowedOrigins {
+		if origin == "" {
+			invalid(fmt.Sprintf("server.grpc_web.allowed_origins[%d]", i), "must not be empty")
+		}
+	}
+	if server.DrainTimeout <= 0 {
+		invalid("server.drain_timeout", "must be positive")
+	}
+	if server.HealthCheckInterval <= 0 {
+		invalid("server.health_check_interval", "must be positive")
+	}
+	if server.TLS.CertFile != "" && server.TLS.KeyFile == "" {
+		invalid("server.tls.key_file", "is required with server.tls.cert_file")
+	}
+	if server.TLS.CertFile == "" && (server.TLS.KeyFile != "" || server.TLS.ClientCAFile != "") {
+		invalid("server.tls.cert_file", "is required with server.tls.key_file and server.tls.client_ca_file")
+	}
+	if len(server.TLS.TrustedProxies) > 0 && server.TLS.ClientCAFile == "" {
+		invalid("server.tls.trusted_proxies", "requires mutual TLS, set server.tls.client_ca_file")
+	}
+
+	_, err := logging.ParseLevel(config.Log.Level)
+	if err != nil {
+		invalid("log.level", "must be debug, info, warn or error, got %q", config.Log.Level)
+	}
+	if config.Log.Format != logging.TextFormat && config.Log.Format != logging.JSONFormat {
+		invalid("log.format", "must be text or json, got %q", config.Log.Format)
+	}
+
+	switch config.Tracing.Exporter {
+	case tracing.NoExporter, tracing.StdoutExporter:
+	case tracing.FileExporter:
+		if config.Tracing.File == "" {
+			invalid("tracing.file", "is required with the file exporter")
+		}
+	default:
+		invalid("tracing.exporter", "must be none, stdout or file, got %q", config.Tracing.Exporter)
+	}
+
+	config.Auth.validate(invalid)
+	config.validateStoresAndLimits(invalid)
+	config.Webhooks.validate(invalid)
+	config.validateUsers(invalid)
+
+	if len(errs) > 0 {
+		return &ValidationError{errs}
+	}
+
+	return nil
+}
+
+func (auth *AuthConfig) validate(invalid func(path string, format string, args ...any)) {
+	if auth.JWTSecret == "" && auth.JWTSigningKeyFile == "" {
+		invalid("auth.jwt_secret", "is required without auth.jwt_signing_key_file")
+	}
+	if wellKnownJWTSecrets[auth.JWTSecret] {
+		invalid("auth.jwt_secret", "must not be the well-known secret %q, set a random secret", auth.JWTSecret)
+	}
+	if auth.TokenDuration <= 0 {
+		invalid("auth.token_duration", "must be positive")
+	}
+	if auth.RefreshTokenDuration <= auth.TokenDuration {
+		invalid("auth.refresh_token_duration", "must be longer than auth.token_duration")
+	}
+	if auth.ResetTokenDuration <= 0 {
+		invalid("auth.reset_token_duration", "must be positive")
+	}
+	if auth.PolicyFile == "" {
+		invalid("auth.policy_file", "is required")
+	}
+
+	switch auth.PasswordHash {
+	case "bcrypt":
+		if auth.BcryptCost < bcrypt.MinCost || auth.BcryptCost > bcrypt.MaxCost {
+			invalid("auth.bcrypt_cost", "must be between %d and %d, got %d", bcrypt.MinCost, bcrypt.MaxCost, auth.BcryptCost)
+		}
+	case "argon2id":
+	default:
+		invalid("auth.password_hash", "must be bcrypt or argon2id, got %q", auth.PasswordHash)
+	}
+
+	login := auth.Login
+	if login.MaxFailures <= 0 {
+		invalid("auth.login.max_failures", "must be positive")
+	}
+	if login.BackoffDelay < 0 {
+		invalid("auth.login.backoff_delay", "must not be negative")
+	}
+	if login.MaxBackoff < login.BackoffDelay {
+		invalid("auth.login.max_backoff", "must not be shorter than auth.login.backoff_delay")
+	}
+	if login.LockoutDuration < 0 {
+		invalid("auth.login.lockout_duration", "must not be negative")
+	}
+}
+
+func (config *Config) validateStoresAndLimits(invalid func(path string, format string, args ...any)) {
+	if config.Stores.ImageFolder == "" {
+		invalid("stores.image_folder", "is required")
+	}
+	if config.Stores.AuditLog == "" {
+		invalid("stores.audit_log", "is required")
+	}
+	if config.Limits.RateLimitsFile == "" {
+		invalid("limits.rate_limits_file", "is required")
+	}
+	if config.Limits.MaxImageSize <= 0 {
+		invalid("limits.max_image_size", "must be positive")
+	}
+	if config.Limits.LaptopEventHistory <= 0 {
+		invalid("limits.laptop_event_history", "must be positive")
+	}
+	if config.Limits.MaxImportLaptops <= 0 {
+		invalid("limits.max_import_laptops", "must be positive")
+	}
+}
+
+func (webhooks *WebhooksConfig) validate(invalid func(path string, format string, args ...any)) {
+	if webhooks.MaxAttempts <= 0 {
+		invalid("webhooks.max_attempts", "must be positive")
+	}
+	if webhooks.InitialBackoff <= 0 {
+		invalid("webhooks.initial_backoff", "must be positive")
+	}
+	if webhooks.MaxBackoff < webhooks.InitialBackoff {
+		invalid("webhooks.max_backoff", "must not be shorter than the initial backoff")
+	}
+	if webhooks.Timeout <= 0 {
+		invalid("webhooks.timeout", "must be positive")
+	}
+	if webhooks.Workers <= 0 {
+		invalid("webhooks.workers", "must be positive")
+	}
+	if webhooks.QueueSize <= 0 {
+		invalid("webhooks.queue_size", "must be positive")
+	}
+}
+
+func (config *Config) validateUsers(invalid func(path string, format string, args ...any)) {
+	usernames := make(map[string]bool)
+
+	for i, user := range config.Users {
+		path := fmt.Sprintf("users[%d]", i)
+
+		if user.Username == "" {
+			invalid(path+".username", "is required")
+		} else if usernames[user.Username] {
+			invalid(path+".username", "duplicate user %q", user.Username)
+		}
+		usernames[user.Username] = true
+
+		if user.Password == "" {
+			invalid(path+".password", "is required")
+		}
+		if user.Role == "" {
+			invalid(path+".role", "is required")
+		}
+	}
+}
+
+// ValidateRoles checks that the roles of the users are defined by the access control policy,
+// which is loaded after the configuration. It returns a ValidationError like Validate.
+func (config *Config) ValidateRoles(isRole func(role string) bool) error {
+	var errs []error
+	for i, user := range config.Users {
+		if user.Role != "" && !isRole(user.Role) {
+			errs = append(errs, fmt.Errorf("users[%d].role: unknown role %q in the policy", i, user.Role))
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{errs}
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+// Package gateway exposes the LaptopService as a REST/JSON API for the clients that cannot speak gRPC,
+// like browsers. Each HTTP request is forwarded to the gRPC server with its credentials and the address
+// of its client, so that the server interceptors authenticate, authorize and limit it as any other call.
+// The server only trusts the address if the gateway is one of its trusted proxies, authenticated
+// by its client certificate: otherwise all the anonymous requests share the address of the gateway.
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pcbook-go/logging"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/serializer"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// maxJSONBodySize caps the JSON bodies of the requests, the images are streamed instead
+const maxJSONBodySize = 1 << 20
+
+// Gateway is an HTTP handler translating the REST routes to LaptopService calls:
+//
+//	POST /v1/laptops                     CreateLaptop, the body is a laptop
+//	GET  /v1/laptops?max_price_usd=...   SearchLaptop, as paged JSON or NDJSON
+//	GET  /v1/laptops/{id}                FindLaptop
+//	POST /v1/laptops/{id}/images         UploadImage, the image is the "image" field of a multipart form
+//	POST /v1/laptops/{id}/ratings        RateLaptop, the body is {"score": 9}
+//	GET  /v1/images/{id}                 DownloadImage
+type Gateway struct {
+	client pb.LaptopServiceClient
+	mux    *http.ServeMux
+}
+
+// New returns a new gateway calling the laptop service with client
+func New(client pb.LaptopServiceClient) *Gateway {
+	gateway := &Gateway{
+		client: client,
+		mux:    http.NewServeMux(),
+	}
+
+	gateway.mux.HandleFunc("/v1/laptops", gateway.handleLaptops)
+	gateway.mux.HandleFunc("/v1/laptops/", gateway.handleLaptop)
+	gateway.mux.HandleFunc("/v1/images/", gateway.handleImage)
+
+	return gateway
+}
+
+func (gateway *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gateway.mux.ServeHTTP(w, r)
+}
+
+// handleLaptops serves /v1/laptops
+func (gateway *Gateway) handleLaptops(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		gateway.createLaptop(w, r)
+	case http.MethodGet:
+		gateway.searchLaptop(w, r)
+	default:
+		methodNotAllowed(w, http.MethodGet, http.MethodPost)
+	}
+}
+
+// handleLaptop serves /v1/laptops/{id} and its images and ratings
+func (gateway *Gateway) handleLaptop(w http.ResponseWriter, r *http.Request) {
+	laptopID, resource, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/laptops/"), "/")
+	if laptopID == "" {
+		writeError(w, status.Error(codes.NotFound, "route not found"))
+		return
+	}
+
+	switch resource {
+	case "":
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		gateway.findLaptop(w, r, laptopID)
+	case "images":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		gateway.uploadImage(w, r, laptopID)
+	case "ratings":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		gateway.rateLaptop(w, r, laptopID)
+	default:
+		writeError(w, status.Error(codes.NotFound, "route not found"))
+	}
+}
+
+// handleImage serves /v1/images/{id}
+func (gateway *Gateway) handleImage(w http.ResponseWriter, r *http.Request) {
+	imageID := strings.TrimPrefix(r.URL.Path, "/v1/images/")
+	if imageID == "" || strings.Contains(imageID, "/") {
+		writeError(w, status.Error(codes.NotFound, "route not found"))
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+
+	gateway.downloadImage(w, r, imageID)
+}
+
+func (gateway *Gateway) createLaptop(w http.ResponseWriter, r *http.Request) {
+	laptop := &pb.Laptop{}
+	err := readJSON(r, laptop)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, err := outgoingContext(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := gateway.client.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, res)
+}
+
+func (gateway *Gateway) findLaptop(w http.ResponseWriter, r *http.Request, laptopID string) {
+	ctx, err := outgoingContext(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	res, err := gateway.client.FindLaptop(ctx, &pb.FindLaptopRequest{Id: laptopID})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, res.GetLaptop())
+}
+
+func (gateway *Gateway) rateLaptop(w http.ResponseWriter, r *http.Request, laptopID string) {
+	req := &pb.RateLaptopRequest{}
+	err := readJSON(r, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	req.LaptopId = laptopID
+
+	ctx, err := outgoingContext(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	stream, err := gateway.client.RateLaptop(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = stream.Send(req)
+	if err != nil {
+		// the error of the call is returned by Recv
+		_, err = stream.Recv()
+		writeError(w, err)
+		return
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_ = stream.CloseSend()
+	writeJSON(w, http.StatusOK, res)
+}
+
+// forwardedForHeader is the metadata carrying the address of the client, read by the ForwardedPeerInterceptor of the server
+const forwardedForHeader = "x-forwarded-for"
+
+// outgoingContext returns the context of the gRPC call, carrying the credentials, the client address and the request ID of r.
+// The address is the peer of the gateway: the X-Forwarded-For header of the request is ignored, anyone could set it.
+func outgoingContext(r *http.Request) (context.Context, error) {
+	md := metadata.MD{}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err == nil {
+		md.Set(forwardedForHeader, host)
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if authorization != "" {
+		scheme, token, ok := strings.Cut(authorization, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return nil, status.Error(codes.Unauthenticated, "authorization header must be a bearer token")
+		}
+
+		md.Set("authorization", token)
+	}
+
+	apiKey := r.Header.Get("X-Api-Key")
+	if apiKey != "" {
+		md.Set("x-api-key", apiKey)
+	}
+
+	ctx := r.Context()
+	requestID := r.Header.Get("X-Request-Id")
+	if requestID != "" {
+		ctx = logging.ContextWithRequestID(ctx, requestID)
+		md.Set(logging.RequestIDHeader, requestID)
+	}
+
+	return metadata.NewOutgoingContext(ctx, md), nil
+}
+
+// readJSON decodes the JSON body of r into message, following the serializer conventions
+func readJSON(r *http.Request, message proto.Message) error {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxJSONBodySize+1))
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "cannot read body: %v", err)
+	}
+
+	if len(data) > maxJSONBodySize {
+		return status.Errorf(codes.InvalidArgument, "body is too large: more than %d bytes", maxJSONBodySize)
+	}
+
+	err = serializer.JSONToProtobufMessage(string(data), message)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid JSON body: %v", err)
+	}
+
+	return nil
+}
+
+// writeJSON writes message as the JSON body of the response, following the serializer conventions
+func writeJSON(w http.ResponseWriter, code int, message proto.Message) {
+	data, err := serializer.JSONMarshaler().MarshalToString(message)
+	if err != nil {
+		writeError(w, status.Errorf(codes.Internal, "cannot serialize response: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = io.WriteString(w, data+"\n")
+}
+
+// errorBody is the JSON body of the error responses
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Reason is the machine-readable reason of the ErrorInfo detail, if the error has one
+	Reason string `json:"reason,omitempty"`
+}
+
+// writeError writes the gRPC status of err as the response, with the matching HTTP status code.
+// The RetryInfo detail, if any, is sent as the Retry-After header.
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	if errors.Is(err, context.Canceled) {
+		st = status.New(codes.Canceled, err.Error())
+	}
+
+	body := errorBody{
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	}
+
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			body.Reason = detail.GetReason()
+		case *errdetails.RetryInfo:
+			seconds := int64(math.Ceil(detail.GetRetryDelay().AsDuration().Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(HTTPStatusFromCode(st.Code()))
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	_ = json.NewEncoder(w).Encode(errorBody{
+		Code:    codes.Unimplemented.String(),
+		Message: "method not allowed",
+	})
+}
+
+// HTTPStatusFromCode returns the HTTP status code of a gRPC status code
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,310 @@
+package gateway_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/gateway"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/serializer"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testGateway is a gateway in front of a gRPC server authenticating the calls with the policy of the repository
+type testGateway struct {
+	url        string
+	jwtManager *service.JWTManager
+}
+
+func startTestGateway(t *testing.T) *testGateway {
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	interceptor := service.NewAuthInterceptor(jwtManager, nil, policy)
+	laptopServer := service.NewLaptopServer(
+		service.NewInMemoryLaptopStore(),
+		service.NewDiskIMageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+	)
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	httpServer := httptest.NewServer(gateway.New(pb.NewLaptopServiceClient(conn)))
+	t.Cleanup(httpServer.Close)
+
+	return &testGateway{
+		url:        httpServer.URL,
+		jwtManager: jwtManager,
+	}
+}
+
+// token returns an access token of a new user, with the second factor required from the admins
+func (gw *testGateway) token(t *testing.T, username string, role string) string {
+	user, err := service.NewUser(username, "secret", role)
+	require.NoError(t, err)
+
+	token, err := gw.jwtManager.GenerateMFA(user)
+	require.NoError(t, err)
+	return token
+}
+
+func (gw *testGateway) do(t *testing.T, method string, path string, token string, contentType string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(method, gw.url+path, body)
+	require.NoError(t, err)
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { res.Body.Close() })
+
+	return res
+}
+
+func decodeJSON(t *testing.T, res *http.Response) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	return body
+}
+
+func (gw *testGateway) createLaptop(t *testing.T, token string) string {
+	data, err := serializer.ProtobufToJSON(sample.NewLaptop())
+	require.NoError(t, err)
+
+	res := gw.do(t, http.MethodPost, "/v1/laptops", token, "application/json", strings.NewReader(data))
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	return decodeJSON(t, res)["id"].(string)
+}
+
+func TestGatewayLaptops(t *testing.T) {
+	t.Parallel()
+
+	gw := startTestGateway(t)
+	adminToken := gw.token(t, "admin1", "admin")
+
+	// o token do cabeçalho Authorization é verificado pelo AuthInterceptor do servidor
+	data, err := serializer.ProtobufToJSON(sample.NewLaptop())
+	require.NoError(t, err)
+	res := gw.do(t, http.MethodPost, "/v1/laptops", "", "application/json", strings.NewReader(data))
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	require.Equal(t, "Unauthenticated", decodeJSON(t, res)["code"])
+
+	res = gw.do(t, http.MethodPost, "/v1/laptops", gw.token(t, "user1", "user"), "application/json", strings.NewReader(data))
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = gw.do(t, http.MethodPost, "/v1/laptops", adminToken, "application/json", strings.NewReader("{"))
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	laptopIDs := []string{gw.createLaptop(t, adminToken), gw.createLaptop(t, adminToken), gw.createLaptop(t, adminToken)}
+
+	// os campos usam os nomes originais e os enums são strings
+	res = gw.do(t, http.MethodGet, "/v1/laptops/"+laptopIDs[0], "", "", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	laptop := decodeJSON(t, res)
+	require.Equal(t, laptopIDs[0], laptop["id"])
+	require.Equal(t, "admin1", laptop["owner"])
+	require.Contains(t, laptop, "price_usd")
+	require.IsType(t, "", laptop["ram"].(map[string]interface{})["unit"])
+
+	res = gw.do(t, http.MethodGet, "/v1/laptops/unknown", "", "", nil)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	body := decodeJSON(t, res)
+	require.Equal(t, "NotFound", body["code"])
+	require.Equal(t, "LAPTOP_NOT_FOUND", body["reason"])
+
+	// as páginas percorrem todos os laptops, sem repetição
+	found := make(map[string]bool)
+	pageToken := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, 3)
+
+		res = gw.do(t, http.MethodGet, "/v1/laptops?page_size=2&min_ram_unit=bit&page_token="+pageToken, "", "", nil)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		var page struct {
+			Laptops       []map[string]interface{} `json:"laptops"`
+			NextPageToken string                   `json:"next_page_token"`
+		}
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&page))
+
+		for _, laptop := range page.Laptops {
+			id := laptop["id"].(string)
+			require.False(t, found[id])
+			found[id] = true
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	require.Len(t, found, len(laptopIDs))
+
+	req, err := http.NewRequest(http.MethodGet, gw.url+"/v1/laptops", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", gateway.NDJSONContentType)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, gateway.NDJSONContentType, res.Header.Get("Content-Type"))
+
+	lines := 0
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		other := &pb.Laptop{}
+		require.NoError(t, serializer.JSONToProtobufMessage(scanner.Text(), other))
+		require.Contains(t, laptopIDs, other.GetId())
+		lines++
+	}
+	require.Equal(t, len(laptopIDs), lines)
+
+	res = gw.do(t, http.MethodGet, "/v1/laptops?min_ram_unit=PETABYTE", "", "", nil)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = gw.do(t, http.MethodDelete, "/v1/laptops/"+laptopIDs[0], adminToken, "", nil)
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestGatewayImagesAndRatings(t *testing.T) {
+	t.Parallel()
+
+	gw := startTestGateway(t)
+	adminToken := gw.token(t, "admin1", "admin")
+	laptopID := gw.createLaptop(t, adminToken)
+
+	image, err := os.ReadFile("../tmp/laptop.jpg")
+	require.NoError(t, err)
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile(gateway.ImageFormField, "laptop.jpg")
+	require.NoError(t, err)
+	_, err = part.Write(image)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	res := gw.do(t, http.MethodPost, "/v1/laptops/"+laptopID+"/images", adminToken, form.FormDataContentType(), body)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+	uploaded := decodeJSON(t, res)
+	require.EqualValues(t, len(image), uploaded["size"])
+
+	// a imagem baixada é idêntica à enviada
+	res = gw.do(t, http.MethodGet, "/v1/images/"+uploaded["id"].(string), "", "", nil)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+	require.Equal(t, "nosniff", res.Header.Get("X-Content-Type-Options"))
+	require.Equal(t, laptopID, res.Header.Get("X-Laptop-Id"))
+	downloaded, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, image, downloaded)
+
+	res = gw.do(t, http.MethodGet, "/v1/images/unknown", "", "", nil)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// os tipos que o navegador executaria são recusados
+	for _, filename := range []string{"page.html", "logo.svg"} {
+		body := &bytes.Buffer{}
+		form := multipart.NewWriter(body)
+		part, err := form.CreateFormFile(gateway.ImageFormField, filename)
+		require.NoError(t, err)
+		_, err = part.Write([]byte("<script>alert(1)</script>"))
+		require.NoError(t, err)
+		require.NoError(t, form.Close())
+
+		res = gw.do(t, http.MethodPost, "/v1/laptops/"+laptopID+"/images", adminToken, form.FormDataContentType(), body)
+		require.Equal(t, http.StatusBadRequest, res.StatusCode, filename)
+	}
+
+	userToken := gw.token(t, "user1", "user")
+	for i, score := range []float64{8, 10} {
+		res = gw.do(t, http.MethodPost, "/v1/laptops/"+laptopID+"/ratings", userToken, "application/json",
+			strings.NewReader(fmt.Sprintf(`{"score": %g}`, score)))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		rating := decodeJSON(t, res)
+		require.Equal(t, laptopID, rating["laptop_id"])
+		require.EqualValues(t, i+1, rating["rated_count"])
+	}
+
+	// só tokens Bearer são aceitos no cabeçalho Authorization
+	req, err := http.NewRequest(http.MethodPost, gw.url+"/v1/laptops/"+laptopID+"/ratings", strings.NewReader(`{"score": 1}`))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Basic dXNlcjE6c2VjcmV0")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+// recordingLaptopClient guarda os metadados enviados pelo gateway ao servidor
+type recordingLaptopClient struct {
+	pb.LaptopServiceClient
+	md metadata.MD
+}
+
+func (client *recordingLaptopClient) FindLaptop(ctx context.Context, req *pb.FindLaptopRequest, opts ...grpc.CallOption) (*pb.SearchLaptopResponse, error) {
+	client.md, _ = metadata.FromOutgoingContext(ctx)
+	return nil, status.Error(codes.NotFound, "laptop not found")
+}
+
+func TestGatewayForwardedFor(t *testing.T) {
+	t.Parallel()
+
+	laptopClient := &recordingLaptopClient{}
+	httpServer := httptest.NewServer(gateway.New(laptopClient))
+	t.Cleanup(httpServer.Close)
+
+	// o endereço repassado é o do cliente do gateway, e não o do cabeçalho enviado pelo cliente
+	req, err := http.NewRequest(http.MethodGet, httpServer.URL+"/v1/laptops/laptop1", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	require.Equal(t, []string{"127.0.0.1"}, laptopClient.md.Get(service.ForwardedForHeader))
+}
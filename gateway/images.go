@@ -0,0 +1,200 @@
+package gateway
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/pcbook-go/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ImageFormField is the field of the multipart form holding the uploaded image
+	ImageFormField = "image"
+
+	// uploadChunkSize is the size of the chunks sent by UploadImage, like the gRPC client does
+	uploadChunkSize = 1024
+)
+
+// uploadImage streams the image of the multipart form to UploadImage, without buffering it:
+// the server rejects the images larger than its limit
+func (gateway *Gateway) uploadImage(w http.ResponseWriter, r *http.Request, laptopID string) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "body must be a multipart form: %v", err))
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			writeError(w, status.Errorf(codes.InvalidArgument, "the %s field is missing", ImageFormField))
+			return
+		}
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "cannot read multipart form: %v", err))
+			return
+		}
+
+		if part.FormName() == ImageFormField {
+			gateway.sendImage(w, r, laptopID, part.FileName(), part.Header.Get("Content-Type"), part)
+			return
+		}
+	}
+}
+
+func (gateway *Gateway) sendImage(
+	w http.ResponseWriter,
+	r *http.Request,
+	laptopID string,
+	filename string,
+	contentType string,
+	image io.Reader,
+) {
+	imageType, err := imageTypeOf(filename, contentType)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, err := outgoingContext(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	stream, err := gateway.client.UploadImage(ctx)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req := &pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  laptopID,
+				ImageType: imageType,
+			},
+		},
+	}
+
+	err = stream.Send(req)
+	if err != nil {
+		// the error of the call is returned by CloseAndRecv
+		_, err = stream.CloseAndRecv()
+		writeError(w, err)
+		return
+	}
+
+	buffer := make([]byte, uploadChunkSize)
+	for {
+		n, err := image.Read(buffer)
+		if n > 0 {
+			req := &pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_ChunkData{
+					ChunkData: buffer[:n],
+				},
+			}
+
+			sendErr := stream.Send(req)
+			if sendErr != nil {
+				_, sendErr = stream.CloseAndRecv()
+				writeError(w, sendErr)
+				return
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "cannot read image: %v", err))
+			return
+		}
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, res)
+}
+
+// imageContentTypes are the content types of the image types accepted by the server,
+// the images of any other type are downloaded as attachments
+var imageContentTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// imageTypeOf returns the extension of the image, like ".jpg", from its filename or else its content type
+func imageTypeOf(filename string, contentType string) (string, error) {
+	imageType := filepath.Ext(filename)
+	if imageType != "" {
+		return imageType, nil
+	}
+
+	extensions, _ := mime.ExtensionsByType(contentType)
+	if len(extensions) > 0 {
+		return extensions[0], nil
+	}
+
+	return "", status.Error(codes.InvalidArgument, "cannot find the image type from its filename or content type")
+}
+
+// downloadImage writes the image as the body of the response, with the content type of its extension
+func (gateway *Gateway) downloadImage(w http.ResponseWriter, r *http.Request, imageID string) {
+	ctx, err := outgoingContext(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	stream, err := gateway.client.DownloadImage(ctx, &pb.DownloadImageRequest{ImageId: imageID})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the first message holds the image info, or the error of the call
+	res, err := stream.Recv()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// the browsers must not guess another type, like HTML, from the content uploaded by the clients
+	contentType, ok := imageContentTypes[strings.ToLower(res.GetInfo().GetImageType())]
+	if !ok {
+		contentType = "application/octet-stream"
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Laptop-Id", res.GetInfo().GetLaptopId())
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			// the status code has been sent, an error can only cut the body short
+			return
+		}
+
+		_, err = w.Write(res.GetChunkData())
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/serializer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// NDJSONContentType is requested in the Accept header to stream the search results, one laptop per line
+	NDJSONContentType = "application/x-ndjson"
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// searchPage is the JSON body of a page of search results
+type searchPage struct {
+	Laptops []json.RawMessage `json:"laptops"`
+	// NextPageToken is passed as the page_token parameter to get the next page, it is empty on the last page
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// searchLaptop streams the laptops as NDJSON if the client accepts it, or returns a page of them.
+// The pages are sorted by laptop ID and the page token is the last ID of the previous page,
+// so that the laptops saved between two pages do not shift the next ones.
+func (gateway *Gateway) searchLaptop(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter, err := parseFilter(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pageSize, err := parsePageSize(query.Get("page_size"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	pageToken := query.Get("page_token")
+
+	ctx, err := outgoingContext(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	stream, err := gateway.client.SearchLaptop(ctx, &pb.SearchLaptopRequest{Filter: filter})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), NDJSONContentType) {
+		streamLaptops(w, stream)
+		return
+	}
+
+	var laptops []*pb.Laptop
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if res.GetLaptop().GetId() > pageToken {
+			laptops = append(laptops, res.GetLaptop())
+		}
+	}
+
+	sort.Slice(laptops, func(i, j int) bool {
+		return laptops[i].GetId() < laptops[j].GetId()
+	})
+
+	page := searchPage{Laptops: []json.RawMessage{}}
+	if len(laptops) > pageSize {
+		laptops = laptops[:pageSize]
+		page.NextPageToken = laptops[pageSize-1].GetId()
+	}
+
+	marshaler := serializer.JSONMarshaler()
+	for _, laptop := range laptops {
+		data, err := marshaler.MarshalToString(laptop)
+		if err != nil {
+			writeError(w, status.Errorf(codes.Internal, "cannot serialize laptop: %v", err))
+			return
+		}
+
+		page.Laptops = append(page.Laptops, json.RawMessage(data))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(page)
+}
+
+// streamLaptops writes each laptop found as a JSON line, as soon as it is received.
+// An error after the first laptop cannot change the status code anymore,
+// so it is written as a last line holding the error instead of a laptop.
+func streamLaptops(w http.ResponseWriter, stream pb.LaptopService_SearchLaptopClient) {
+	marshaler := serializer.JSONMarshaler()
+	flusher, _ := w.(http.Flusher)
+	started := false
+
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if !started {
+				writeError(w, err)
+				return
+			}
+
+			st := status.Convert(err)
+			_ = json.NewEncoder(w).Encode(map[string]errorBody{
+				"error": {Code: st.Code().String(), Message: st.Message()},
+			})
+			return
+		}
+
+		if !started {
+			w.Header().Set("Content-Type", NDJSONContentType)
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		data, err := marshaler.MarshalToString(res.GetLaptop())
+		if err != nil {
+			return
+		}
+
+		_, err = io.WriteString(w, data+"\n")
+		if err != nil {
+			return
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if !started {
+		w.Header().Set("Content-Type", NDJSONContentType)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// parseFilter reads the search filter from the query parameters:
+// max_price_usd, min_cpu_cores, min_cpu_ghz, min_ram_value and min_ram_unit, like "GIGABYTE".
+// Without max_price_usd, the price is not limited.
+func parseFilter(query url.Values) (*pb.Filter, error) {
+	filter := &pb.Filter{
+		MaxPriceUsd: math.MaxFloat64,
+		MinRam:      &pb.Memory{},
+	}
+	var err error
+
+	if value := query.Get("max_price_usd"); value != "" {
+		filter.MaxPriceUsd, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid max_price_usd: %s", value)
+		}
+	}
+
+	if value := query.Get("min_cpu_cores"); value != "" {
+		cores, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid min_cpu_cores: %s", value)
+		}
+		filter.MinCpuCores = uint32(cores)
+	}
+
+	if value := query.Get("min_cpu_ghz"); value != "" {
+		filter.MinCpuGhz, err = strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid min_cpu_ghz: %s", value)
+		}
+	}
+
+	if value := query.Get("min_ram_value"); value != "" {
+		filter.MinRam.Value, err = strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid min_ram_value: %s", value)
+		}
+	}
+
+	if value := query.Get("min_ram_unit"); value != "" {
+		unit, ok := pb.Memory_Unit_value[strings.ToUpper(value)]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid min_ram_unit: %s", value)
+		}
+		filter.MinRam.Unit = pb.Memory_Unit(unit)
+	}
+
+	return filter, nil
+}
+
+func parsePageSize(value string) (int, error) {
+	if value == "" {
+		return defaultPageSize, nil
+	}
+
+	pageSize, err := strconv.Atoi(value)
+	if err != nil || pageSize <= 0 || pageSize > maxPageSize {
+		return 0, status.Errorf(codes.InvalidArgument, "page_size must be between 1 and %d", maxPageSize)
+	}
+
+	return pageSize, nil
+}
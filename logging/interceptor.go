@@ -0,0 +1,316 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata key carrying the request ID, in requests and response headers
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength caps the request IDs accepted from clients, longer ones are replaced
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	data := make([]byte, 16)
+	_, err := rand.Read(data)
+	if err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(data)
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey{}).(string)
+	return requestID, ok
+}
+
+// validRequestID checks that a request ID received from a client is short and printable
+func validRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range requestID {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// callInfo holds the fields of a call found by the next interceptors, like the authenticated user
+type callInfo struct {
+	mutex sync.Mutex
+	user  string
+}
+
+type callInfoKey struct{}
+
+// SetUser records the user of the current call, to be logged when it ends
+func SetUser(ctx context.Context, username string) {
+	info, ok := ctx.Value(callInfoKey{}).(*callInfo)
+	if !ok {
+		return
+	}
+
+	info.mutex.Lock()
+	defer info.mutex.Unlock()
+
+	info.user = username
+}
+
+func (info *callInfo) getUser() string {
+	info.mutex.Lock()
+	defer info.mutex.Unlock()
+
+	return info.user
+}
+
+// ServerInterceptor is a server interceptor assigning a request ID to each call and logging its outcome.
+// It must come before the other interceptors, to log the calls rejected by them,
+// but after the tracing interceptor, to log the trace ID.
+type ServerInterceptor struct {
+	logger *slog.Logger
+}
+
+// NewServerInterceptor returns a new server logging interceptor
+func NewServerInterceptor(logger *slog.Logger) *ServerInterceptor {
+	return &ServerInterceptor{logger}
+}
+
+func (interceptor *ServerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, call := interceptor.start(ctx, info.FullMethod)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, call.requestID))
+
+		res, err := handler(ctx, req)
+
+		call.end(ctx, err)
+		return res, err
+	}
+}
+
+func (interceptor *ServerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, call := interceptor.start(stream.Context(), info.FullMethod)
+		_ = stream.SetHeader(metadata.Pairs(RequestIDHeader, call.requestID))
+
+		err := handler(srv, &serverStream{stream, ctx})
+
+		call.end(ctx, err)
+		return err
+	}
+}
+
+// serverCall is a call being handled by the server
+type serverCall struct {
+	logger    *slog.Logger
+	requestID string
+	info      *callInfo
+	start     time.Time
+}
+
+// start reads or generates the request ID of a call and stores it in the context with the call logger
+func (interceptor *ServerInterceptor) start(ctx context.Context, method string) (context.Context, *serverCall) {
+	var requestID string
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		values := md.Get(RequestIDHeader)
+		if len(values) > 0 && validRequestID(values[0]) {
+			requestID = values[0]
+		}
+	}
+
+	if requestID == "" {
+		requestID = NewRequestID()
+	}
+
+	call := &serverCall{
+		logger:    interceptor.logger.With(callAttributes(ctx, requestID, method)...),
+		requestID: requestID,
+		info:      &callInfo{},
+		start:     time.Now(),
+	}
+
+	ctx = ContextWithRequestID(ctx, requestID)
+	ctx = ContextWithLogger(ctx, call.logger)
+	ctx = context.WithValue(ctx, callInfoKey{}, call.info)
+
+	return ctx, call
+}
+
+// end logs the outcome of the call: server errors as errors, client errors as warnings
+func (call *serverCall) end(ctx context.Context, err error) {
+	code := status.Code(err)
+
+	attrs := []any{
+		"code", code.String(),
+		"duration", time.Since(call.start),
+	}
+
+	user := call.info.getUser()
+	if user != "" {
+		attrs = append(attrs, "user", user)
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if ok && p.Addr != nil {
+		attrs = append(attrs, "peer", p.Addr.String())
+	}
+
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+
+	call.logger.Log(ctx, levelOf(code), "call finished", attrs...)
+}
+
+// levelOf returns the log level of a call ending with the code
+func levelOf(code codes.Code) slog.Level {
+	switch code {
+	case codes.OK:
+		return slog.LevelInfo
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return slog.LevelError
+	default:
+		return slog.LevelWarn
+	}
+}
+
+// serverStream replaces the context of a stream with the one carrying the request ID and the logger
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *serverStream) Context() context.Context {
+	return stream.ctx
+}
+
+// ClientInterceptor is a client interceptor sending a request ID with each call and logging its outcome
+type ClientInterceptor struct {
+	logger *slog.Logger
+}
+
+// NewClientInterceptor returns a new client logging interceptor
+func NewClientInterceptor(logger *slog.Logger) *ClientInterceptor {
+	return &ClientInterceptor{logger}
+}
+
+func (interceptor *ClientInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, requestID := outgoingRequestID(ctx)
+		start := time.Now()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		interceptor.log(ctx, method, requestID, start, err)
+		return err
+	}
+}
+
+func (interceptor *ClientInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, requestID := outgoingRequestID(ctx)
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			interceptor.log(ctx, method, requestID, time.Now(), err)
+			return nil, err
+		}
+
+		interceptor.logger.DebugContext(ctx, "stream opened", callAttributes(ctx, requestID, method)...)
+		return stream, nil
+	}
+}
+
+func (interceptor *ClientInterceptor) log(ctx context.Context, method string, requestID string, start time.Time, err error) {
+	code := status.Code(err)
+
+	attrs := append(callAttributes(ctx, requestID, method),
+		"code", code.String(),
+		"duration", time.Since(start),
+	)
+
+	if err != nil {
+		attrs = append(attrs, "error", status.Convert(err).Message())
+	}
+
+	level := slog.LevelDebug
+	if err != nil {
+		level = levelOf(code)
+	}
+
+	interceptor.logger.Log(ctx, level, "call finished", attrs...)
+}
+
+// callAttributes returns the attributes identifying a call in every log, with the trace ID if it is traced
+func callAttributes(ctx context.Context, requestID string, method string) []any {
+	attrs := []any{"request_id", requestID}
+
+	spanContext := trace.SpanContextFromContext(ctx)
+	if spanContext.IsValid() {
+		attrs = append(attrs, "trace_id", spanContext.TraceID().String())
+	}
+
+	return append(attrs, "method", method)
+}
+
+// outgoingRequestID attaches the request ID of ctx to the outgoing metadata, generating one if needed
+func outgoingRequestID(ctx context.Context) (context.Context, string) {
+	requestID, ok := RequestIDFromContext(ctx)
+	if !ok {
+		requestID = NewRequestID()
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, RequestIDHeader, requestID), requestID
+}
@@ -0,0 +1,117 @@
+// Package logging configures the structured logs of the server and the client,
+// and propagates request IDs through the gRPC metadata.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const (
+	// TextFormat writes the logs as key=value pairs
+	TextFormat = "text"
+	// JSONFormat writes the logs as JSON objects, one per line
+	JSONFormat = "json"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are the attribute keys whose values are never written, compared in lowercase
+var sensitiveKeys = []string{"token", "password", "secret", "authorization", "api_key", "apikey", "recovery_code", "totp"}
+
+// settingSuffixes end the keys naming a file or a duration, like "token_duration", which are never credentials
+var settingSuffixes = []string{"_file", "_files", "_duration"}
+
+// New returns a logger writing to w in the given format, dropping the records below level.
+// Attributes holding tokens, passwords or secrets are redacted.
+func New(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	switch format {
+	case TextFormat, "":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case JSONFormat:
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+// ParseLevel parses a level name like "debug", "info", "warn" or "error"
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	if err != nil {
+		return level, fmt.Errorf("invalid log level: %s", name)
+	}
+
+	return level, nil
+}
+
+// redact replaces the values of sensitive attributes, and string values that look like credentials
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() == slog.KindGroup {
+		return attr
+	}
+
+	if IsSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, redacted)
+	}
+
+	if attr.Value.Kind() == slog.KindString && looksLikeCredential(attr.Value.String()) {
+		return slog.String(attr.Key, redacted)
+	}
+
+	return attr
+}
+
+// IsSensitiveKey checks if a key names a credential, e.g. "access_token" or "password"
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range settingSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return false
+		}
+	}
+
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// looksLikeCredential detects JSON web tokens and API keys written under an unexpected key
+func looksLikeCredential(value string) bool {
+	if strings.HasPrefix(value, "pcbk_") {
+		return true
+	}
+
+	return strings.HasPrefix(value, "eyJ") && strings.Count(value, ".") == 2
+}
+
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying the logger
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger of the request, with its request ID and method,
+// or the default logger outside of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	logger, ok := ctx.Value(loggerKey{}).(*slog.Logger)
+	if !ok {
+		return slog.Default()
+	}
+
+	return logger
+}
@@ -0,0 +1,179 @@
+// Package metrics exposes the Prometheus metrics of the gRPC server.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pcbook-go/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const namespace = "pcbook"
+
+// ServerInterceptor is a server interceptor counting the calls and measuring their latency
+type ServerInterceptor struct {
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	inFlight      *prometheus.GaugeVec
+	receivedBytes *prometheus.CounterVec
+}
+
+// NewServerInterceptor returns a new metrics interceptor, registering its collectors
+func NewServerInterceptor(registerer prometheus.Registerer) (*ServerInterceptor, error) {
+	interceptor := &ServerInterceptor{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "Number of RPCs completed by the server, by method and status code.",
+		}, []string{"method", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "Latency of the RPCs completed by the server, by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "grpc_in_flight_streams",
+			Help:      "Number of streams currently open, by method.",
+		}, []string{"method"}),
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_stream_received_bytes_total",
+			Help:      "Size of the messages received on client streams like UploadImage, by method.",
+		}, []string{"method"}),
+	}
+
+	collectors := []prometheus.Collector{
+		interceptor.requests,
+		interceptor.duration,
+		interceptor.inFlight,
+		interceptor.receivedBytes,
+	}
+
+	for _, collector := range collectors {
+		err := registerer.Register(collector)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return interceptor, nil
+}
+
+func (interceptor *ServerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+
+		res, err := handler(ctx, req)
+
+		interceptor.observe(info.FullMethod, start, err)
+		return res, err
+	}
+}
+
+func (interceptor *ServerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+
+		inFlight := interceptor.inFlight.WithLabelValues(info.FullMethod)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		if info.IsClientStream {
+			stream = &countingServerStream{
+				ServerStream:  stream,
+				receivedBytes: interceptor.receivedBytes.WithLabelValues(info.FullMethod),
+			}
+		}
+
+		err := handler(srv, stream)
+
+		interceptor.observe(info.FullMethod, start, err)
+		return err
+	}
+}
+
+func (interceptor *ServerInterceptor) observe(method string, start time.Time, err error) {
+	interceptor.requests.WithLabelValues(method, status.Code(err).String()).Inc()
+	interceptor.duration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
+
+// countingServerStream counts the bytes of the messages received on a stream
+type countingServerStream struct {
+	grpc.ServerStream
+	receivedBytes prometheus.Counter
+}
+
+func (stream *countingServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		if message, ok := m.(proto.Message); ok {
+			stream.receivedBytes.Add(float64(proto.Size(message)))
+		}
+	}
+	return err
+}
+
+// RegisterStoreGauges registers gauges reading the number of laptops, the size of the images
+// and the number of ratings from the stores each time the metrics are scraped
+func RegisterStoreGauges(
+	registerer prometheus.Registerer,
+	laptopStore service.LaptopStore,
+	imageStore service.ImageStore,
+	ratingStore service.RatingStore,
+) error {
+	gauges := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "laptops",
+			Help:      "Number of laptops in the store.",
+		}, func() float64 {
+			return float64(laptopStore.Count())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "image_bytes",
+			Help:      "Total size of the laptop images saved on disk.",
+		}, func() float64 {
+			return float64(imageStore.TotalSize())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ratings",
+			Help:      "Number of ratings of all laptops.",
+		}, func() float64 {
+			return float64(ratingStore.Count())
+		}),
+	}
+
+	for _, gauge := range gauges {
+		err := registerer.Register(gauge)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus text format
+func Handler(gatherer prometheus.Gatherer) http.Handler {
+	return promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,144 @@
+package metrics_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pcbook-go/metrics"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+type userServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *userServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func TestServerMetrics(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskIMageStore(t.TempDir())
+	ratingStore := service.NewInMemoryRatingStore()
+
+	registry := prometheus.NewRegistry()
+	interceptor, err := metrics.NewServerInterceptor(registry)
+	require.NoError(t, err)
+	require.NoError(t, metrics.RegisterStoreGauges(registry, laptopStore, imageStore, ratingStore))
+
+	// os coletores não podem ser registrados duas vezes
+	_, err = metrics.NewServerInterceptor(registry)
+	require.Error(t, err)
+
+	// somente um superadmin pode enviar imagens dos laptops criados sem autenticação
+	superadmin := &service.UserClaims{Username: "root", Role: service.SuperadminRole}
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.ChainStreamInterceptor(interceptor.Stream(), func(
+			srv interface{},
+			stream grpc.ServerStream,
+			info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler,
+		) error {
+			ctx := service.ContextWithUserClaims(stream.Context(), superadmin)
+			return handler(srv, &userServerStream{ServerStream: stream, ctx: ctx})
+		}),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, imageStore, ratingStore))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	laptopClient := pb.NewLaptopServiceClient(conn)
+	ctx := context.Background()
+
+	laptop := sample.NewLaptop()
+	_, err = laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	_, err = laptopClient.FindLaptop(ctx, &pb.FindLaptopRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// envia uma imagem de 2000 bytes em duas partes
+	upload, err := laptopClient.UploadImage(ctx)
+	require.NoError(t, err)
+	require.NoError(t, upload.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"}},
+	}))
+	for i := 0; i < 2; i++ {
+		require.NoError(t, upload.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{ChunkData: make([]byte, 1000)},
+		}))
+	}
+	_, err = upload.CloseAndRecv()
+	require.NoError(t, err)
+
+	rate, err := laptopClient.RateLaptop(ctx)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, rate.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8}))
+		_, err = rate.Recv()
+		require.NoError(t, err)
+	}
+	require.NoError(t, rate.CloseSend())
+	_, err = rate.Recv()
+	require.Equal(t, io.EOF, err)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range metric.GetLabel() {
+				key += " " + label.GetValue()
+			}
+
+			switch {
+			case metric.GetCounter() != nil:
+				values[key] = metric.GetCounter().GetValue()
+			case metric.GetGauge() != nil:
+				values[key] = metric.GetGauge().GetValue()
+			case metric.GetHistogram() != nil:
+				values[key] = float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	require.Equal(t, 1.0, values["pcbook_grpc_requests_total NotFound /pcbook.LaptopService/FindLaptop"])
+	require.Equal(t, 1.0, values["pcbook_grpc_requests_total OK /pcbook.LaptopService/CreateLaptop"])
+	require.Equal(t, 1.0, values["pcbook_grpc_requests_total OK /pcbook.LaptopService/UploadImage"])
+	require.Equal(t, 1.0, values["pcbook_grpc_request_duration_seconds /pcbook.LaptopService/RateLaptop"])
+	require.Equal(t, 0.0, values["pcbook_grpc_in_flight_streams /pcbook.LaptopService/UploadImage"])
+	require.Greater(t, values["pcbook_grpc_stream_received_bytes_total /pcbook.LaptopService/UploadImage"], 2000.0)
+
+	require.Equal(t, 1.0, values["pcbook_laptops"])
+	require.Equal(t, 2000.0, values["pcbook_image_bytes"])
+	require.Equal(t, 3.0, values["pcbook_ratings"])
+
+	// as métricas são servidas no formato de texto do Prometheus
+	recorder := httptest.NewRecorder()
+	metrics.Handler(registry).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, 200, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "pcbook_laptops 1\n")
+}
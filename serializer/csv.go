@@ -0,0 +1,416 @@
+package serializer
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// csvListSeparator separa os valores de um campo repetido em uma célula, como as GPUs de um laptop
+const csvListSeparator = '|'
+
+// timestampName é o tipo dos campos gravados como uma data RFC 3339, e não como seconds e nanos
+const timestampName = "google.protobuf.Timestamp"
+
+// csvColumn é uma coluna do CSV, com o caminho dos campos a partir da mensagem de cada linha
+type csvColumn struct {
+	name string
+	path []protoreflect.FieldDescriptor
+	// list é a posição em path do campo repetido, ou -1 se não há
+	list int
+}
+
+// csvColumns retorna uma coluna para cada campo escalar da mensagem e das mensagens dentro dela.
+// Os campos das mensagens dentro de um campo repetido são uma coluna com os valores de todos os elementos.
+func csvColumns(descriptor protoreflect.MessageDescriptor) []csvColumn {
+	var columns []csvColumn
+
+	var walk func(descriptor protoreflect.MessageDescriptor, path []protoreflect.FieldDescriptor, list int)
+	walk = func(descriptor protoreflect.MessageDescriptor, path []protoreflect.FieldDescriptor, list int) {
+		fields := descriptor.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			fieldPath := append(append([]protoreflect.FieldDescriptor(nil), path...), field)
+
+			fieldList := list
+			if field.IsList() {
+				// uma lista dentro de outra não cabe em uma célula
+				if list >= 0 {
+					continue
+				}
+				fieldList = len(path)
+			}
+
+			if field.IsMap() {
+				continue
+			}
+
+			if field.Message() != nil && field.Message().FullName() != timestampName {
+				walk(field.Message(), fieldPath, fieldList)
+				continue
+			}
+
+			names := make([]string, len(fieldPath))
+			for i, field := range fieldPath {
+				names[i] = string(field.Name())
+			}
+			columns = append(columns, csvColumn{name: strings.Join(names, "."), path: fieldPath, list: fieldList})
+		}
+	}
+
+	walk(descriptor, nil, -1)
+	return columns
+}
+
+// format retorna a célula da coluna na mensagem
+func (column *csvColumn) format(message protoreflect.Message) string {
+	if column.list < 0 {
+		return formatCSVPath(message, column.path)
+	}
+
+	parent, ok := csvParent(message, column.path[:column.list])
+	if !ok {
+		return ""
+	}
+
+	field := column.path[column.list]
+	list := parent.Get(field).List()
+	values := make([]string, list.Len())
+
+	for i := range values {
+		if field.Message() != nil && field.Message().FullName() != timestampName {
+			values[i] = formatCSVPath(list.Get(i).Message(), column.path[column.list+1:])
+		} else {
+			values[i] = formatCSVValue(field, list.Get(i))
+		}
+	}
+
+	return joinCSVList(values)
+}
+
+// parse define o campo da coluna na mensagem, as células vazias não definem nada
+func (column *csvColumn) parse(message protoreflect.Message, cell string) error {
+	if cell == "" {
+		return nil
+	}
+
+	if column.list < 0 {
+		return parseCSVPath(message, column.path, cell)
+	}
+
+	parent := csvMutableParent(message, column.path[:column.list])
+	field := column.path[column.list]
+	list := parent.Mutable(field).List()
+	values := splitCSVList(cell)
+
+	for list.Len() < len(values) {
+		list.Append(list.NewElement())
+	}
+
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+
+		if field.Message() != nil && field.Message().FullName() != timestampName {
+			err := parseCSVPath(list.Get(i).Message(), column.path[column.list+1:], value)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		parsed, err := parseCSVValue(field, value)
+		if err != nil {
+			return err
+		}
+		list.Set(i, parsed)
+	}
+
+	return nil
+}
+
+// csvParent percorre as mensagens do caminho, retorna false se uma delas não está definida
+func csvParent(message protoreflect.Message, path []protoreflect.FieldDescriptor) (protoreflect.Message, bool) {
+	for _, field := range path {
+		if !message.Has(field) {
+			return nil, false
+		}
+		message = message.Get(field).Message()
+	}
+	return message, true
+}
+
+// csvMutableParent percorre as mensagens do caminho, criando as que não estão definidas
+func csvMutableParent(message protoreflect.Message, path []protoreflect.FieldDescriptor) protoreflect.Message {
+	for _, field := range path {
+		message = message.Mutable(field).Message()
+	}
+	return message
+}
+
+func formatCSVPath(message protoreflect.Message, path []protoreflect.FieldDescriptor) string {
+	parent, ok := csvParent(message, path[:len(path)-1])
+	if !ok {
+		return ""
+	}
+
+	// os campos com presença, como os de um oneof, ficam vazios quando não estão definidos
+	field := path[len(path)-1]
+	if field.HasPresence() && !parent.Has(field) {
+		return ""
+	}
+
+	return formatCSVValue(field, parent.Get(field))
+}
+
+func parseCSVPath(message protoreflect.Message, path []protoreflect.FieldDescriptor, cell string) error {
+	parent := csvMutableParent(message, path[:len(path)-1])
+	field := path[len(path)-1]
+
+	value, err := parseCSVValue(field, cell)
+	if err != nil {
+		return err
+	}
+
+	parent.Set(field, value)
+	return nil
+}
+
+func formatCSVValue(field protoreflect.FieldDescriptor, value protoreflect.Value) string {
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		timestamp, ok := value.Message().Interface().(*timestamppb.Timestamp)
+		if !ok {
+			return ""
+		}
+		return timestamp.AsTime().Format(time.RFC3339Nano)
+	case protoreflect.EnumKind:
+		enumValue := field.Enum().Values().ByNumber(value.Enum())
+		if enumValue == nil {
+			return strconv.Itoa(int(value.Enum()))
+		}
+		return string(enumValue.Name())
+	case protoreflect.BoolKind:
+		return strconv.FormatBool(value.Bool())
+	case protoreflect.StringKind:
+		return value.String()
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(value.Bytes())
+	case protoreflect.FloatKind:
+		return strconv.FormatFloat(value.Float(), 'g', -1, 32)
+	case protoreflect.DoubleKind:
+		return strconv.FormatFloat(value.Float(), 'g', -1, 64)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return strconv.FormatInt(value.Int(), 10)
+	default:
+		return strconv.FormatUint(value.Uint(), 10)
+	}
+}
+
+func parseCSVValue(field protoreflect.FieldDescriptor, cell string) (protoreflect.Value, error) {
+	var value protoreflect.Value
+	var err error
+
+	switch field.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		var t time.Time
+		t, err = time.Parse(time.RFC3339Nano, cell)
+		value = protoreflect.ValueOfMessage(timestamppb.New(t).ProtoReflect())
+	case protoreflect.EnumKind:
+		enumValue := field.Enum().Values().ByName(protoreflect.Name(cell))
+		if enumValue != nil {
+			value = protoreflect.ValueOfEnum(enumValue.Number())
+			break
+		}
+		var number int64
+		number, err = strconv.ParseInt(cell, 10, 32)
+		value = protoreflect.ValueOfEnum(protoreflect.EnumNumber(number))
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(cell)
+		value = protoreflect.ValueOfBool(b)
+	case protoreflect.StringKind:
+		value = protoreflect.ValueOfString(cell)
+	case protoreflect.BytesKind:
+		var data []byte
+		data, err = base64.StdEncoding.DecodeString(cell)
+		value = protoreflect.ValueOfBytes(data)
+	case protoreflect.FloatKind:
+		var f float64
+		f, err = strconv.ParseFloat(cell, 32)
+		value = protoreflect.ValueOfFloat32(float32(f))
+	case protoreflect.DoubleKind:
+		var f float64
+		f, err = strconv.ParseFloat(cell, 64)
+		value = protoreflect.ValueOfFloat64(f)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var i int64
+		i, err = strconv.ParseInt(cell, 10, 32)
+		value = protoreflect.ValueOfInt32(int32(i))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var i int64
+		i, err = strconv.ParseInt(cell, 10, 64)
+		value = protoreflect.ValueOfInt64(i)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		var u uint64
+		u, err = strconv.ParseUint(cell, 10, 32)
+		value = protoreflect.ValueOfUint32(uint32(u))
+	default:
+		var u uint64
+		u, err = strconv.ParseUint(cell, 10, 64)
+		value = protoreflect.ValueOfUint64(u)
+	}
+
+	if err != nil {
+		return protoreflect.Value{}, fmt.Errorf("valor inválido %q do campo %s: %w", cell, field.Name(), err)
+	}
+	return value, nil
+}
+
+// joinCSVList junta os valores de uma lista, escapando o separador com uma barra invertida
+func joinCSVList(values []string) string {
+	escaper := strings.NewReplacer(`\`, `\\`, string(csvListSeparator), `\`+string(csvListSeparator))
+
+	escaped := make([]string, len(values))
+	for i, value := range values {
+		escaped[i] = escaper.Replace(value)
+	}
+	return strings.Join(escaped, string(csvListSeparator))
+}
+
+// splitCSVList separa os valores juntados por joinCSVList
+func splitCSVList(cell string) []string {
+	var values []string
+	value := strings.Builder{}
+
+	for i := 0; i < len(cell); i++ {
+		switch {
+		case cell[i] == '\\' && i+1 < len(cell):
+			i++
+			value.WriteByte(cell[i])
+		case cell[i] == csvListSeparator:
+			values = append(values, value.String())
+			value.Reset()
+		default:
+			value.WriteByte(cell[i])
+		}
+	}
+
+	return append(values, value.String())
+}
+
+// CSVWriter grava mensagens do mesmo tipo em um CSV, uma por linha,
+// com um cabeçalho com os nomes das colunas como "cpu.number_cores"
+type CSVWriter struct {
+	writer  *csv.Writer
+	columns []csvColumn
+}
+
+// NewCSVWriter retorna um CSVWriter que grava o cabeçalho com a primeira mensagem
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(w)}
+}
+
+// Write grava uma mensagem em uma linha
+func (writer *CSVWriter) Write(message proto.Message) error {
+	reflected := proto.MessageReflect(message)
+
+	if writer.columns == nil {
+		writer.columns = csvColumns(reflected.Descriptor())
+
+		header := make([]string, len(writer.columns))
+		for i, column := range writer.columns {
+			header[i] = column.name
+		}
+
+		err := writer.writer.Write(header)
+		if err != nil {
+			return err
+		}
+	}
+
+	record := make([]string, len(writer.columns))
+	for i, column := range writer.columns {
+		record[i] = column.format(reflected)
+	}
+
+	return writer.writer.Write(record)
+}
+
+// Close grava as linhas que ainda estão no buffer
+func (writer *CSVWriter) Close() error {
+	writer.writer.Flush()
+	return writer.writer.Error()
+}
+
+// CSVReader lê as mensagens de um CSV gravado pelo CSVWriter.
+// As colunas podem estar em qualquer ordem, e as que faltam deixam os campos vazios.
+type CSVReader struct {
+	reader  *csv.Reader
+	header  []string
+	columns []*csvColumn
+}
+
+// NewCSVReader retorna um CSVReader que lê o cabeçalho com a primeira mensagem
+func NewCSVReader(r io.Reader) *CSVReader {
+	return &CSVReader{reader: csv.NewReader(r)}
+}
+
+// Read lê a mensagem da próxima linha, retorna io.EOF depois da última
+func (reader *CSVReader) Read(message proto.Message) error {
+	reflected := proto.MessageReflect(message)
+
+	if reader.header == nil {
+		header, err := reader.reader.Read()
+		if err != nil {
+			return err
+		}
+		reader.header = header
+	}
+
+	if reader.columns == nil {
+		columns := make(map[string]csvColumn)
+		for _, column := range csvColumns(reflected.Descriptor()) {
+			columns[column.name] = column
+		}
+
+		reader.columns = make([]*csvColumn, len(reader.header))
+		for i, name := range reader.header {
+			column, ok := columns[name]
+			if !ok {
+				return fmt.Errorf("coluna desconhecida: %q", name)
+			}
+			reader.columns[i] = &column
+		}
+	}
+
+	record, err := reader.reader.Read()
+	if err != nil {
+		return err
+	}
+
+	message.Reset()
+	for i, cell := range record {
+		err := reader.columns[i].parse(reflected, cell)
+		if err != nil {
+			return fmt.Errorf("linha %d: %w", reader.lineNumber(), err)
+		}
+	}
+
+	return nil
+}
+
+func (reader *CSVReader) lineNumber() int {
+	line, _ := reader.reader.FieldPos(0)
+	return line
+}
@@ -1,22 +1,42 @@
 package serializer
 
 import (
+	"bytes"
+
 	"github.com/golang/protobuf/jsonpb"
 	"github.com/golang/protobuf/proto"
 )
 
-// ProtobufToJSON converte mensagem de buffer de protocolo em string JSON
-func ProtobufToJSON(message proto.Message) (string, error) {
-	marshaler := jsonpb.Marshaler{
+// JSONMarshaler retorna o marshaler das convenções JSON do pcbook:
+// nomes originais dos campos, enums como strings e valores padrão incluídos
+func JSONMarshaler() *jsonpb.Marshaler {
+	return &jsonpb.Marshaler{
 		EnumsAsInts:  false,
 		EmitDefaults: true,
-		Indent:       "  ",
 		OrigName:     true,
 	}
+}
+
+// ProtobufToJSON converte mensagem de buffer de protocolo em string JSON
+func ProtobufToJSON(message proto.Message) (string, error) {
+	marshaler := JSONMarshaler()
+	marshaler.Indent = "  "
 
 	return marshaler.MarshalToString(message)
 }
 
+// ProtobufToCompactJSON converte mensagem de buffer de protocolo em JSON sem indentação, para ser enviado pela rede
+func ProtobufToCompactJSON(message proto.Message) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+
+	err := JSONMarshaler().Marshal(buffer, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
 // JSONToProtobufMessage converte string JSON em mensagem de buffer de protocolo
 func JSONToProtobufMessage(data string, message proto.Message) error {
 	return jsonpb.UnmarshalString(data, message)
@@ -0,0 +1,260 @@
+package serializer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Format é um formato de arquivo com várias mensagens do mesmo tipo
+type Format string
+
+const (
+	// FormatJSON grava as mensagens em um array JSON
+	FormatJSON Format = "json"
+	// FormatNDJSON grava uma mensagem JSON por linha
+	FormatNDJSON Format = "ndjson"
+	// FormatCSV grava uma mensagem por linha, com uma coluna por campo escalar
+	FormatCSV Format = "csv"
+	// FormatBinary grava as mensagens binárias, cada uma precedida do seu tamanho em varint
+	FormatBinary Format = "binary"
+)
+
+// Formats são todos os formatos de arquivo
+var Formats = []Format{FormatJSON, FormatNDJSON, FormatCSV, FormatBinary}
+
+// maxDelimitedSize limita o tamanho de uma mensagem lida de um arquivo binário
+const maxDelimitedSize = 64 << 20
+
+// MessageWriter grava várias mensagens do mesmo tipo em um formato
+type MessageWriter interface {
+	// Write grava uma mensagem
+	Write(message proto.Message) error
+	// Close termina o arquivo, sem fechar o io.Writer
+	Close() error
+}
+
+// MessageReader lê as mensagens gravadas por um MessageWriter
+type MessageReader interface {
+	// Read lê a próxima mensagem, retorna io.EOF depois da última
+	Read(message proto.Message) error
+}
+
+// NewMessageWriter retorna um MessageWriter que grava as mensagens no formato
+func NewMessageWriter(w io.Writer, format Format) (MessageWriter, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonArrayWriter{writer: bufio.NewWriter(w)}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{writer: bufio.NewWriter(w)}, nil
+	case FormatCSV:
+		return NewCSVWriter(w), nil
+	case FormatBinary:
+		return &delimitedWriter{writer: bufio.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("formato desconhecido: %q", format)
+	}
+}
+
+// NewMessageReader retorna um MessageReader que lê as mensagens no formato
+func NewMessageReader(r io.Reader, format Format) (MessageReader, error) {
+	switch format {
+	case FormatJSON:
+		return &jsonArrayReader{decoder: json.NewDecoder(r)}, nil
+	case FormatNDJSON:
+		return &ndjsonReader{reader: bufio.NewReader(r)}, nil
+	case FormatCSV:
+		return NewCSVReader(r), nil
+	case FormatBinary:
+		return &delimitedReader{reader: bufio.NewReader(r)}, nil
+	default:
+		return nil, fmt.Errorf("formato desconhecido: %q", format)
+	}
+}
+
+// WriteDelimited grava a mensagem binária precedida do seu tamanho em varint,
+// o mesmo formato do writeDelimitedTo das outras linguagens
+func WriteDelimited(w io.Writer, message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("não é possível empacotar mensagem proto para binário: %w", err)
+	}
+
+	size := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(size, uint64(len(data)))
+
+	_, err = w.Write(size[:n])
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// ReadDelimited lê uma mensagem gravada por WriteDelimited, retorna io.EOF se não há mais mensagens
+func ReadDelimited(r *bufio.Reader, message proto.Message) error {
+	size, err := binary.ReadUvarint(r)
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		// o arquivo termina no meio do tamanho
+		return fmt.Errorf("não é possível ler o tamanho da mensagem: %w", err)
+	}
+
+	if size > maxDelimitedSize {
+		return fmt.Errorf("a mensagem é muito grande: %d > %d", size, maxDelimitedSize)
+	}
+
+	data := make([]byte, size)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return fmt.Errorf("não é possível ler a mensagem: %w", io.ErrUnexpectedEOF)
+	}
+
+	err = proto.Unmarshal(data, message)
+	if err != nil {
+		return fmt.Errorf("não é possível descompactar o binário para a mensagem proto: %w", err)
+	}
+
+	return nil
+}
+
+type delimitedWriter struct {
+	writer *bufio.Writer
+}
+
+func (writer *delimitedWriter) Write(message proto.Message) error {
+	return WriteDelimited(writer.writer, message)
+}
+
+func (writer *delimitedWriter) Close() error {
+	return writer.writer.Flush()
+}
+
+type delimitedReader struct {
+	reader *bufio.Reader
+}
+
+func (reader *delimitedReader) Read(message proto.Message) error {
+	return ReadDelimited(reader.reader, message)
+}
+
+type ndjsonWriter struct {
+	writer *bufio.Writer
+}
+
+func (writer *ndjsonWriter) Write(message proto.Message) error {
+	data, err := ProtobufToCompactJSON(message)
+	if err != nil {
+		return fmt.Errorf("não é possível empacotar a mensagem proto para JSON: %w", err)
+	}
+
+	_, err = writer.writer.Write(append(data, '\n'))
+	return err
+}
+
+func (writer *ndjsonWriter) Close() error {
+	return writer.writer.Flush()
+}
+
+type ndjsonReader struct {
+	reader *bufio.Reader
+}
+
+func (reader *ndjsonReader) Read(message proto.Message) error {
+	for {
+		line, err := reader.reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// as linhas vazias são ignoradas
+		line = bytes.TrimSpace(line)
+		if len(line) > 0 {
+			return JSONToProtobufMessage(string(line), message)
+		}
+
+		if err != nil {
+			return io.EOF
+		}
+	}
+}
+
+type jsonArrayWriter struct {
+	writer *bufio.Writer
+	count  int
+}
+
+func (writer *jsonArrayWriter) Write(message proto.Message) error {
+	data, err := ProtobufToCompactJSON(message)
+	if err != nil {
+		return fmt.Errorf("não é possível empacotar a mensagem proto para JSON: %w", err)
+	}
+
+	// uma mensagem por linha, para que o arquivo possa ser lido por pessoas
+	separator := ",\n"
+	if writer.count == 0 {
+		separator = "[\n"
+	}
+	writer.count++
+
+	_, err = writer.writer.WriteString(separator)
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.writer.Write(data)
+	return err
+}
+
+func (writer *jsonArrayWriter) Close() error {
+	end := "\n]\n"
+	if writer.count == 0 {
+		end = "[]\n"
+	}
+
+	_, err := writer.writer.WriteString(end)
+	if err != nil {
+		return err
+	}
+
+	return writer.writer.Flush()
+}
+
+type jsonArrayReader struct {
+	decoder *json.Decoder
+	started bool
+}
+
+func (reader *jsonArrayReader) Read(message proto.Message) error {
+	if !reader.started {
+		token, err := reader.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("não é possível ler o início do array JSON: %w", err)
+		}
+		if token != json.Delim('[') {
+			return fmt.Errorf("o arquivo JSON não é um array")
+		}
+		reader.started = true
+	}
+
+	if !reader.decoder.More() {
+		return io.EOF
+	}
+
+	var data json.RawMessage
+	err := reader.decoder.Decode(&data)
+	if err != nil {
+		return fmt.Errorf("não é possível ler a mensagem JSON: %w", err)
+	}
+
+	return JSONToProtobufMessage(string(data), message)
+}
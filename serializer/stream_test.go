@@ -0,0 +1,96 @@
+package serializer_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/serializer"
+	"github.com/stretchr/testify/require"
+)
+
+// roundTrip grava os laptops no formato e os lê de volta
+func roundTrip(t *testing.T, format serializer.Format, laptops []*pb.Laptop) ([]byte, []*pb.Laptop) {
+	var buffer bytes.Buffer
+
+	writer, err := serializer.NewMessageWriter(&buffer, format)
+	require.NoError(t, err)
+	for _, laptop := range laptops {
+		require.NoError(t, writer.Write(laptop))
+	}
+	require.NoError(t, writer.Close())
+	data := append([]byte(nil), buffer.Bytes()...)
+
+	reader, err := serializer.NewMessageReader(&buffer, format)
+	require.NoError(t, err)
+
+	var read []*pb.Laptop
+	for {
+		laptop := &pb.Laptop{}
+		err := reader.Read(laptop)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		read = append(read, laptop)
+	}
+
+	return data, read
+}
+
+func TestMessageStreamFormats(t *testing.T) {
+	t.Parallel()
+
+	// um laptop com o peso em libras, outro sem GPU, e um com os separadores da lista no nome
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	laptops[0].Weight = &pb.Laptop_WeightLb{WeightLb: 4.5}
+	laptops[1].Gpus = nil
+	laptops[2].Gpus = append(laptops[2].Gpus, sample.NewGPU())
+	laptops[2].Gpus[0].Name = `RTX | 4090 \ Ti`
+
+	for _, format := range serializer.Formats {
+		format := format
+
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+
+			_, read := roundTrip(t, format, laptops)
+			require.Len(t, read, len(laptops))
+			for i := range laptops {
+				require.True(t, proto.Equal(laptops[i], read[i]), "%v\n%v", laptops[i], read[i])
+			}
+
+			// um arquivo sem laptops também pode ser lido
+			_, read = roundTrip(t, format, nil)
+			require.Empty(t, read)
+		})
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Gpus = append(laptop.Gpus, sample.NewGPU())
+
+	data, _ := roundTrip(t, serializer.FormatCSV, []*pb.Laptop{laptop})
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	// uma coluna por campo escalar, com os valores das listas juntados
+	header := strings.Split(lines[0], ",")
+	require.Contains(t, header, "cpu.number_cores")
+	require.Contains(t, header, "gpus.memory.value")
+	require.Contains(t, header, "storages.driver")
+	require.Contains(t, header, "updated_at")
+	require.Contains(t, lines[1], laptop.Gpus[0].GetBrand()+"|"+laptop.Gpus[1].GetBrand())
+
+	// uma coluna que não é de um campo é um erro
+	reader, err := serializer.NewMessageReader(strings.NewReader("id,unknown\n1,2\n"), serializer.FormatCSV)
+	require.NoError(t, err)
+	require.Error(t, reader.Read(&pb.Laptop{}))
+}
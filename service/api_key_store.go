@@ -0,0 +1,179 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const apiKeyPrefix = "pcbk_"
+
+// APIKey is a long-lived credential for machine-to-machine clients.
+// Only the SHA-256 hash of the secret key is stored.
+type APIKey struct {
+	ID           string
+	Name         string
+	HashedKey    string
+	Scopes       []string
+	Owner        string
+	Organization string
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+	Revoked      bool
+}
+
+// NewAPIKey generates a new API key acting with the given scopes on behalf of the owner.
+// It returns the key and the secret to give to the client, which cannot be recovered later.
+func NewAPIKey(name string, scopes []string, owner string, organization string, expiresAt time.Time) (*APIKey, string, error) {
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate api key id: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate api key secret: %w", err)
+	}
+
+	key := &APIKey{
+		ID:           hex.EncodeToString(id),
+		Name:         name,
+		Scopes:       append([]string(nil), scopes...),
+		Owner:        owner,
+		Organization: organization,
+		CreatedAt:    time.Now(),
+		ExpiresAt:    expiresAt,
+	}
+
+	plainKey := apiKeyPrefix + key.ID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	key.HashedKey = hashAPIKey(plainKey)
+
+	return key, plainKey, nil
+}
+
+// ParseAPIKeyID returns the ID embedded in a plain API key
+func ParseAPIKeyID(plainKey string) (string, error) {
+	rest := strings.TrimPrefix(plainKey, apiKeyPrefix)
+	if rest == plainKey {
+		return "", fmt.Errorf("malformed api key")
+	}
+
+	i := strings.Index(rest, ".")
+	if i <= 0 {
+		return "", fmt.Errorf("malformed api key")
+	}
+
+	return rest[:i], nil
+}
+
+// IsCorrectKey checks if the plain key matches the stored hash
+func (key *APIKey) IsCorrectKey(plainKey string) bool {
+	return subtle.ConstantTimeCompare([]byte(key.HashedKey), []byte(hashAPIKey(plainKey))) == 1
+}
+
+// IsActive checks if the key is neither revoked nor expired
+func (key *APIKey) IsActive() bool {
+	if key.Revoked {
+		return false
+	}
+
+	return key.ExpiresAt.IsZero() || time.Now().Before(key.ExpiresAt)
+}
+
+func (key *APIKey) Clone() *APIKey {
+	other := *key
+	other.Scopes = append([]string(nil), key.Scopes...)
+	return &other
+}
+
+func hashAPIKey(plainKey string) string {
+	sum := sha256.Sum256([]byte(plainKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// APIKeyStore is an interface to store API keys
+type APIKeyStore interface {
+	// Save saves a new API key to the store
+	Save(key *APIKey) error
+	// Find finds an API key by ID
+	Find(id string) (*APIKey, error)
+	// List returns all API keys, including revoked ones
+	List() ([]*APIKey, error)
+	// Revoke marks an API key as revoked
+	Revoke(id string) error
+}
+
+// InMemoryAPIKeyStore stores API keys in memory
+type InMemoryAPIKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*APIKey
+}
+
+// NewInMemoryAPIKeyStore returns a new InMemoryAPIKeyStore
+func NewInMemoryAPIKeyStore() *InMemoryAPIKeyStore {
+	return &InMemoryAPIKeyStore{
+		keys: make(map[string]*APIKey),
+	}
+}
+
+func (store *InMemoryAPIKeyStore) Save(key *APIKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.keys[key.ID] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.keys[key.ID] = key.Clone()
+	return nil
+}
+
+func (store *InMemoryAPIKeyStore) Find(id string) (*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	key := store.keys[id]
+	if key == nil {
+		return nil, nil
+	}
+
+	return key.Clone(), nil
+}
+
+func (store *InMemoryAPIKeyStore) List() ([]*APIKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	keys := make([]*APIKey, 0, len(store.keys))
+	for _, key := range store.keys {
+		keys = append(keys, key.Clone())
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+func (store *InMemoryAPIKeyStore) Revoke(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := store.keys[id]
+	if key == nil {
+		return ErrNotFound
+	}
+
+	key.Revoked = true
+	return nil
+}
@@ -0,0 +1,151 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/pcbook-go/totp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func startTestAuthServer(t *testing.T, userStore service.UserStore, apiKeyStore service.APIKeyStore, policy *service.Policy) string {
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	interceptor := service.NewAuthInterceptor(jwtManager, apiKeyStore, policy)
+	authServer := service.NewAuthServer(userStore, jwtManager, service.WithAPIKeys(apiKeyStore, interceptor))
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil)
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterAuthServiceServer(grpcServer, authServer)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	admin, err := service.NewUser("admin1", "secret", "admin")
+	require.NoError(t, err)
+
+	// a política exige o segundo fator dos admins
+	admin.TOTPSecret, err = totp.GenerateSecret()
+	require.NoError(t, err)
+	admin.TOTPEnabled = true
+	require.NoError(t, userStore.Save(admin))
+
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+	serverAddress := startTestAuthServer(t, userStore, service.NewInMemoryAPIKeyStore(), policy)
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+
+	authClient := pb.NewAuthServiceClient(conn)
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	accessToken := loginWithTOTP(t, authClient, "admin1", "secret", admin.TOTPSecret)
+	adminCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", accessToken)
+
+	// um admin não pode emitir uma chave com mais permissões do que ele tem
+	_, err = authClient.CreateAPIKey(adminCtx, &pb.CreateAPIKeyRequest{Name: "import", Scopes: []string{service.SuperadminRole}})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	adminKey, err := authClient.CreateAPIKey(adminCtx, &pb.CreateAPIKeyRequest{Name: "import", Scopes: []string{"admin"}})
+	require.NoError(t, err)
+	require.Equal(t, "admin1", adminKey.GetApiKey().GetOwner())
+
+	userKey, err := authClient.CreateAPIKey(adminCtx, &pb.CreateAPIKeyRequest{Name: "ratings", Scopes: []string{"user"}})
+	require.NoError(t, err)
+
+	list, err := authClient.ListAPIKeys(adminCtx, &pb.ListAPIKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, list.GetApiKeys(), 2)
+
+	createLaptop := func(key string) error {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+		_, err := laptopClient.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+		return err
+	}
+
+	require.NoError(t, createLaptop(adminKey.GetKey()))
+	require.Equal(t, codes.PermissionDenied, status.Code(createLaptop(userKey.GetKey())))
+	require.Equal(t, codes.Unauthenticated, status.Code(createLaptop(adminKey.GetKey()+"x")))
+
+	// uma chave de API não pode gerenciar chaves sem o escopo de admin
+	userCtx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", userKey.GetKey())
+	_, err = authClient.ListAPIKeys(userCtx, &pb.ListAPIKeysRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = authClient.RevokeAPIKey(adminCtx, &pb.RevokeAPIKeyRequest{Id: adminKey.GetApiKey().GetId()})
+	require.NoError(t, err)
+	require.Equal(t, codes.Unauthenticated, status.Code(createLaptop(adminKey.GetKey())))
+}
+
+func TestServerAPIKeysScope(t *testing.T) {
+	t.Parallel()
+
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+
+	apiKeyStore := service.NewInMemoryAPIKeyStore()
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	interceptor := service.NewAuthInterceptor(jwtManager, apiKeyStore, policy)
+	server := service.NewAuthServer(service.NewInMemoryUserStore(), jwtManager, service.WithAPIKeys(apiKeyStore, interceptor))
+
+	owner := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin", Organization: "pcbook"})
+	colleague := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin2", Role: "admin", Organization: "pcbook"})
+	stranger := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin3", Role: "admin", Organization: "outra"})
+	superadmin := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "root", Role: service.SuperadminRole})
+
+	created, err := server.CreateAPIKey(owner, &pb.CreateAPIKeyRequest{Name: "import", Scopes: []string{"admin"}})
+	require.NoError(t, err)
+	keyID := created.GetApiKey().GetId()
+
+	listed := func(ctx context.Context) int {
+		res, err := server.ListAPIKeys(ctx, &pb.ListAPIKeysRequest{})
+		require.NoError(t, err)
+		return len(res.GetApiKeys())
+	}
+
+	// as chaves de outras organizações não são listadas nem podem ser revogadas
+	require.Equal(t, 1, listed(owner))
+	require.Equal(t, 1, listed(colleague))
+	require.Equal(t, 1, listed(superadmin))
+	require.Equal(t, 0, listed(stranger))
+
+	_, err = server.RevokeAPIKey(stranger, &pb.RevokeAPIKeyRequest{Id: keyID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	key, err := apiKeyStore.Find(keyID)
+	require.NoError(t, err)
+	require.False(t, key.Revoked)
+
+	_, err = server.RevokeAPIKey(colleague, &pb.RevokeAPIKeyRequest{Id: keyID})
+	require.NoError(t, err)
+
+	_, err = server.RevokeAPIKey(superadmin, &pb.RevokeAPIKeyRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pcbook-go/logging"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// AuditedMethods are the mutating RPCs recorded in the audit log
+var AuditedMethods = []string{
+	"/pcbook.AuthService/Login",
+	"/pcbook.AuthService/RefreshToken",
+	"/pcbook.AuthService/Logout",
+	"/pcbook.AuthService/CreateAPIKey",
+	"/pcbook.AuthService/RevokeAPIKey",
+	"/pcbook.AuthService/UnlockUser",
+	"/pcbook.AuthService/ChangePassword",
+	"/pcbook.AuthService/CreatePasswordResetToken",
+	"/pcbook.AuthService/ResetPassword",
+	"/pcbook.AuthService/EnrollTOTP",
+	"/pcbook.AuthService/ConfirmTOTP",
+	"/pcbook.AuthService/VerifySecondFactor",
+	"/pcbook.LaptopService/CreateLaptop",
+	"/pcbook.LaptopService/UpdateLaptop",
+	"/pcbook.LaptopService/DeleteLaptop",
+	"/pcbook.LaptopService/ImportLaptops",
+	"/pcbook.LaptopService/UploadImage",
+	"/pcbook.LaptopService/RateLaptop",
+	"/pcbook.WebhookService/RegisterWebhook",
+	"/pcbook.WebhookService/DeleteWebhook",
+	"/pcbook.WebhookService/RedeliverWebhook",
+}
+
+// auditTargetFields are the message fields holding the IDs of the resources a call acts on.
+// Nested messages like the laptop of CreateLaptopRequest are searched too.
+var auditTargetFields = map[protoreflect.Name]bool{
+	"id":        true,
+	"laptop_id": true,
+	"username":  true,
+	// the redelivered webhook deliveries
+	"delivery_id": true,
+}
+
+// maxAuditTargets caps the targets recorded for a stream, e.g. all laptops rated in a RateLaptop stream
+const maxAuditTargets = 100
+
+// AuditInterceptor is a server interceptor recording the calls of the audited methods.
+// It must be chained before the AuthInterceptor, to record the calls it rejects;
+// the caller is found by the AuthInterceptor during the call.
+type AuditInterceptor struct {
+	auditLog AuditLog
+	methods  map[string]bool
+}
+
+// NewAuditInterceptor returns a new audit interceptor recording the calls of the given methods
+func NewAuditInterceptor(auditLog AuditLog, methods ...string) *AuditInterceptor {
+	interceptor := &AuditInterceptor{
+		auditLog: auditLog,
+		methods:  make(map[string]bool),
+	}
+
+	for _, method := range methods {
+		interceptor.methods[method] = true
+	}
+
+	return interceptor
+}
+
+func (interceptor *AuditInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if !interceptor.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+
+		ctx, actor := withAuditActor(ctx)
+		targets := &auditTargets{}
+		targets.add(req)
+
+		res, err := handler(ctx, req)
+		if err == nil {
+			targets.add(res)
+		}
+
+		interceptor.record(ctx, actor, info.FullMethod, targets.list(), err)
+		return res, err
+	}
+}
+
+func (interceptor *AuditInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if !interceptor.methods[info.FullMethod] {
+			return handler(srv, stream)
+		}
+
+		ctx, actor := withAuditActor(stream.Context())
+		auditStream := &auditServerStream{
+			ServerStream: stream,
+			ctx:          ctx,
+			targets:      &auditTargets{},
+		}
+
+		err := handler(srv, auditStream)
+
+		interceptor.record(ctx, actor, info.FullMethod, auditStream.targets.list(), err)
+		return err
+	}
+}
+
+// record writes the audit entry of a call. A failure is logged, but the call has already been handled.
+func (interceptor *AuditInterceptor) record(ctx context.Context, actor *auditActor, method string, targets []string, err error) {
+	entry := &AuditEntry{
+		Time:    time.Now(),
+		Method:  method,
+		Targets: targets,
+		Code:    status.Code(err).String(),
+	}
+
+	claims, ok := actor.get()
+	if !ok {
+		claims, ok = UserClaimsFromContext(ctx)
+	}
+	if ok {
+		entry.Actor = claims.Username
+		entry.APIKeyID = claims.APIKeyID
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if ok && p.Addr != nil {
+		entry.Peer = p.Addr.String()
+	}
+
+	err = interceptor.auditLog.Record(entry)
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot record audit entry", "error", err)
+	}
+}
+
+// auditActor holds the caller of an audited call, set by the AuthInterceptor
+// as soon as the caller is authenticated, even if the call is then denied
+type auditActor struct {
+	mutex  sync.Mutex
+	claims *UserClaims
+}
+
+type auditActorKey struct{}
+
+// withAuditActor returns a copy of ctx where the caller of the call can be recorded
+func withAuditActor(ctx context.Context) (context.Context, *auditActor) {
+	actor := &auditActor{}
+	return context.WithValue(ctx, auditActorKey{}, actor), actor
+}
+
+// setAuditActor records the authenticated caller of the current call, to be audited when it ends
+func setAuditActor(ctx context.Context, claims *UserClaims) {
+	actor, ok := ctx.Value(auditActorKey{}).(*auditActor)
+	if !ok {
+		return
+	}
+
+	actor.mutex.Lock()
+	defer actor.mutex.Unlock()
+
+	actor.claims = claims
+}
+
+func (actor *auditActor) get() (*UserClaims, bool) {
+	actor.mutex.Lock()
+	defer actor.mutex.Unlock()
+
+	return actor.claims, actor.claims != nil
+}
+
+// auditServerStream collects the targets of the messages sent and received on a stream
+type auditServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	targets *auditTargets
+}
+
+func (stream *auditServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *auditServerStream) RecvMsg(m interface{}) error {
+	err := stream.ServerStream.RecvMsg(m)
+	if err == nil {
+		stream.targets.add(m)
+	}
+	return err
+}
+
+func (stream *auditServerStream) SendMsg(m interface{}) error {
+	err := stream.ServerStream.SendMsg(m)
+	if err == nil {
+		stream.targets.add(m)
+	}
+	return err
+}
+
+// auditTargets is a set of target IDs, kept in the order they are found
+type auditTargets struct {
+	mutex sync.Mutex
+	ids   []string
+	seen  map[string]bool
+}
+
+func (targets *auditTargets) add(m interface{}) {
+	message, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+
+	targets.mutex.Lock()
+	defer targets.mutex.Unlock()
+
+	targets.collect(message.ProtoReflect())
+}
+
+func (targets *auditTargets) collect(message protoreflect.Message) {
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.IsList() || field.IsMap() {
+			return true
+		}
+
+		switch {
+		case field.Kind() == protoreflect.MessageKind:
+			targets.collect(value.Message())
+		case field.Kind() == protoreflect.StringKind && auditTargetFields[field.Name()]:
+			id := value.String()
+			if targets.seen == nil {
+				targets.seen = make(map[string]bool)
+			}
+
+			if id != "" && !targets.seen[id] && len(targets.ids) < maxAuditTargets {
+				targets.seen[id] = true
+				targets.ids = append(targets.ids, id)
+			}
+		}
+
+		return true
+	})
+}
+
+func (targets *auditTargets) list() []string {
+	targets.mutex.Lock()
+	defer targets.mutex.Unlock()
+
+	return append([]string(nil), targets.ids...)
+}
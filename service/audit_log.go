@@ -0,0 +1,338 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditEntry records a call to a mutating RPC
+type AuditEntry struct {
+	Sequence uint64    `json:"seq"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor,omitempty"`
+	APIKeyID string    `json:"api_key_id,omitempty"`
+	Method   string    `json:"method"`
+	Targets  []string  `json:"targets,omitempty"`
+	Code     string    `json:"code"`
+	Peer     string    `json:"peer,omitempty"`
+
+	// PrevHash is the hash of the previous entry, chaining the entries so that
+	// modifying or removing one of them breaks the hashes of all the next ones
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// computeHash returns the SHA-256 hash of the entry without its own hash, which includes the previous hash
+func (entry *AuditEntry) computeHash() (string, error) {
+	other := *entry
+	other.Hash = ""
+
+	data, err := json.Marshal(&other)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal audit entry: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuditFilter selects audit entries. Empty fields match all entries.
+type AuditFilter struct {
+	Actor  string
+	Method string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	// Limit is the maximum number of entries, 0 means no limit
+	Limit int
+}
+
+// Match checks if the entry is selected by the filter
+func (filter *AuditFilter) Match(entry *AuditEntry) bool {
+	if filter.Actor != "" && entry.Actor != filter.Actor {
+		return false
+	}
+
+	if filter.Method != "" && entry.Method != filter.Method && !strings.HasSuffix(entry.Method, "/"+filter.Method) {
+		return false
+	}
+
+	if filter.Target != "" && !containsString(entry.Targets, filter.Target) {
+		return false
+	}
+
+	if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+		return false
+	}
+
+	if !filter.Until.IsZero() && !entry.Time.Before(filter.Until) {
+		return false
+	}
+
+	return true
+}
+
+// AuditLog is an interface to record and query audit entries
+type AuditLog interface {
+	// Record appends an entry, setting its sequence number and hashes
+	Record(entry *AuditEntry) error
+	// Query calls found for each entry selected by the filter, the oldest first,
+	// and stops reading after the limit of the filter
+	Query(filter AuditFilter, found func(entry *AuditEntry) error) error
+	// Head returns the sequence number and the hash of the last entry. Recorded outside of the log,
+	// they anchor the hash chain: removing the last entries changes the head.
+	Head() (uint64, string)
+}
+
+// ErrAuditLogTampered is returned when the hash chain of the audit log is broken
+var ErrAuditLogTampered = errors.New("audit log has been tampered with")
+
+// errStopScan stops reading the audit log once a query has found enough entries
+var errStopScan = errors.New("stop scan")
+
+// FileAuditLog appends the audit entries to a JSON lines file, each line holding one hash-chained entry
+type FileAuditLog struct {
+	mutex    sync.Mutex
+	filename string
+	file     *os.File
+	size     int64
+	sequence uint64
+	lastHash string
+}
+
+// NewFileAuditLog opens the audit log file, creating it if needed.
+// The hash chain of the existing entries is verified before appending new ones.
+func NewFileAuditLog(filename string) (*FileAuditLog, error) {
+	auditLog := &FileAuditLog{
+		filename: filename,
+	}
+
+	err := auditLog.scan(-1, func(entry *AuditEntry) error {
+		auditLog.sequence = entry.Sequence
+		auditLog.lastHash = entry.Hash
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot stat audit log file: %w", err)
+	}
+
+	auditLog.file = file
+	auditLog.size = info.Size()
+	return auditLog, nil
+}
+
+// Close closes the audit log file
+func (auditLog *FileAuditLog) Close() error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	return auditLog.file.Close()
+}
+
+func (auditLog *FileAuditLog) Record(entry *AuditEntry) error {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	other := *entry
+	other.Time = entry.Time.UTC()
+	other.Sequence = auditLog.sequence + 1
+	other.PrevHash = auditLog.lastHash
+
+	hash, err := other.computeHash()
+	if err != nil {
+		return err
+	}
+	other.Hash = hash
+
+	data, err := json.Marshal(&other)
+	if err != nil {
+		return fmt.Errorf("cannot marshal audit entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	_, err = auditLog.file.Write(data)
+	if err == nil {
+		err = auditLog.file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write audit entry: %w", err)
+	}
+
+	auditLog.size += int64(len(data))
+	auditLog.sequence = other.Sequence
+	auditLog.lastHash = other.Hash
+
+	*entry = other
+	return nil
+}
+
+func (auditLog *FileAuditLog) Query(filter AuditFilter, found func(entry *AuditEntry) error) error {
+	// only the entries written before the query are read, without blocking new ones
+	auditLog.mutex.Lock()
+	size := auditLog.size
+	auditLog.mutex.Unlock()
+
+	count := 0
+	err := auditLog.scan(size, func(entry *AuditEntry) error {
+		if !filter.Match(entry) {
+			return nil
+		}
+
+		err := found(entry)
+		if err != nil {
+			return err
+		}
+
+		count++
+		if filter.Limit > 0 && count >= filter.Limit {
+			return errStopScan
+		}
+		return nil
+	})
+	if errors.Is(err, errStopScan) {
+		return nil
+	}
+	return err
+}
+
+func (auditLog *FileAuditLog) Head() (uint64, string) {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+
+	return auditLog.sequence, auditLog.lastHash
+}
+
+// Verify checks the hash chain of the whole audit log, up to the head
+func (auditLog *FileAuditLog) Verify() error {
+	auditLog.mutex.Lock()
+	size, sequence, hash := auditLog.size, auditLog.sequence, auditLog.lastHash
+	auditLog.mutex.Unlock()
+
+	var last *AuditEntry
+	err := auditLog.scan(size, func(entry *AuditEntry) error {
+		last = entry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return checkAuditHead(last, sequence, hash)
+}
+
+// VerifyAuditHead checks the hash chain of the audit log file up to a head recorded before,
+// e.g. in the logs of the server: the entries must not have been removed or replaced since then
+func VerifyAuditHead(filename string, sequence uint64, hash string) error {
+	auditLog := &FileAuditLog{filename: filename}
+
+	var head *AuditEntry
+	err := auditLog.scan(-1, func(entry *AuditEntry) error {
+		if entry.Sequence == sequence {
+			head = entry
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errStopScan) {
+		return err
+	}
+
+	return checkAuditHead(head, sequence, hash)
+}
+
+// checkAuditHead checks that the entry is the head, a nil entry matching an empty log
+func checkAuditHead(entry *AuditEntry, sequence uint64, hash string) error {
+	if entry == nil {
+		if sequence == 0 {
+			return nil
+		}
+		return fmt.Errorf("%w: entry %d is missing", ErrAuditLogTampered, sequence)
+	}
+
+	if entry.Sequence != sequence || entry.Hash != hash {
+		return fmt.Errorf("%w: entry %d does not match the head %d", ErrAuditLogTampered, entry.Sequence, sequence)
+	}
+	return nil
+}
+
+// scan reads and verifies the entries in the first size bytes of the file, or the whole file if size is negative
+func (auditLog *FileAuditLog) scan(size int64, found func(entry *AuditEntry) error) error {
+	file, err := os.Open(auditLog.filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if size >= 0 {
+		reader = io.LimitReader(file, size)
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var sequence uint64
+	var lastHash string
+
+	for scanner.Scan() {
+		entry := &AuditEntry{}
+		err := json.Unmarshal(scanner.Bytes(), entry)
+		if err != nil {
+			return fmt.Errorf("%w: cannot parse entry after %d: %v", ErrAuditLogTampered, sequence, err)
+		}
+
+		hash, err := entry.computeHash()
+		if err != nil {
+			return err
+		}
+
+		if entry.Sequence != sequence+1 || entry.PrevHash != lastHash || entry.Hash != hash {
+			return fmt.Errorf("%w: entry %d does not match the chain", ErrAuditLogTampered, entry.Sequence)
+		}
+
+		sequence = entry.Sequence
+		lastHash = entry.Hash
+
+		err = found(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("cannot read audit log file: %w", err)
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, other := range values {
+		if other == value {
+			return true
+		}
+	}
+
+	return false
+}
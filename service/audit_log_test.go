@@ -0,0 +1,184 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func queryAuditLog(t *testing.T, auditLog service.AuditLog, filter service.AuditFilter) []*service.AuditEntry {
+	var entries []*service.AuditEntry
+	err := auditLog.Query(filter, func(entry *service.AuditEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return entries
+}
+
+func TestFileAuditLog(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := service.NewFileAuditLog(filename)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, auditLog.Record(&service.AuditEntry{Time: start, Actor: "admin1", Method: "/pcbook.LaptopService/CreateLaptop", Targets: []string{"laptop1"}, Code: "OK"}))
+	require.NoError(t, auditLog.Record(&service.AuditEntry{Time: start, Actor: "user1", Method: "/pcbook.LaptopService/RateLaptop", Targets: []string{"laptop1"}, Code: "OK"}))
+	require.NoError(t, auditLog.Close())
+
+	// a cadeia continua depois de reabrir o arquivo
+	auditLog, err = service.NewFileAuditLog(filename)
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	entry := &service.AuditEntry{Time: start.Add(time.Hour), Actor: "admin1", Method: "/pcbook.LaptopService/UploadImage", Targets: []string{"laptop2"}, Code: "OK"}
+	require.NoError(t, auditLog.Record(entry))
+	require.Equal(t, uint64(3), entry.Sequence)
+	require.NotEmpty(t, entry.PrevHash)
+
+	require.Len(t, queryAuditLog(t, auditLog, service.AuditFilter{}), 3)
+	require.Len(t, queryAuditLog(t, auditLog, service.AuditFilter{Actor: "admin1"}), 2)
+	require.Len(t, queryAuditLog(t, auditLog, service.AuditFilter{Method: "RateLaptop"}), 1)
+	require.Len(t, queryAuditLog(t, auditLog, service.AuditFilter{Target: "laptop1"}), 2)
+	require.Len(t, queryAuditLog(t, auditLog, service.AuditFilter{Since: start.Add(time.Minute)}), 1)
+	// o limite retorna as entradas mais antigas
+	limited := queryAuditLog(t, auditLog, service.AuditFilter{Limit: 2})
+	require.Len(t, limited, 2)
+	require.Equal(t, uint64(1), limited[0].Sequence)
+	require.Equal(t, uint64(2), limited[1].Sequence)
+	require.NoError(t, auditLog.Verify())
+
+	sequence, hash := auditLog.Head()
+	require.Equal(t, uint64(3), sequence)
+	require.Equal(t, entry.Hash, hash)
+	require.NoError(t, service.VerifyAuditHead(filename, sequence, hash))
+
+	// remover as últimas entradas mantém a cadeia, mas não a cabeça
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	require.NoError(t, os.WriteFile(filename, []byte(strings.Join(lines[:2], "")), 0600))
+	require.ErrorIs(t, service.VerifyAuditHead(filename, sequence, hash), service.ErrAuditLogTampered)
+	require.ErrorIs(t, auditLog.Verify(), service.ErrAuditLogTampered)
+	require.NoError(t, os.WriteFile(filename, data, 0600))
+
+	// alterar uma entrada quebra a cadeia de hashes
+	tampered := strings.Replace(string(data), `"actor":"user1"`, `"actor":"user2"`, 1)
+	require.NoError(t, os.WriteFile(filename, []byte(tampered), 0600))
+
+	require.ErrorIs(t, auditLog.Verify(), service.ErrAuditLogTampered)
+
+	_, err = service.NewFileAuditLog(filename)
+	require.ErrorIs(t, err, service.ErrAuditLogTampered)
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	t.Parallel()
+
+	auditLog, err := service.NewFileAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	interceptor := service.NewAuditInterceptor(auditLog, service.AuditedMethods...)
+	unary := interceptor.Unary()
+
+	ctx := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin"})
+	laptop := sample.NewLaptop()
+
+	_, err = unary(ctx, &pb.CreateLaptopRequest{Laptop: laptop}, &grpc.UnaryServerInfo{FullMethod: "/pcbook.LaptopService/CreateLaptop"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &pb.CreateLaptopResponse{Id: laptop.GetId()}, nil
+		})
+	require.NoError(t, err)
+
+	_, err = unary(ctx, &pb.UpdateLaptopRequest{Laptop: laptop}, &grpc.UnaryServerInfo{FullMethod: "/pcbook.LaptopService/UpdateLaptop"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, status.Error(codes.PermissionDenied, "denied")
+		})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// métodos que não alteram nada não são registrados
+	_, err = unary(ctx, &pb.FindLaptopRequest{Id: laptop.GetId()}, &grpc.UnaryServerInfo{FullMethod: "/pcbook.LaptopService/FindLaptop"},
+		func(ctx context.Context, req interface{}) (interface{}, error) {
+			return &pb.SearchLaptopResponse{Laptop: laptop}, nil
+		})
+	require.NoError(t, err)
+
+	entries := queryAuditLog(t, auditLog, service.AuditFilter{})
+	require.Len(t, entries, 2)
+
+	require.Equal(t, "admin1", entries[0].Actor)
+	require.Equal(t, "/pcbook.LaptopService/CreateLaptop", entries[0].Method)
+	require.Equal(t, []string{laptop.GetId()}, entries[0].Targets)
+	require.Equal(t, codes.OK.String(), entries[0].Code)
+
+	require.Equal(t, codes.PermissionDenied.String(), entries[1].Code)
+	require.Equal(t, entries[0].Hash, entries[1].PrevHash)
+}
+
+func TestAuditInterceptorDeniedCalls(t *testing.T) {
+	t.Parallel()
+
+	auditLog, err := service.NewFileAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+
+	// o interceptor de auditoria vem antes do de autenticação, para registrar as chamadas recusadas por ele
+	audit := service.NewAuditInterceptor(auditLog, service.AuditedMethods...).Unary()
+	auth := service.NewAuthInterceptor(jwtManager, nil, policy).Unary()
+	info := &grpc.UnaryServerInfo{FullMethod: "/pcbook.LaptopService/CreateLaptop"}
+	createLaptop := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return audit(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return auth(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return &pb.CreateLaptopResponse{}, nil
+			})
+		})
+	}
+
+	laptop := sample.NewLaptop()
+
+	_, err = createLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	user, err := service.NewUser("user1", "secret", "user")
+	require.NoError(t, err)
+	accessToken, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+	userCtx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", accessToken))
+
+	_, err = createLaptop(userCtx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	entries := queryAuditLog(t, auditLog, service.AuditFilter{})
+	require.Len(t, entries, 2)
+
+	require.Empty(t, entries[0].Actor)
+	require.Equal(t, codes.Unauthenticated.String(), entries[0].Code)
+	require.Equal(t, []string{laptop.GetId()}, entries[0].Targets)
+
+	// o usuário autenticado é registrado mesmo quando não tem permissão
+	require.Equal(t, "user1", entries[1].Actor)
+	require.Equal(t, "/pcbook.LaptopService/CreateLaptop", entries[1].Method)
+	require.Equal(t, codes.PermissionDenied.String(), entries[1].Code)
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/pcbook-go/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The headers of QueryAuditLog with the head of the audit log when the query started
+const (
+	AuditHeadSequenceHeader = "x-audit-head-sequence"
+	AuditHeadHashHeader     = "x-audit-head-hash"
+)
+
+// AuditServer is the server that provides access to the audit log
+type AuditServer struct {
+	pb.UnimplementedAuditServiceServer
+	auditLog AuditLog
+}
+
+// NewAuditServer returns a new audit server
+func NewAuditServer(auditLog AuditLog) *AuditServer {
+	return &AuditServer{auditLog: auditLog}
+}
+
+// QueryAuditLog is a server-streaming RPC to find the audit entries matching a filter, the oldest first.
+// The head of the log is sent in the headers, so that the callers can record it and detect a truncation later.
+func (server *AuditServer) QueryAuditLog(req *pb.QueryAuditLogRequest, stream pb.AuditService_QueryAuditLogServer) error {
+	filter := AuditFilter{
+		Actor:  req.GetActor(),
+		Method: req.GetMethod(),
+		Target: req.GetTarget(),
+		Limit:  int(req.GetLimit()),
+	}
+
+	if req.GetSince() != nil {
+		filter.Since = req.GetSince().AsTime()
+	}
+
+	if req.GetUntil() != nil {
+		filter.Until = req.GetUntil().AsTime()
+	}
+
+	sequence, hash := server.auditLog.Head()
+	err := stream.SendHeader(metadata.Pairs(
+		AuditHeadSequenceHeader, strconv.FormatUint(sequence, 10),
+		AuditHeadHashHeader, hash,
+	))
+	if err != nil {
+		return err
+	}
+
+	err = server.auditLog.Query(filter, func(entry *AuditEntry) error {
+		err := stream.Context().Err()
+		if err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		return stream.Send(&pb.QueryAuditLogResponse{Entry: auditEntryToProto(entry)})
+	})
+
+	if errors.Is(err, ErrAuditLogTampered) {
+		return status.Errorf(codes.DataLoss, "%v", err)
+	}
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return status.Errorf(codes.Internal, "cannot query audit log: %v", err)
+	}
+
+	return nil
+}
+
+func auditEntryToProto(entry *AuditEntry) *pb.AuditEntry {
+	return &pb.AuditEntry{
+		Sequence: entry.Sequence,
+		Time:     timestamppb.New(entry.Time),
+		Actor:    entry.Actor,
+		ApiKeyId: entry.APIKeyID,
+		Method:   entry.Method,
+		Targets:  entry.Targets,
+		Code:     entry.Code,
+		Peer:     entry.Peer,
+		PrevHash: entry.PrevHash,
+		Hash:     entry.Hash,
+	}
+}
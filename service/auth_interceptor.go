@@ -2,21 +2,48 @@ package service
 
 import (
 	"context"
-	"log"
+	"sync"
 
+	"github.com/pcbook-go/logging"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 type AuthInterceptor struct {
-	jwtManager      *JWTManager
-	accessibleRoles map[string][]string
+	jwtManager  *JWTManager
+	apiKeyStore APIKeyStore
+	mutex       sync.RWMutex
+	policy      *Policy
 }
 
-func NewAuthInterceptor(jwtManager *JWTManager, accessibleRoles map[string][]string) *AuthInterceptor {
-	return &AuthInterceptor{jwtManager, accessibleRoles}
+// NewAuthInterceptor returns a new auth interceptor.
+// Clients can authenticate with an access token or, if apiKeyStore is not nil, with an API key.
+func NewAuthInterceptor(jwtManager *JWTManager, apiKeyStore APIKeyStore, policy *Policy) *AuthInterceptor {
+	return &AuthInterceptor{
+		jwtManager:  jwtManager,
+		apiKeyStore: apiKeyStore,
+		policy:      policy,
+	}
+}
+
+// SetPolicy replaces the access control policy, e.g. after the policy file is reloaded
+func (interceptor *AuthInterceptor) SetPolicy(policy *Policy) {
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	interceptor.policy = policy
+}
+
+// Policy returns the current access control policy
+func (interceptor *AuthInterceptor) Policy() *Policy {
+	interceptor.mutex.RLock()
+	defer interceptor.mutex.RUnlock()
+
+	return interceptor.policy
 }
 
 func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
@@ -26,13 +53,15 @@ func (interceptor *AuthInterceptor) Unary() grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		log.Println("--> unary interceptor: ", info.FullMethod)
-
-		err := interceptor.authorize(ctx, info.FullMethod)
+		claims, err := interceptor.authorize(ctx, info.FullMethod)
 		if err != nil {
 			return nil, err
 		}
 
+		if claims != nil {
+			ctx = ContextWithUserClaims(ctx, claims)
+		}
+
 		return handler(ctx, req)
 	}
 }
@@ -44,44 +73,154 @@ func (interceptor *AuthInterceptor) Stream() grpc.StreamServerInterceptor {
 		info *grpc.StreamServerInfo,
 		handler grpc.StreamHandler,
 	) error {
-		log.Println("--> stream interceptor: ", info.FullMethod)
-
-		err := interceptor.authorize(stream.Context(), info.FullMethod)
+		claims, err := interceptor.authorize(stream.Context(), info.FullMethod)
 		if err != nil {
 			return err
 		}
 
+		if claims != nil {
+			stream = &serverStreamWithContext{
+				ServerStream: stream,
+				ctx:          ContextWithUserClaims(stream.Context(), claims),
+			}
+		}
+
 		return handler(srv, stream)
 	}
 }
 
-func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) error {
-	accessibleRoles, ok := interceptor.accessibleRoles[method]
-	if !ok {
-		return nil
+// authorize checks if the caller can access the method and returns its verified claims,
+// which are nil for public methods
+func (interceptor *AuthInterceptor) authorize(ctx context.Context, method string) (*UserClaims, error) {
+	policy := interceptor.Policy()
+	if !policy.IsAllowed(method) {
+		return nil, status.Error(codes.PermissionDenied, "no permission to access this RPC")
 	}
 
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return status.Errorf(codes.Unauthenticated, "metadata is not provided")
+	if policy.IsPublic(method) {
+		return nil, nil
+	}
+
+	claims, err := interceptor.authenticate(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+	logging.SetUser(ctx, claims.Username)
+	setAuditActor(ctx, claims)
+
+	if !policy.HasAccess(method, claims.Roles()...) {
+		return nil, status.Error(codes.PermissionDenied, "no permission to access this RPC")
+	}
+
+	// API keys and client certificates are not issued to people, only tokens need the second factor
+	if claims.TokenType == accessTokenType && !claims.MFA && policy.RequiresMFA(method, claims.Roles()...) {
+		return nil, status.Error(codes.PermissionDenied, "second factor authentication is required, enroll with EnrollTOTP")
+	}
+
+	return claims, nil
+}
+
+// authenticate verifies the API key or the access token sent in the metadata,
+// falling back to the identity of the mutual TLS client certificate
+func (interceptor *AuthInterceptor) authenticate(ctx context.Context, policy *Policy) (*UserClaims, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	if values := md["x-api-key"]; len(values) > 0 {
+		return interceptor.verifyAPIKey(values[0])
 	}
 
 	values := md["authorization"]
 	if len(values) == 0 {
-		return status.Errorf(codes.Unauthenticated, "authorization token is not provided")
+		claims, ok := certificateClaims(ctx, policy)
+		if ok {
+			return claims, nil
+		}
+
+		return nil, status.Errorf(codes.Unauthenticated, "authorization token is not provided")
 	}
 
 	accessToken := values[0]
 	claims, err := interceptor.jwtManager.Verify(accessToken)
 	if err != nil {
-		return status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
+		return nil, status.Errorf(codes.Unauthenticated, "access token is invalid: %v", err)
 	}
 
-	for _, role := range accessibleRoles {
-		if role == claims.Role {
-			return nil
-		}
+	return claims, nil
+}
+
+func (interceptor *AuthInterceptor) verifyAPIKey(plainKey string) (*UserClaims, error) {
+	if interceptor.apiKeyStore == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "api keys are not accepted")
+	}
+
+	id, err := ParseAPIKeyID(plainKey)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "api key is invalid: %v", err)
+	}
+
+	key, err := interceptor.apiKeyStore.Find(id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find api key: %v", err)
+	}
+
+	if key == nil || !key.IsCorrectKey(plainKey) || !key.IsActive() {
+		return nil, status.Errorf(codes.Unauthenticated, "api key is invalid")
+	}
+
+	claims := &UserClaims{
+		Username:     key.Owner,
+		Organization: key.Organization,
+		Scopes:       key.Scopes,
+		APIKeyID:     key.ID,
+	}
+	return claims, nil
+}
+
+// certificateClaims maps the verified client certificate of the peer to a user of the policy
+func certificateClaims(ctx context.Context, policy *Policy) (*UserClaims, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
 	}
 
-	return status.Error(codes.PermissionDenied, "no permission to access this RPC")
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	subject := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+	identity, ok := policy.Certificates[subject]
+	if !ok {
+		return nil, false
+	}
+
+	claims := &UserClaims{
+		Username:     identity.Username,
+		Role:         identity.Role,
+		Organization: identity.Organization,
+	}
+	return claims, true
+}
+
+type userClaimsKey struct{}
+
+// ContextWithUserClaims returns a copy of ctx carrying the verified user claims
+func ContextWithUserClaims(ctx context.Context, claims *UserClaims) context.Context {
+	return context.WithValue(ctx, userClaimsKey{}, claims)
+}
+
+// UserClaimsFromContext returns the verified user claims of the request, if any
+func UserClaimsFromContext(ctx context.Context) (*UserClaims, bool) {
+	claims, ok := ctx.Value(userClaimsKey{}).(*UserClaims)
+	return claims, ok
+}
+
+// serverStreamWithContext is a server stream with a replaced context
+type serverStreamWithContext struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *serverStreamWithContext) Context() context.Context {
+	return stream.ctx
 }
@@ -2,43 +2,820 @@ package service
 
 import (
 	"context"
+	"errors"
+	"math"
+	"net"
+	"time"
 
+	"github.com/pcbook-go/logging"
 	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/totp"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type AuthServer struct {
 	userStore UserStore
 	pb.UnimplementedAuthServiceServer
-	jwtManager *JWTManager
+	jwtManager   *JWTManager
+	apiKeyStore  APIKeyStore
+	policies     PolicySource
+	loginLimiter *LoginLimiter
+	hasher       PasswordHasher
+
+	passwordPolicy     *PasswordPolicy
+	resetStore         PasswordResetStore
+	resetTokenDuration time.Duration
+}
+
+// totpIssuer is the name shown by authenticator apps
+const totpIssuer = "pcbook"
+
+// AuthServerOption configures an optional feature of the auth server
+type AuthServerOption func(server *AuthServer)
+
+// PolicySource provides the current access control policy
+type PolicySource interface {
+	Policy() *Policy
+}
+
+// WithAPIKeys enables the API key RPCs. The scopes of new keys are checked against the policy.
+func WithAPIKeys(apiKeyStore APIKeyStore, policies PolicySource) AuthServerOption {
+	return func(server *AuthServer) {
+		server.apiKeyStore = apiKeyStore
+		server.policies = policies
+	}
+}
+
+// WithLoginLimiter enables the protection against brute-force attacks on Login
+func WithLoginLimiter(loginLimiter *LoginLimiter) AuthServerOption {
+	return func(server *AuthServer) {
+		server.loginLimiter = loginLimiter
+	}
+}
+
+// WithPasswordHasher sets the hashing policy. Passwords hashed with another algorithm
+// or weaker parameters are rehashed on the next successful login.
+func WithPasswordHasher(hasher PasswordHasher) AuthServerOption {
+	return func(server *AuthServer) {
+		server.hasher = hasher
+	}
+}
+
+// WithPasswordPolicy sets the policy new passwords must comply with
+func WithPasswordPolicy(passwordPolicy *PasswordPolicy) AuthServerOption {
+	return func(server *AuthServer) {
+		server.passwordPolicy = passwordPolicy
+	}
+}
+
+// WithPasswordReset enables the password reset RPCs. Reset tokens are valid for tokenDuration,
+// and the roles of the users they are created for are checked against the policy.
+func WithPasswordReset(resetStore PasswordResetStore, tokenDuration time.Duration, policies PolicySource) AuthServerOption {
+	return func(server *AuthServer) {
+		server.resetStore = resetStore
+		server.resetTokenDuration = tokenDuration
+		server.policies = policies
+	}
 }
 
 // NewAuthServer returns a new auth server
-func NewAuthServer(userStore UserStore, jwtManager *JWTManager) *AuthServer {
-	return &AuthServer{
+func NewAuthServer(userStore UserStore, jwtManager *JWTManager, options ...AuthServerOption) *AuthServer {
+	server := &AuthServer{
 		UnimplementedAuthServiceServer: pb.UnimplementedAuthServiceServer{},
 		userStore:                      userStore,
 		jwtManager:                     jwtManager,
+		hasher:                         DefaultPasswordHasher,
+		passwordPolicy:                 DefaultPasswordPolicy(),
+	}
+
+	for _, option := range options {
+		option(server)
 	}
+
+	return server
 }
 
 // Login is a unary RPC to login user
 func (server *AuthServer) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
+	limiterKeys := loginLimiterKeys(ctx, req.GetUsername())
+	if server.loginLimiter != nil {
+		wait := server.loginLimiter.Check(limiterKeys...)
+		if wait > 0 {
+			return nil, tooManyAttemptsError(ctx, wait)
+		}
+	}
+
 	user, err := server.userStore.Find(req.GetUsername())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
 	}
 
 	if user == nil || !user.IsCorrectPassword(req.GetPassword()) {
+		if server.loginLimiter != nil {
+			server.loginLimiter.Failure(limiterKeys...)
+		}
 		return nil, status.Errorf(codes.NotFound, "incorrect username/password")
 	}
 
-	token, err := server.jwtManager.Generate(user)
+	server.upgradePasswordHash(ctx, user, req.GetPassword())
+
+	// the failed attempts are only reset by the second factor,
+	// otherwise knowing the password would allow guessing codes endlessly
+	if user.TOTPEnabled {
+		challengeToken, err := server.jwtManager.GenerateChallengeToken(user)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot generate challenge token")
+		}
+
+		res := &pb.LoginResponse{
+			MfaRequired:    true,
+			ChallengeToken: challengeToken,
+		}
+		return res, nil
+	}
+
+	// only the username is reset, so that a valid account cannot be used to
+	// clear the failures of an address guessing passwords of other accounts
+	if server.loginLimiter != nil {
+		server.loginLimiter.Reset(limiterKeys[0])
+	}
+
+	accessToken, refreshToken, err := server.generateTokens(user, false)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.LoginResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+	return res, nil
+}
+
+// RefreshToken is a unary RPC to exchange a refresh token for a new pair of tokens
+func (server *AuthServer) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	claims, user, err := server.verifyRefreshToken(req.GetRefreshToken())
+	if err != nil {
+		return nil, err
+	}
+
+	// refresh tokens are single use: the old one is revoked before issuing a new one,
+	// and only one of the concurrent refreshes with the same token can revoke it
+	err = server.jwtManager.Revoke(claims)
+	if errors.Is(err, ErrTokenRevoked) {
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token has already been used")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke refresh token: %v", err)
+	}
+
+	accessToken, refreshToken, err := server.generateTokens(user, claims.MFA)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.RefreshTokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+	return res, nil
+}
+
+// verifyRefreshToken verifies the refresh token and returns its claims and user,
+// rejecting the tokens issued before the last password change of the user
+func (server *AuthServer) verifyRefreshToken(refreshToken string) (*UserClaims, *User, error) {
+	claims, err := server.jwtManager.VerifyRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Unauthenticated, "refresh token is invalid: %v", err)
+	}
+
+	user, err := server.userStore.Find(claims.Username)
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil {
+		return nil, nil, status.Errorf(codes.Unauthenticated, "user no longer exists")
+	}
+
+	if !claims.IsCurrent(user) {
+		return nil, nil, status.Errorf(codes.Unauthenticated, "refresh token has been invalidated by a password change")
+	}
+
+	return claims, user, nil
+}
+
+// Logout is a unary RPC to revoke a refresh token
+func (server *AuthServer) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	claims, err := server.jwtManager.VerifyRefreshToken(req.GetRefreshToken())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token is invalid: %v", err)
+	}
+
+	err = server.jwtManager.Revoke(claims)
+	if errors.Is(err, ErrTokenRevoked) {
+		return nil, status.Errorf(codes.Unauthenticated, "refresh token has already been revoked")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke refresh token: %v", err)
+	}
+
+	return &pb.LogoutResponse{}, nil
+}
+
+// GetPublicKeys is a unary RPC to get the public keys used to verify tokens, shaped as a JWK set
+func (server *AuthServer) GetPublicKeys(ctx context.Context, req *pb.GetPublicKeysRequest) (*pb.GetPublicKeysResponse, error) {
+	res := &pb.GetPublicKeysResponse{
+		Keys: server.jwtManager.PublicKeys(),
+	}
+	return res, nil
+}
+
+// CreateAPIKey is a unary RPC to issue an API key acting on behalf of the caller
+func (server *AuthServer) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	if server.apiKeyStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "api keys are not enabled")
+	}
+
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	if req.GetName() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "api key name is required")
+	}
+
+	if len(req.GetScopes()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "at least one scope is required")
+	}
+
+	// a key cannot have more permissions than the user creating it
+	policy := server.policies.Policy()
+	for _, scope := range req.GetScopes() {
+		if !canGrant(policy, claims, scope) {
+			return nil, status.Errorf(codes.PermissionDenied, "cannot grant scope %q", scope)
+		}
+	}
+
+	var expiresAt time.Time
+	if req.GetExpiresAt() != nil {
+		expiresAt = req.GetExpiresAt().AsTime()
+		if !expiresAt.After(time.Now()) {
+			return nil, status.Errorf(codes.InvalidArgument, "expiration time must be in the future")
+		}
+	}
+
+	key, plainKey, err := NewAPIKey(req.GetName(), req.GetScopes(), claims.Username, claims.Organization, expiresAt)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate api key: %v", err)
+	}
+
+	err = server.apiKeyStore.Save(key)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save api key: %v", err)
+	}
+
+	res := &pb.CreateAPIKeyResponse{
+		ApiKey: apiKeyToProto(key),
+		Key:    plainKey,
+	}
+	return res, nil
+}
+
+// ListAPIKeys is a unary RPC to list the API keys of the caller and of their organization,
+// or all API keys for a superadmin
+func (server *AuthServer) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	if server.apiKeyStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "api keys are not enabled")
+	}
+
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	keys, err := server.apiKeyStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list api keys: %v", err)
+	}
+
+	res := &pb.ListAPIKeysResponse{}
+	for _, key := range keys {
+		if canManageAPIKey(claims, key) {
+			res.ApiKeys = append(res.ApiKeys, apiKeyToProto(key))
+		}
+	}
+	return res, nil
+}
+
+// RevokeAPIKey is a unary RPC to revoke an API key of the caller or of their organization,
+// or any API key for a superadmin
+func (server *AuthServer) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	if server.apiKeyStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "api keys are not enabled")
+	}
+
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	key, err := server.apiKeyStore.Find(req.GetId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find api key: %v", err)
+	}
+
+	// the keys of other organizations are not found, so that their IDs cannot be guessed
+	if key == nil || !canManageAPIKey(claims, key) {
+		return nil, status.Errorf(codes.NotFound, "api key %s not found", req.GetId())
+	}
+
+	err = server.apiKeyStore.Revoke(key.ID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "api key %s not found", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke api key: %v", err)
+	}
+
+	return &pb.RevokeAPIKeyResponse{}, nil
+}
+
+// UnlockUser is a unary RPC to clear the failed login attempts of a username and/or peer address
+func (server *AuthServer) UnlockUser(ctx context.Context, req *pb.UnlockUserRequest) (*pb.UnlockUserResponse, error) {
+	if server.loginLimiter == nil {
+		return nil, status.Errorf(codes.Unimplemented, "login attempts are not limited")
+	}
+
+	var keys []string
+	if req.GetUsername() != "" {
+		keys = append(keys, usernameLimiterKey(req.GetUsername()))
+	}
+	if req.GetPeerAddress() != "" {
+		keys = append(keys, peerLimiterKey(req.GetPeerAddress()))
+	}
+
+	if len(keys) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "username or peer address is required")
+	}
+
+	res := &pb.UnlockUserResponse{
+		WasLocked: server.loginLimiter.Reset(keys...),
+	}
+	return res, nil
+}
+
+// ChangePassword is a unary RPC for the caller to change their own password
+func (server *AuthServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	user, err := server.callerUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// a stolen access token must not allow guessing the password faster than Login does
+	limiterKeys := []string{usernameLimiterKey(user.Username)}
+	if server.loginLimiter != nil {
+		wait := server.loginLimiter.Check(limiterKeys...)
+		if wait > 0 {
+			return nil, tooManyAttemptsError(ctx, wait)
+		}
+	}
+
+	if !user.IsCorrectPassword(req.GetOldPassword()) {
+		if server.loginLimiter != nil {
+			server.loginLimiter.Failure(limiterKeys...)
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "old password is incorrect")
+	}
+
+	err = server.setPassword(user, req.GetNewPassword())
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+// CreatePasswordResetToken is a unary RPC for an admin to issue a one-time password reset token for a user
+func (server *AuthServer) CreatePasswordResetToken(
+	ctx context.Context,
+	req *pb.CreatePasswordResetTokenRequest,
+) (*pb.CreatePasswordResetTokenResponse, error) {
+	if server.resetStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "password reset is not enabled")
+	}
+
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	if req.GetUsername() == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "username is required")
+	}
+
+	user, err := server.userStore.Find(req.GetUsername())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil {
+		return nil, status.Errorf(codes.NotFound, "user %s not found", req.GetUsername())
+	}
+
+	// resetting the password of a user is taking over their account,
+	// so it is only allowed for users with less or the same permissions
+	if !canGrant(server.policies.Policy(), claims, user.Role) {
+		return nil, status.Errorf(codes.PermissionDenied, "cannot reset the password of user %s", user.Username)
+	}
+
+	token, plainToken, err := NewPasswordResetToken(user.Username, claims.Username, server.resetTokenDuration)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate reset token: %v", err)
+	}
+
+	err = server.resetStore.Save(token)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save reset token: %v", err)
+	}
+
+	res := &pb.CreatePasswordResetTokenResponse{
+		ResetToken: plainToken,
+		ExpiresAt:  timestamppb.New(token.ExpiresAt),
+	}
+	return res, nil
+}
+
+// ResetPassword is a unary RPC to choose a new password with a reset token
+func (server *AuthServer) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	if server.resetStore == nil {
+		return nil, status.Errorf(codes.Unimplemented, "password reset is not enabled")
+	}
+
+	hashedToken := HashPasswordResetToken(req.GetResetToken())
+	token, err := server.resetStore.Find(hashedToken)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find reset token: %v", err)
+	}
+
+	if token == nil || token.IsExpired() {
+		return nil, status.Errorf(codes.Unauthenticated, "reset token is invalid or expired")
+	}
+
+	user, err := server.userStore.Find(token.Username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "user no longer exists")
+	}
+
+	// the token is only consumed by a password that complies with the policy
+	err = server.passwordPolicy.Validate(user.Username, req.GetNewPassword())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	err = server.resetStore.Delete(hashedToken)
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.Unauthenticated, "reset token is invalid or expired")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete reset token: %v", err)
+	}
+
+	err = server.setPassword(user, req.GetNewPassword())
+	if err != nil {
+		return nil, err
+	}
+
+	if server.loginLimiter != nil {
+		server.loginLimiter.Reset(usernameLimiterKey(user.Username))
+	}
+
+	return &pb.ResetPasswordResponse{}, nil
+}
+
+// EnrollTOTP is a unary RPC for the caller to start the enrollment of a TOTP authenticator app.
+// The second factor is only required at login once the enrollment is confirmed with ConfirmTOTP.
+func (server *AuthServer) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPResponse, error) {
+	user, err := server.callerUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// otherwise a stolen access token could replace the authenticator of the user
+	if user.TOTPEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "totp is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastCounter = 0
+	err = server.userStore.Update(user)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save user: %v", err)
+	}
+
+	res := &pb.EnrollTOTPResponse{
+		Secret: secret,
+		Uri:    totp.URI(totpIssuer, user.Username, secret),
+	}
+	return res, nil
+}
+
+// ConfirmTOTP is a unary RPC to enable the second factor with a first code of the authenticator app.
+// It returns the recovery codes, which are not shown again.
+func (server *AuthServer) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPResponse, error) {
+	user, err := server.callerUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.TOTPEnabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "totp is already enabled")
+	}
+
+	if user.TOTPSecret == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "totp enrollment has not been started")
+	}
+
+	if !user.VerifyTOTP(req.GetCode(), time.Now()) {
+		return nil, status.Errorf(codes.InvalidArgument, "totp code is invalid")
+	}
+
+	recoveryCodes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	user.TOTPEnabled = true
+	user.RecoveryCodes = hashedCodes
+	err = server.userStore.Update(user)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save user: %v", err)
+	}
+
+	res := &pb.ConfirmTOTPResponse{
+		RecoveryCodes: recoveryCodes,
+	}
+	return res, nil
+}
+
+// VerifySecondFactor is a unary RPC to exchange the challenge token returned by Login
+// and a TOTP or recovery code for the access and refresh tokens
+func (server *AuthServer) VerifySecondFactor(
+	ctx context.Context,
+	req *pb.VerifySecondFactorRequest,
+) (*pb.VerifySecondFactorResponse, error) {
+	claims, err := server.jwtManager.VerifyChallengeToken(req.GetChallengeToken())
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "challenge token is invalid: %v", err)
+	}
+
+	limiterKeys := []string{usernameLimiterKey(claims.Username)}
+	if server.loginLimiter != nil {
+		wait := server.loginLimiter.Check(limiterKeys...)
+		if wait > 0 {
+			return nil, tooManyAttemptsError(ctx, wait)
+		}
+	}
+
+	user, err := server.userStore.Find(claims.Username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil || !user.TOTPEnabled {
+		return nil, status.Errorf(codes.Unauthenticated, "second factor is not enabled")
+	}
+
+	if !claims.IsCurrent(user) {
+		return nil, status.Errorf(codes.Unauthenticated, "challenge token has been invalidated by a password change")
+	}
+
+	var valid bool
+	switch factor := req.GetFactor().(type) {
+	case *pb.VerifySecondFactorRequest_Code:
+		valid = user.VerifyTOTP(factor.Code, time.Now())
+	case *pb.VerifySecondFactorRequest_RecoveryCode:
+		valid = user.UseRecoveryCode(factor.RecoveryCode)
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "totp code or recovery code is required")
+	}
+
+	if !valid {
+		if server.loginLimiter != nil {
+			server.loginLimiter.Failure(limiterKeys...)
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "second factor is invalid")
+	}
+
+	// challenge tokens are single use: only one of the concurrent calls with the same token can revoke it
+	err = server.jwtManager.Revoke(claims)
+	if errors.Is(err, ErrTokenRevoked) {
+		return nil, status.Errorf(codes.Unauthenticated, "challenge token has already been used")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot revoke challenge token: %v", err)
+	}
+
+	// saves the last used code and the remaining recovery codes, so that they cannot be used again
+	err = server.userStore.Update(user)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "cannot generate access token")
+		return nil, status.Errorf(codes.Internal, "cannot save user: %v", err)
 	}
 
-	res := &pb.LoginResponse{AccessToken: token}
+	if server.loginLimiter != nil {
+		server.loginLimiter.Reset(limiterKeys...)
+	}
+
+	accessToken, refreshToken, err := server.generateTokens(user, true)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.VerifySecondFactorResponse{
+		AccessToken:            accessToken,
+		RefreshToken:           refreshToken,
+		RemainingRecoveryCodes: int32(len(user.RecoveryCodes)),
+	}
 	return res, nil
 }
+
+// callerUser returns the user authenticated with an access token
+func (server *AuthServer) callerUser(ctx context.Context) (*User, error) {
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	if claims.APIKeyID != "" {
+		return nil, status.Errorf(codes.PermissionDenied, "api keys cannot manage user credentials")
+	}
+
+	user, err := server.userStore.Find(claims.Username)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+	}
+
+	if user == nil {
+		return nil, status.Errorf(codes.NotFound, "user %s has no credentials", claims.Username)
+	}
+
+	return user, nil
+}
+
+// setPassword validates the new password of the user against the policy, then saves its hash.
+// The refresh tokens issued before are invalidated, so that a stolen session ends with the password change.
+func (server *AuthServer) setPassword(user *User, password string) error {
+	err := server.passwordPolicy.Validate(user.Username, password)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	err = user.SetPassword(password, server.hasher)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot hash password: %v", err)
+	}
+	user.TokenGeneration++
+
+	err = server.userStore.Update(user)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot save user: %v", err)
+	}
+
+	return nil
+}
+
+// upgradePasswordHash rehashes the password if the stored hash does not follow the hashing policy.
+// The login still succeeds if the upgrade fails, it will be retried on the next login.
+func (server *AuthServer) upgradePasswordHash(ctx context.Context, user *User, password string) {
+	if !server.hasher.NeedsRehash(user.HashedPassword) {
+		return
+	}
+
+	err := user.SetPassword(password, server.hasher)
+	if err == nil {
+		err = server.userStore.Update(user)
+	}
+
+	if err != nil {
+		logging.FromContext(ctx).Error("cannot upgrade password hash", "user", user.Username, "error", err)
+	}
+}
+
+// loginLimiterKeys returns the username key followed by the peer address key, if known
+func loginLimiterKeys(ctx context.Context, username string) []string {
+	keys := []string{usernameLimiterKey(username)}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		keys = append(keys, peerLimiterKey(p.Addr.String()))
+	}
+
+	return keys
+}
+
+func usernameLimiterKey(username string) string {
+	return "user:" + username
+}
+
+func peerLimiterKey(address string) string {
+	// the port changes on every connection, only the host identifies the peer
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+
+	return "peer:" + host
+}
+
+// tooManyAttemptsError returns a ResourceExhausted error telling the client when to retry,
+// both in the retry-after trailer (in seconds) and as a RetryInfo detail
+func tooManyAttemptsError(ctx context.Context, wait time.Duration) error {
+	_ = grpc.SetTrailer(ctx, retryAfterMetadata(wait))
+
+	seconds := int64(math.Ceil(wait.Seconds()))
+	return retryLaterError(wait, "too many failed login attempts, retry in %d seconds", seconds)
+}
+
+// retryLaterError returns a ResourceExhausted error with a RetryInfo detail telling the client when to retry
+func retryLaterError(wait time.Duration, format string, args ...interface{}) error {
+	st := status.Newf(codes.ResourceExhausted, format, args...)
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(wait),
+	})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+func canGrant(policy *Policy, claims *UserClaims, scope string) bool {
+	for _, role := range claims.Roles() {
+		if policy.Grants(role, scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// canManageAPIKey checks if the caller can list and revoke the key:
+// its owner, the users of its organization, or a superadmin
+func canManageAPIKey(claims *UserClaims, key *APIKey) bool {
+	if claims.HasRole(SuperadminRole) || claims.Username == key.Owner {
+		return true
+	}
+
+	return key.Organization != "" && claims.Organization == key.Organization
+}
+
+func apiKeyToProto(key *APIKey) *pb.APIKey {
+	apiKey := &pb.APIKey{
+		Id:        key.ID,
+		Name:      key.Name,
+		Scopes:    key.Scopes,
+		Owner:     key.Owner,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+		Revoked:   key.Revoked,
+	}
+
+	if !key.ExpiresAt.IsZero() {
+		apiKey.ExpiresAt = timestamppb.New(key.ExpiresAt)
+	}
+
+	return apiKey
+}
+
+func (server *AuthServer) generateTokens(user *User, mfa bool) (string, string, error) {
+	generate, generateRefreshToken := server.jwtManager.Generate, server.jwtManager.GenerateRefreshToken
+	if mfa {
+		generate, generateRefreshToken = server.jwtManager.GenerateMFA, server.jwtManager.GenerateMFARefreshToken
+	}
+
+	accessToken, err := generate(user)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "cannot generate access token")
+	}
+
+	refreshToken, err := generateRefreshToken(user)
+	if err != nil {
+		return "", "", status.Errorf(codes.Internal, "cannot generate refresh token")
+	}
+
+	return accessToken, refreshToken, nil
+}
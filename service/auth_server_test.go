@@ -0,0 +1,160 @@
+package service_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestAuthServer(t *testing.T, options ...service.AuthServerOption) (*service.AuthServer, *service.JWTManager) {
+	userStore := service.NewInMemoryUserStore()
+	user, err := service.NewUser("user1", "secret", "user")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	return service.NewAuthServer(userStore, jwtManager, options...), jwtManager
+}
+
+func TestServerRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	server, jwtManager := newTestAuthServer(t)
+	ctx := context.Background()
+
+	login, err := server.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "secret"})
+	require.NoError(t, err)
+	require.NotEmpty(t, login.GetAccessToken())
+	require.NotEmpty(t, login.GetRefreshToken())
+
+	// o refresh token não pode ser usado como access token
+	_, err = jwtManager.Verify(login.GetRefreshToken())
+	require.Error(t, err)
+
+	refreshed, err := server.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+	require.NoError(t, err)
+	require.NotEqual(t, login.GetRefreshToken(), refreshed.GetRefreshToken())
+
+	claims, err := jwtManager.Verify(refreshed.GetAccessToken())
+	require.NoError(t, err)
+	require.Equal(t, "user1", claims.Username)
+
+	// o refresh token antigo foi rotacionado
+	_, err = server.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestServerConcurrentRefreshToken(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newTestAuthServer(t)
+	ctx := context.Background()
+
+	login, err := server.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "secret"})
+	require.NoError(t, err)
+
+	// somente uma das renovações simultâneas com o mesmo refresh token recebe novos tokens
+	const refreshes = 20
+	codesCh := make(chan codes.Code, refreshes)
+	var wg sync.WaitGroup
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := server.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+			codesCh <- status.Code(err)
+		}()
+	}
+	wg.Wait()
+	close(codesCh)
+
+	succeeded := 0
+	for code := range codesCh {
+		if code == codes.OK {
+			succeeded++
+			continue
+		}
+		require.Equal(t, codes.Unauthenticated, code)
+	}
+	require.Equal(t, 1, succeeded)
+}
+
+func TestServerLogout(t *testing.T) {
+	t.Parallel()
+
+	server, _ := newTestAuthServer(t)
+	ctx := context.Background()
+
+	login, err := server.Login(ctx, &pb.LoginRequest{Username: "user1", Password: "secret"})
+	require.NoError(t, err)
+
+	_, err = server.Logout(ctx, &pb.LogoutRequest{RefreshToken: login.GetRefreshToken()})
+	require.NoError(t, err)
+
+	_, err = server.RefreshToken(ctx, &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = server.Logout(ctx, &pb.LogoutRequest{RefreshToken: "invalid"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestServerLoginLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := service.NewLoginLimiter(3, 10*time.Millisecond, 20*time.Millisecond, time.Hour)
+	server, _ := newTestAuthServer(t, service.WithLoginLimiter(limiter))
+	ctx := context.Background()
+
+	wrong := &pb.LoginRequest{Username: "user1", Password: "wrong"}
+	correct := &pb.LoginRequest{Username: "user1", Password: "secret"}
+
+	_, err := server.Login(ctx, wrong)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// durante o backoff, nem a senha correta é aceita
+	_, err = server.Login(ctx, correct)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	st, _ := status.FromError(err)
+	require.Len(t, st.Details(), 1)
+	retryInfo, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	require.Positive(t, retryInfo.GetRetryDelay().AsDuration())
+
+	// depois do backoff, um login correto zera as falhas
+	time.Sleep(20 * time.Millisecond)
+	_, err = server.Login(ctx, correct)
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		time.Sleep(25 * time.Millisecond)
+		_, err = server.Login(ctx, wrong)
+		require.Equal(t, codes.NotFound, status.Code(err))
+	}
+
+	// a conta fica bloqueada mesmo depois do backoff máximo
+	time.Sleep(25 * time.Millisecond)
+	_, err = server.Login(ctx, correct)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	res, err := server.UnlockUser(ctx, &pb.UnlockUserRequest{Username: "user1"})
+	require.NoError(t, err)
+	require.True(t, res.GetWasLocked())
+
+	_, err = server.Login(ctx, correct)
+	require.NoError(t, err)
+
+	_, err = server.UnlockUser(ctx, &pb.UnlockUserRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
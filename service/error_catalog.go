@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// ErrorDomain é o domínio dos detalhes ErrorInfo dos erros do catálogo
+const ErrorDomain = "pcbook"
+
+// ErrorReason é o motivo de um erro, enviado no detalhe ErrorInfo para que os clientes
+// decidam o que fazer sem depender da mensagem
+type ErrorReason string
+
+const (
+	// ReasonLaptopNotFound indica que o laptop não existe
+	ReasonLaptopNotFound ErrorReason = "LAPTOP_NOT_FOUND"
+	// ReasonLaptopAlreadyExists indica que já existe um laptop com o mesmo ID
+	ReasonLaptopAlreadyExists ErrorReason = "LAPTOP_ALREADY_EXISTS"
+	// ReasonLaptopConflict indica que o laptop foi alterado ao mesmo tempo por outra requisição
+	ReasonLaptopConflict ErrorReason = "LAPTOP_CONFLICT"
+	// ReasonLaptopInvalid indica que o laptop tem campos inválidos, listados no detalhe BadRequest
+	ReasonLaptopInvalid ErrorReason = "LAPTOP_INVALID"
+	// ReasonLaptopPermissionDenied indica que o laptop pertence a outro usuário
+	ReasonLaptopPermissionDenied ErrorReason = "LAPTOP_PERMISSION_DENIED"
+	// ReasonImageNotFound indica que a imagem não existe
+	ReasonImageNotFound ErrorReason = "IMAGE_NOT_FOUND"
+	// ReasonImageTooLarge indica que a imagem passa do tamanho máximo
+	ReasonImageTooLarge ErrorReason = "IMAGE_TOO_LARGE"
+	// ReasonImportTooLarge indica que a importação passa do número máximo de laptops
+	ReasonImportTooLarge ErrorReason = "IMPORT_TOO_LARGE"
+	// ReasonInvalidRequest indica uma requisição mal formada
+	ReasonInvalidRequest ErrorReason = "INVALID_REQUEST"
+	// ReasonEventsDisabled indica que os eventos dos laptops não estão habilitados no servidor
+	ReasonEventsDisabled ErrorReason = "EVENTS_DISABLED"
+	// ReasonEventsExpired indica que os eventos pedidos não estão mais no histórico, ou ainda não aconteceram
+	ReasonEventsExpired ErrorReason = "EVENTS_EXPIRED"
+	// ReasonWatcherTooSlow indica que o observador não acompanhou os eventos e foi desconectado
+	ReasonWatcherTooSlow ErrorReason = "WATCHER_TOO_SLOW"
+	// ReasonServerShuttingDown indica que o servidor está desligando
+	ReasonServerShuttingDown ErrorReason = "SERVER_SHUTTING_DOWN"
+	// ReasonRequestCanceled indica que o cliente cancelou a requisição
+	ReasonRequestCanceled ErrorReason = "REQUEST_CANCELED"
+	// ReasonDeadlineExceeded indica que o prazo da requisição terminou
+	ReasonDeadlineExceeded ErrorReason = "DEADLINE_EXCEEDED"
+	// ReasonStreamBroken indica um erro ao enviar ou receber as mensagens de um stream
+	ReasonStreamBroken ErrorReason = "STREAM_BROKEN"
+	// ReasonStoreFailure indica um erro inesperado de uma loja
+	ReasonStoreFailure ErrorReason = "STORE_FAILURE"
+	// ReasonInternal indica um erro inesperado do servidor
+	ReasonInternal ErrorReason = "INTERNAL"
+)
+
+const (
+	laptopResourceType = "pcbook.Laptop"
+	imageResourceType  = "pcbook.Image"
+)
+
+// errorCatalogEntry é o código gRPC de um motivo, o tipo do recurso no detalhe ResourceInfo,
+// e o tempo de espera no detalhe RetryInfo se a requisição pode ser repetida
+type errorCatalogEntry struct {
+	code         codes.Code
+	resourceType string
+	retryDelay   time.Duration
+}
+
+var errorCatalog = map[ErrorReason]errorCatalogEntry{
+	ReasonLaptopNotFound:         {code: codes.NotFound, resourceType: laptopResourceType},
+	ReasonLaptopAlreadyExists:    {code: codes.AlreadyExists, resourceType: laptopResourceType},
+	ReasonLaptopConflict:         {code: codes.Aborted, resourceType: laptopResourceType, retryDelay: time.Second},
+	ReasonLaptopInvalid:          {code: codes.InvalidArgument, resourceType: laptopResourceType},
+	ReasonLaptopPermissionDenied: {code: codes.PermissionDenied, resourceType: laptopResourceType},
+	ReasonImageNotFound:          {code: codes.NotFound, resourceType: imageResourceType},
+	ReasonImageTooLarge:          {code: codes.InvalidArgument},
+	ReasonImportTooLarge:         {code: codes.ResourceExhausted},
+	ReasonInvalidRequest:         {code: codes.InvalidArgument},
+	ReasonEventsDisabled:         {code: codes.Unimplemented},
+	ReasonEventsExpired:          {code: codes.OutOfRange},
+	ReasonWatcherTooSlow:         {code: codes.ResourceExhausted, retryDelay: time.Second},
+	ReasonServerShuttingDown:     {code: codes.Unavailable, retryDelay: 5 * time.Second},
+	ReasonRequestCanceled:        {code: codes.Canceled},
+	ReasonDeadlineExceeded:       {code: codes.DeadlineExceeded},
+	ReasonStreamBroken:           {code: codes.Unknown},
+	ReasonStoreFailure:           {code: codes.Internal},
+	ReasonInternal:               {code: codes.Internal},
+}
+
+// Code retorna o código gRPC dos erros com o motivo
+func (reason ErrorReason) Code() codes.Code {
+	entry, ok := errorCatalog[reason]
+	if !ok {
+		return codes.Unknown
+	}
+	return entry.code
+}
+
+// newErrorStatus retorna o status do motivo, com os detalhes ErrorInfo,
+// ResourceInfo se o motivo é de um recurso, e RetryInfo se a requisição pode ser repetida
+func newErrorStatus(reason ErrorReason, resourceName string, format string, args ...interface{}) *status.Status {
+	entry := errorCatalog[reason]
+	message := fmt.Sprintf(format, args...)
+	st := status.New(reason.Code(), message)
+
+	details := []proto.Message{&errdetails.ErrorInfo{
+		Reason: string(reason),
+		Domain: ErrorDomain,
+	}}
+
+	if entry.resourceType != "" && resourceName != "" {
+		details = append(details, &errdetails.ResourceInfo{
+			ResourceType: entry.resourceType,
+			ResourceName: resourceName,
+			Description:  message,
+		})
+	}
+
+	if entry.retryDelay > 0 {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(entry.retryDelay),
+		})
+	}
+
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return detailed
+}
+
+// newError retorna o erro do motivo, com os detalhes do catálogo
+func newError(reason ErrorReason, resourceName string, format string, args ...interface{}) error {
+	return newErrorStatus(reason, resourceName, format, args...).Err()
+}
+
+// laptopStoreError converte um erro da loja de laptops no erro do catálogo.
+// Os erros que já são do gRPC são retornados sem alteração.
+func laptopStoreError(err error, laptopID string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return newError(ReasonLaptopNotFound, laptopID, "laptop id %s não existe", laptopID)
+	case errors.Is(err, ErrAlreadyExists):
+		return newError(ReasonLaptopAlreadyExists, laptopID, "laptop id %s já existe", laptopID)
+	case errors.Is(err, ErrConflict):
+		return newError(ReasonLaptopConflict, laptopID, "o laptop %s foi alterado por outra requisição, tente novamente", laptopID)
+	case errors.Is(err, context.Canceled):
+		return newError(ReasonRequestCanceled, "", "requisição cancelada")
+	case errors.Is(err, context.DeadlineExceeded):
+		return newError(ReasonDeadlineExceeded, "", "o tempo foi esgotado")
+	default:
+		return newError(ReasonStoreFailure, "", "erro na loja de laptops: %v", err)
+	}
+}
+
+// ErrorReasonOf retorna o motivo do detalhe ErrorInfo de um erro do catálogo,
+// ou false se o erro não tem o detalhe
+func ErrorReasonOf(err error) (ErrorReason, bool) {
+	st, ok := status.FromError(err)
+	if !ok {
+		return "", false
+	}
+
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if ok && info.GetDomain() == ErrorDomain {
+			return ErrorReason(info.GetReason()), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,169 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// failingLaptopStore é uma loja de laptops em que as atualizações falham com o erro
+type failingLaptopStore struct {
+	service.LaptopStore
+	err error
+}
+
+func (store *failingLaptopStore) Update(ctx context.Context, laptop *pb.Laptop, updatedAt *timestamppb.Timestamp) error {
+	return store.err
+}
+
+// requireCatalogError verifica o código e o motivo do erro, e retorna os detalhes dele
+func requireCatalogError(t *testing.T, err error, reason service.ErrorReason) (*errdetails.ResourceInfo, *errdetails.RetryInfo) {
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, reason.Code(), st.Code(), st.Message())
+
+	errReason, ok := service.ErrorReasonOf(err)
+	require.True(t, ok)
+	require.Equal(t, reason, errReason)
+
+	var resourceInfo *errdetails.ResourceInfo
+	var retryInfo *errdetails.RetryInfo
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ResourceInfo:
+			resourceInfo = detail
+		case *errdetails.RetryInfo:
+			retryInfo = detail
+		}
+	}
+
+	return resourceInfo, retryInfo
+}
+
+func TestServerErrorCatalog(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	server := service.NewLaptopServer(laptopStore, nil, nil)
+
+	laptop := sample.NewLaptop()
+	_, err := server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	// o laptop repetido é identificado no ResourceInfo
+	_, err = server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	resourceInfo, retryInfo := requireCatalogError(t, err, service.ReasonLaptopAlreadyExists)
+	require.Equal(t, "pcbook.Laptop", resourceInfo.GetResourceType())
+	require.Equal(t, laptop.GetId(), resourceInfo.GetResourceName())
+	require.Nil(t, retryInfo)
+
+	// FindLaptop retorna NotFound para um laptop que não existe
+	missingID := sample.NewLaptop().GetId()
+	_, err = server.FindLaptop(context.Background(), &pb.FindLaptopRequest{Id: missingID})
+	resourceInfo, _ = requireCatalogError(t, err, service.ReasonLaptopNotFound)
+	require.Equal(t, missingID, resourceInfo.GetResourceName())
+
+	_, err = server.DeleteLaptop(context.Background(), &pb.DeleteLaptopRequest{Id: missingID})
+	requireCatalogError(t, err, service.ReasonLaptopNotFound)
+
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: &pb.Laptop{}})
+	requireCatalogError(t, err, service.ReasonLaptopInvalid)
+
+	err = service.NewLaptopServer(laptopStore, nil, nil).WatchLaptops(&pb.WatchLaptopsRequest{}, nil)
+	requireCatalogError(t, err, service.ReasonEventsDisabled)
+}
+
+func TestServerStoreErrors(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		err    error
+		reason service.ErrorReason
+		// resource indica se o laptop é identificado no ResourceInfo
+		resource bool
+		retry    bool
+	}{
+		{
+			name:     "conflito pode ser repetido",
+			err:      service.ErrConflict,
+			reason:   service.ReasonLaptopConflict,
+			resource: true,
+			retry:    true,
+		},
+		{
+			name:     "laptop removido durante a atualização",
+			err:      service.ErrNotFound,
+			reason:   service.ReasonLaptopNotFound,
+			resource: true,
+		},
+		{
+			name:   "erro inesperado",
+			err:    errors.New("disco cheio"),
+			reason: service.ReasonStoreFailure,
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := sample.NewLaptop()
+			laptopStore := service.NewInMemoryLaptopStore()
+			require.NoError(t, laptopStore.Save(context.Background(), laptop))
+
+			ctx := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "root", Role: service.SuperadminRole})
+			server := service.NewLaptopServer(&failingLaptopStore{LaptopStore: laptopStore, err: tc.err}, nil, nil)
+			_, err := server.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: laptop})
+
+			resourceInfo, retryInfo := requireCatalogError(t, err, tc.reason)
+			if tc.resource {
+				require.Equal(t, laptop.GetId(), resourceInfo.GetResourceName())
+			} else {
+				require.Nil(t, resourceInfo)
+			}
+
+			if tc.retry {
+				require.NotNil(t, retryInfo)
+				require.Positive(t, retryInfo.GetRetryDelay().AsDuration())
+			} else {
+				require.Nil(t, retryInfo)
+			}
+		})
+	}
+}
+
+func TestClientImportLaptopsErrorReasons(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	existing := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(context.Background(), existing))
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	laptops[0].Id = existing.GetId()
+	laptops[2].Cpu = nil
+
+	// cada laptop que falhou tem o motivo do catálogo
+	res := importLaptops(t, laptopClient, pb.ImportOptions_BEST_EFFORT, laptops)
+	requireImportErrors(t, res, map[uint32]codes.Code{
+		0: codes.AlreadyExists,
+		2: codes.InvalidArgument,
+	})
+	require.Equal(t, string(service.ReasonLaptopInvalid), res.GetErrors()[0].GetReason())
+	require.Equal(t, string(service.ReasonLaptopAlreadyExists), res.GetErrors()[1].GetReason())
+}
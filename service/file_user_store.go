@@ -0,0 +1,142 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileUserStore stores users in memory and persists them to a JSON file on every change
+type FileUserStore struct {
+	mutex    sync.RWMutex
+	filename string
+	users    map[string]*User
+}
+
+// NewFileUserStore returns a FileUserStore loaded from the file, which is created on the first save
+func NewFileUserStore(filename string) (*FileUserStore, error) {
+	store := &FileUserStore{
+		filename: filename,
+		users:    make(map[string]*User),
+	}
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read users file: %w", err)
+	}
+
+	var users []*User
+	err = json.Unmarshal(data, &users)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse users file: %w", err)
+	}
+
+	for _, user := range users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("users file contains a user without username")
+		}
+
+		store.users[user.Username] = user
+	}
+
+	return store, nil
+}
+
+func (store *FileUserStore) Save(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[user.Username] != nil {
+		return ErrAlreadyExists
+	}
+
+	return store.write(user)
+}
+
+func (store *FileUserStore) Find(username string) (*User, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	user := store.users[username]
+	if user == nil {
+		return nil, nil
+	}
+
+	return user.Clone(), nil
+}
+
+func (store *FileUserStore) Update(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.users[user.Username] == nil {
+		return ErrNotFound
+	}
+
+	return store.write(user)
+}
+
+// write persists the users with the given user added or replaced.
+// The memory is only changed if the file has been written.
+func (store *FileUserStore) write(user *User) error {
+	users := make([]*User, 0, len(store.users)+1)
+	for username, other := range store.users {
+		if username != user.Username {
+			users = append(users, other)
+		}
+	}
+	users = append(users, user)
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot marshal users: %w", err)
+	}
+
+	err = writeFileAtomic(store.filename, data, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot write users file: %w", err)
+	}
+
+	store.users[user.Username] = user.Clone()
+	return nil
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it,
+// so that readers never see a partially written file
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(file.Name(), perm)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filename)
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ForwardedForHeader is the metadata carrying the address of the client of a proxy, like the REST gateway
+const ForwardedForHeader = "x-forwarded-for"
+
+// ForwardedPeerInterceptor replaces the peer of the calls forwarded by a trusted proxy, like the REST gateway,
+// with the address of the client of the proxy, so that the rate limits, the login backoff, the logs
+// and the audit log see each client instead of the proxy. A proxy is trusted if it presents a verified
+// client certificate with one of the trusted common names: the header is ignored for the other callers,
+// since anyone could set it. It must be chained first, before the interceptors using the peer.
+type ForwardedPeerInterceptor struct {
+	proxies map[string]bool
+}
+
+// NewForwardedPeerInterceptor returns a new interceptor trusting the proxies with the given certificate common names
+func NewForwardedPeerInterceptor(proxies ...string) *ForwardedPeerInterceptor {
+	interceptor := &ForwardedPeerInterceptor{
+		proxies: make(map[string]bool),
+	}
+
+	for _, proxy := range proxies {
+		interceptor.proxies[proxy] = true
+	}
+
+	return interceptor
+}
+
+func (interceptor *ForwardedPeerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return handler(interceptor.forwardedContext(ctx), req)
+	}
+}
+
+func (interceptor *ForwardedPeerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx := interceptor.forwardedContext(stream.Context())
+		if ctx == stream.Context() {
+			return handler(srv, stream)
+		}
+
+		return handler(srv, &serverStreamWithContext{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// forwardedContext returns ctx with the forwarded client as peer, or ctx itself if the call is not forwarded by a trusted proxy
+func (interceptor *ForwardedPeerInterceptor) forwardedContext(ctx context.Context) context.Context {
+	if len(interceptor.proxies) == 0 {
+		return ctx
+	}
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(ForwardedForHeader)) != 1 {
+		return ctx
+	}
+
+	ip := net.ParseIP(md.Get(ForwardedForHeader)[0])
+	if ip == nil {
+		return ctx
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+
+	if !interceptor.proxies[tlsInfo.State.VerifiedChains[0][0].Subject.CommonName] {
+		return ctx
+	}
+
+	// the certificate is the proxy's and not the client's: it must not authenticate the client
+	return peer.NewContext(ctx, &peer.Peer{Addr: forwardedAddr{ip: ip}})
+}
+
+// forwardedAddr is the address of the client of a proxy, whose port is unknown
+type forwardedAddr struct {
+	ip net.IP
+}
+
+func (addr forwardedAddr) Network() string {
+	return "tcp"
+}
+
+func (addr forwardedAddr) String() string {
+	return addr.ip.String()
+}
@@ -0,0 +1,104 @@
+package service_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// proxyPeer retorna o peer de um chamador com um certificado de cliente verificado com o CN
+func proxyPeer(commonName string) *peer.Peer {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+
+	return &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 51000},
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}},
+		},
+	}
+}
+
+func TestForwardedPeerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	interceptor := service.NewForwardedPeerInterceptor("gateway")
+
+	testCases := []struct {
+		name      string
+		peer      *peer.Peer
+		forwarded []string
+		expected  string
+	}{
+		{
+			name:      "proxy confiável",
+			peer:      proxyPeer("gateway"),
+			forwarded: []string{"203.0.113.7"},
+			expected:  "203.0.113.7",
+		},
+		{
+			name:     "proxy confiável sem o endereço do cliente",
+			peer:     proxyPeer("gateway"),
+			expected: "10.0.0.5:51000",
+		},
+		{
+			name:      "certificado de outro cliente",
+			peer:      proxyPeer("import-job"),
+			forwarded: []string{"203.0.113.7"},
+			expected:  "10.0.0.5:51000",
+		},
+		{
+			name:      "sem certificado",
+			peer:      &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 51000}},
+			forwarded: []string{"203.0.113.7"},
+			expected:  "10.0.0.5:51000",
+		},
+		{
+			name:      "endereço inválido",
+			peer:      proxyPeer("gateway"),
+			forwarded: []string{"203.0.113.7, 10.0.0.1"},
+			expected:  "10.0.0.5:51000",
+		},
+		{
+			name:      "vários endereços",
+			peer:      proxyPeer("gateway"),
+			forwarded: []string{"203.0.113.7", "10.0.0.1"},
+			expected:  "10.0.0.5:51000",
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			md := metadata.MD{}
+			for _, address := range tc.forwarded {
+				md.Append(service.ForwardedForHeader, address)
+			}
+			ctx := metadata.NewIncomingContext(peer.NewContext(context.Background(), tc.peer), md)
+
+			var handled *peer.Peer
+			_, err := interceptor.Unary()(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				handled, _ = peer.FromContext(ctx)
+				return nil, nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, handled.Addr.String())
+
+			// o certificado do proxy não autentica os clientes dele
+			if tc.expected != tc.peer.Addr.String() {
+				require.Nil(t, handled.AuthInfo)
+			}
+		})
+	}
+}
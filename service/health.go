@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// HealthCheck checks that a dependency of the server works
+type HealthCheck func(ctx context.Context) error
+
+// healthCheckTimeout bounds each check, so that a stuck dependency is reported as failing
+const healthCheckTimeout = 5 * time.Second
+
+type dependency struct {
+	name     string
+	check    HealthCheck
+	services []string
+	healthy  bool
+	checked  bool
+}
+
+// HealthChecker checks the dependencies of the server and reports their status on the gRPC health service.
+// Each dependency is reported under its own name, each service is serving if all its dependencies are healthy,
+// and the server as a whole, under the empty name, is serving if all dependencies are healthy.
+type HealthChecker struct {
+	mutex        sync.Mutex
+	server       *health.Server
+	dependencies []*dependency
+	services     map[string]bool
+}
+
+// NewHealthChecker returns a new health checker reporting to the health server
+func NewHealthChecker(server *health.Server) *HealthChecker {
+	return &HealthChecker{
+		server:   server,
+		services: make(map[string]bool),
+	}
+}
+
+// AddDependency adds a dependency needed by the given services, like "pcbook.LaptopService"
+func (checker *HealthChecker) AddDependency(name string, check HealthCheck, services ...string) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	checker.dependencies = append(checker.dependencies, &dependency{
+		name:     name,
+		check:    check,
+		services: services,
+	})
+
+	for _, service := range services {
+		checker.services[service] = true
+	}
+}
+
+// Check runs the checks of all dependencies and updates the statuses of the health service
+func (checker *HealthChecker) Check(ctx context.Context) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+
+	unhealthyServices := make(map[string]bool)
+	healthy := true
+
+	for _, dependency := range checker.dependencies {
+		checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := dependency.check(checkCtx)
+		cancel()
+
+		if err != nil {
+			healthy = false
+			for _, service := range dependency.services {
+				unhealthyServices[service] = true
+			}
+		}
+
+		if !dependency.checked || dependency.healthy != (err == nil) {
+			if err != nil {
+				slog.Warn("dependency is unhealthy", "dependency", dependency.name, "error", err)
+			} else {
+				slog.Info("dependency is healthy", "dependency", dependency.name)
+			}
+		}
+
+		dependency.checked = true
+		dependency.healthy = err == nil
+		checker.server.SetServingStatus(dependency.name, servingStatus(err == nil))
+	}
+
+	for service := range checker.services {
+		checker.server.SetServingStatus(service, servingStatus(!unhealthyServices[service]))
+	}
+
+	checker.server.SetServingStatus("", servingStatus(healthy))
+}
+
+// Run checks the dependencies at each interval, until ctx is done
+func (checker *HealthChecker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checker.Check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Shutdown reports all services as not serving, for good: the next checks do not change their status.
+// It is called before draining the server, so that load balancers stop sending new calls.
+func (checker *HealthChecker) Shutdown() {
+	checker.server.Shutdown()
+}
+
+func servingStatus(healthy bool) healthpb.HealthCheckResponse_ServingStatus {
+	if healthy {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
+
+// WritableFolderCheck checks that files can be created in the folder
+func WritableFolderCheck(folder string) HealthCheck {
+	return func(ctx context.Context) error {
+		file, err := os.CreateTemp(folder, ".health-*")
+		if err != nil {
+			return fmt.Errorf("folder %s is not writable: %w", folder, err)
+		}
+
+		file.Close()
+		return os.Remove(file.Name())
+	}
+}
+
+// LaptopStoreCheck checks that the laptop store can be read
+func LaptopStoreCheck(store LaptopStore) HealthCheck {
+	return func(ctx context.Context) error {
+		_, err := store.Find(ctx, "")
+		return err
+	}
+}
+
+// UserStoreCheck checks that the user store can be read,
+// and for a FileUserStore that the users file can be written
+func UserStoreCheck(store UserStore) HealthCheck {
+	return func(ctx context.Context) error {
+		_, err := store.Find("")
+		if err != nil {
+			return err
+		}
+
+		fileStore, ok := store.(*FileUserStore)
+		if ok {
+			return WritableFolderCheck(filepath.Dir(fileStore.filename))(ctx)
+		}
+
+		return nil
+	}
+}
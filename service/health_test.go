@@ -0,0 +1,84 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthChecker(t *testing.T) {
+	t.Parallel()
+
+	healthServer := health.NewServer()
+	checker := service.NewHealthChecker(healthServer)
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	require.NoError(t, laptopStore.Save(context.Background(), sample.NewLaptop()))
+
+	var imageFolderBroken atomic.Bool
+	imageFolderCheck := service.WritableFolderCheck(t.TempDir())
+
+	checker.AddDependency("laptop-store", service.LaptopStoreCheck(laptopStore), "pcbook.LaptopService")
+	checker.AddDependency("image-folder", func(ctx context.Context) error {
+		if imageFolderBroken.Load() {
+			return errors.New("disk full")
+		}
+		return imageFolderCheck(ctx)
+	}, "pcbook.LaptopService")
+	checker.AddDependency("user-store", service.UserStoreCheck(service.NewInMemoryUserStore()), "pcbook.AuthService")
+
+	requireStatus := func(name string, expected healthpb.HealthCheckResponse_ServingStatus) {
+		res, err := healthServer.Check(context.Background(), &healthpb.HealthCheckRequest{Service: name})
+		require.NoError(t, err)
+		require.Equal(t, expected, res.GetStatus(), name)
+	}
+
+	checker.Check(context.Background())
+	requireStatus("", healthpb.HealthCheckResponse_SERVING)
+	requireStatus("image-folder", healthpb.HealthCheckResponse_SERVING)
+	requireStatus("pcbook.LaptopService", healthpb.HealthCheckResponse_SERVING)
+
+	// uma dependência com falha afeta somente os serviços que dependem dela
+	imageFolderBroken.Store(true)
+	checker.Check(context.Background())
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus("image-folder", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus("laptop-store", healthpb.HealthCheckResponse_SERVING)
+	requireStatus("pcbook.LaptopService", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus("pcbook.AuthService", healthpb.HealthCheckResponse_SERVING)
+
+	imageFolderBroken.Store(false)
+	checker.Check(context.Background())
+	requireStatus("", healthpb.HealthCheckResponse_SERVING)
+
+	// depois do desligamento, as verificações não voltam a servir
+	checker.Shutdown()
+	checker.Check(context.Background())
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus("pcbook.AuthService", healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+func TestHealthChecks(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	require.NoError(t, service.WritableFolderCheck(folder)(context.Background()))
+	require.Error(t, service.WritableFolderCheck(filepath.Join(folder, "missing"))(context.Background()))
+
+	// o arquivo de usuários precisa estar em uma pasta com escrita
+	fileStore, err := service.NewFileUserStore(filepath.Join(folder, "users.json"))
+	require.NoError(t, err)
+	require.NoError(t, service.UserStoreCheck(fileStore)(context.Background()))
+
+	missingStore, err := service.NewFileUserStore(filepath.Join(folder, "missing", "users.json"))
+	require.NoError(t, err)
+	require.Error(t, service.UserStoreCheck(missingStore)(context.Background()))
+}
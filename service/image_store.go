@@ -2,8 +2,10 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"github.com/google/uuid"
@@ -11,7 +13,11 @@ import (
 
 // ImageStore é uma interface para armazenar imagens de laptop
 type ImageStore interface {
-	Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error)
+	Save(ctx context.Context, laptopID string, imageType string, imageData bytes.Buffer) (string, error)
+	// Load retorna as informações e os dados de uma imagem, ou nil se a imagem não existe
+	Load(ctx context.Context, imageID string) (*ImageInfo, []byte, error)
+	// TotalSize retorna o total de bytes das imagens salvas
+	TotalSize() int64
 }
 
 // DiskImageStore armazena a imagem no disco e suas informações na memória
@@ -26,6 +32,7 @@ type ImageInfo struct {
 	LaptopID string
 	Type     string
 	Path     string
+	Size     int64
 }
 
 func NewDiskIMageStore(imageFolder string) *DiskImageStore {
@@ -36,13 +43,19 @@ func NewDiskIMageStore(imageFolder string) *DiskImageStore {
 }
 
 // Save adiciona uma nova imagem de um laptop
-func (store *DiskImageStore) Save(laptopID string, imageType string, imageData bytes.Buffer) (string, error) {
+func (store *DiskImageStore) Save(ctx context.Context, laptopID string, imageType string, imageData bytes.Buffer) (string, error) {
+	// o tipo faz parte do caminho do arquivo, ele não pode sair da pasta das imagens
+	if strings.ContainsAny(imageType, `/\`) || strings.Contains(imageType, "..") {
+		return "", fmt.Errorf("tipo de imagem inválido: %q", imageType)
+	}
+
 	imageID, err := uuid.NewRandom()
 	if err != nil {
 		return "", fmt.Errorf("error ao gerar o ID da image: %v", err)
 	}
 
 	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageID, imageType)
+	imageSize := int64(imageData.Len())
 
 	file, err := os.Create(imagePath)
 	if err != nil {
@@ -60,7 +73,40 @@ func (store *DiskImageStore) Save(laptopID string, imageType string, imageData b
 		LaptopID: laptopID,
 		Type:     imageType,
 		Path:     imagePath,
+		Size:     imageSize,
 	}
 
 	return imageID.String(), nil
 }
+
+// Load lê do disco os dados de uma imagem salva por esta loja
+func (store *DiskImageStore) Load(ctx context.Context, imageID string) (*ImageInfo, []byte, error) {
+	store.mutex.RLock()
+	image := store.images[imageID]
+	store.mutex.RUnlock()
+
+	if image == nil {
+		return nil, nil, nil
+	}
+
+	data, err := os.ReadFile(image.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("erro ao ler o arquivo da imagem: %v", err)
+	}
+
+	other := *image
+	return &other, data, nil
+}
+
+// TotalSize retorna o total de bytes das imagens salvas no disco por esta loja
+func (store *DiskImageStore) TotalSize() int64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var size int64
+	for _, image := range store.images {
+		size += image.Size
+	}
+
+	return size
+}
@@ -0,0 +1,344 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/pcbook-go/pb"
+)
+
+// SigningKey is a key used to sign and/or verify JSON web tokens
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	// signKey is nil for verification-only keys
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey returns a symmetric HS256 key, which can both sign and verify tokens
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{
+		ID:        id,
+		Method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}
+}
+
+// NewPrivateKey returns a signing key for a RSA, ECDSA or Ed25519 private key.
+// The key ID is the RFC 7638 thumbprint of its public key.
+func NewPrivateKey(privateKey crypto.Signer) (*SigningKey, error) {
+	key, err := NewPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	key.signKey = privateKey
+	return key, nil
+}
+
+// NewPublicKey returns a verification-only key for a RSA, ECDSA or Ed25519 public key.
+// The key ID is the RFC 7638 thumbprint of the public key.
+func NewPublicKey(publicKey crypto.PublicKey) (*SigningKey, error) {
+	var method jwt.SigningMethod
+
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			method = jwt.SigningMethodES256
+		case elliptic.P384():
+			method = jwt.SigningMethodES384
+		case elliptic.P521():
+			method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported elliptic curve: %s", pub.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+		method = SigningMethodEd25519
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+
+	key := &SigningKey{
+		Method:    method,
+		verifyKey: publicKey,
+	}
+
+	jwk := key.JWK()
+	thumbprint, err := jwkThumbprint(jwk)
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = thumbprint
+	return key, nil
+}
+
+// LoadPrivateKeyFile loads a PEM encoded private key (PKCS#1, PKCS#8 or SEC 1)
+func LoadPrivateKeyFile(filename string) (*SigningKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read private key file: %w", err)
+	}
+
+	return ParsePrivateKeyPEM(data)
+}
+
+// LoadPublicKeyFile loads a PEM encoded public key (PKIX or PKCS#1)
+func LoadPublicKeyFile(filename string) (*SigningKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read public key file: %w", err)
+	}
+
+	return ParsePublicKeyPEM(data)
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded private key (PKCS#1, PKCS#8 or SEC 1)
+func ParsePrivateKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	var privateKey interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse private key: %w", err)
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type: %T", privateKey)
+	}
+
+	return NewPrivateKey(signer)
+}
+
+// ParsePublicKeyPEM parses a PEM encoded public key (PKIX or PKCS#1)
+func ParsePublicKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	var publicKey interface{}
+	var err error
+
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse public key: %w", err)
+	}
+
+	return NewPublicKey(publicKey)
+}
+
+// CanSign checks if the key holds a private or secret key
+func (key *SigningKey) CanSign() bool {
+	return key.signKey != nil
+}
+
+// IsSymmetric checks if the key is a shared secret
+func (key *SigningKey) IsSymmetric() bool {
+	_, ok := key.verifyKey.([]byte)
+	return ok
+}
+
+// JWK returns the public part of the key as a JSON web key, or nil for symmetric keys
+func (key *SigningKey) JWK() *pb.JsonWebKey {
+	jwk := &pb.JsonWebKey{
+		Kid: key.ID,
+		Use: "sig",
+		Alg: key.Method.Alg(),
+	}
+
+	switch pub := key.verifyKey.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeBase64URL(pub.N.Bytes())
+		jwk.E = encodeBase64URL(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk.Kty = "EC"
+		jwk.Crv = pub.Curve.Params().Name
+		jwk.X = encodeBase64URL(pub.X.FillBytes(make([]byte, size)))
+		jwk.Y = encodeBase64URL(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeBase64URL(pub)
+	default:
+		return nil
+	}
+
+	return jwk
+}
+
+// jwkThumbprint computes the RFC 7638 thumbprint of a JSON web key
+func jwkThumbprint(jwk *pb.JsonWebKey) (string, error) {
+	// only the required members, which encoding/json sorts lexicographically
+	members := map[string]string{"kty": jwk.Kty}
+
+	switch jwk.Kty {
+	case "RSA":
+		members["n"] = jwk.N
+		members["e"] = jwk.E
+	case "EC":
+		members["crv"] = jwk.Crv
+		members["x"] = jwk.X
+		members["y"] = jwk.Y
+	case "OKP":
+		members["crv"] = jwk.Crv
+		members["x"] = jwk.X
+	default:
+		return "", fmt.Errorf("unsupported key type: %s", jwk.Kty)
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("cannot marshal key: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return encodeBase64URL(sum[:]), nil
+}
+
+func encodeBase64URL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// KeySet holds the key used to sign new tokens and all keys accepted to verify them
+type KeySet struct {
+	mutex      sync.RWMutex
+	signingKey *SigningKey
+	keys       map[string]*SigningKey
+}
+
+// NewKeySet returns a new key set signing with signingKey and also accepting verificationKeys
+func NewKeySet(signingKey *SigningKey, verificationKeys ...*SigningKey) (*KeySet, error) {
+	set := &KeySet{
+		keys: make(map[string]*SigningKey),
+	}
+
+	for _, key := range verificationKeys {
+		set.Add(key)
+	}
+
+	err := set.Rotate(signingKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
+
+// Add adds a key accepted to verify tokens
+func (set *KeySet) Add(key *SigningKey) {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	set.keys[key.ID] = key
+}
+
+// Rotate makes key the signing key for new tokens.
+// The previous signing key is still accepted to verify tokens until it is removed.
+func (set *KeySet) Rotate(key *SigningKey) error {
+	if !key.CanSign() {
+		return fmt.Errorf("key %s cannot be used to sign tokens", key.ID)
+	}
+
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	set.keys[key.ID] = key
+	set.signingKey = key
+	return nil
+}
+
+// Remove stops accepting the key with the given ID, unless it is the current signing key
+func (set *KeySet) Remove(keyID string) error {
+	set.mutex.Lock()
+	defer set.mutex.Unlock()
+
+	if set.signingKey.ID == keyID {
+		return fmt.Errorf("cannot remove the current signing key")
+	}
+
+	delete(set.keys, keyID)
+	return nil
+}
+
+// SigningKey returns the key used to sign new tokens
+func (set *KeySet) SigningKey() *SigningKey {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	return set.signingKey
+}
+
+// Find returns the verification key with the given ID, or nil if it is not found
+func (set *KeySet) Find(keyID string) *SigningKey {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	return set.keys[keyID]
+}
+
+// PublicKeys returns the JSON web keys of all asymmetric keys in the set
+func (set *KeySet) PublicKeys() []*pb.JsonWebKey {
+	set.mutex.RLock()
+	defer set.mutex.RUnlock()
+
+	jwks := make([]*pb.JsonWebKey, 0, len(set.keys))
+	for _, key := range set.keys {
+		jwk := key.JWK()
+		if jwk != nil {
+			jwks = append(jwks, jwk)
+		}
+	}
+
+	sort.Slice(jwks, func(i, j int) bool {
+		return jwks[i].Kid < jwks[j].Kid
+	})
+
+	return jwks
+}
@@ -5,52 +5,166 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/pcbook-go/pb"
+)
+
+const (
+	accessTokenType    = "access"
+	refreshTokenType   = "refresh"
+	challengeTokenType = "mfa_challenge"
+
+	// challengeTokenDuration is the time a user has to send the second factor after the password
+	challengeTokenDuration = 5 * time.Minute
 )
 
 // JWTManager is a JSON web token manager
 type JWTManager struct {
-	secretKey     string
-	tokenDuration time.Duration
+	keySet               *KeySet
+	tokenDuration        time.Duration
+	refreshTokenDuration time.Duration
+	revocationStore      RevocationStore
 }
 
 // NewJWTManager returns a new JWT manager
-func NewJWTManager(secretKey string, tokenDuration time.Duration) *JWTManager {
-	return &JWTManager{secretKey, tokenDuration}
+func NewJWTManager(
+	keySet *KeySet,
+	tokenDuration time.Duration,
+	refreshTokenDuration time.Duration,
+	revocationStore RevocationStore,
+) *JWTManager {
+	return &JWTManager{keySet, tokenDuration, refreshTokenDuration, revocationStore}
 }
 
 // UserClaims is a custom JWT claims that contains some user's information
 type UserClaims struct {
 	jwt.StandardClaims
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	Username     string `json:"username"`
+	Role         string `json:"role"`
+	Organization string `json:"organization,omitempty"`
+	TokenType    string `json:"token_type"`
+	// MFA is true if the user has also been authenticated with a second factor
+	MFA bool `json:"mfa,omitempty"`
+	// TokenGeneration is the token generation of the user when the token was issued
+	TokenGeneration int `json:"gen,omitempty"`
+
+	// Scopes and APIKeyID are only set when the caller is authenticated with an API key
+	Scopes   []string `json:"-"`
+	APIKeyID string   `json:"-"`
 }
 
-// Generate generates and signs a new token for a user
+// Roles returns the roles the caller acts with: the API key scopes or the user role
+func (claims *UserClaims) Roles() []string {
+	if claims.APIKeyID != "" {
+		return claims.Scopes
+	}
+
+	return []string{claims.Role}
+}
+
+// HasRole checks if the caller acts with the given role
+func (claims *UserClaims) HasRole(role string) bool {
+	for _, r := range claims.Roles() {
+		if r == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsCurrent checks that the token has been issued to the user after their last password change
+func (claims *UserClaims) IsCurrent(user *User) bool {
+	return claims.Username == user.Username && claims.TokenGeneration == user.TokenGeneration
+}
+
+// Generate generates and signs a new access token for a user
 func (manager *JWTManager) Generate(user *User) (string, error) {
+	return manager.generate(user, accessTokenType, manager.tokenDuration, false)
+}
+
+// GenerateRefreshToken generates and signs a new long-lived refresh token for a user
+func (manager *JWTManager) GenerateRefreshToken(user *User) (string, error) {
+	return manager.generate(user, refreshTokenType, manager.refreshTokenDuration, false)
+}
+
+// GenerateMFA generates and signs a new access token for a user authenticated with a second factor
+func (manager *JWTManager) GenerateMFA(user *User) (string, error) {
+	return manager.generate(user, accessTokenType, manager.tokenDuration, true)
+}
+
+// GenerateMFARefreshToken generates and signs a new refresh token for a user authenticated with a second factor
+func (manager *JWTManager) GenerateMFARefreshToken(user *User) (string, error) {
+	return manager.generate(user, refreshTokenType, manager.refreshTokenDuration, true)
+}
+
+// GenerateChallengeToken generates and signs a short-lived token proving that a user
+// sent the correct password, to be exchanged for an access token with the second factor
+func (manager *JWTManager) GenerateChallengeToken(user *User) (string, error) {
+	return manager.generate(user, challengeTokenType, challengeTokenDuration, false)
+}
+
+func (manager *JWTManager) generate(user *User, tokenType string, duration time.Duration, mfa bool) (string, error) {
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return "", fmt.Errorf("cannot generate token id: %w", err)
+	}
+
+	now := time.Now()
 	claims := UserClaims{
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(manager.tokenDuration).Unix(),
+			Id:        tokenID.String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(duration).Unix(),
 		},
-		Username: user.Username,
-		Role:     user.Role,
+		Username:        user.Username,
+		Role:            user.Role,
+		Organization:    user.Organization,
+		TokenType:       tokenType,
+		MFA:             mfa,
+		TokenGeneration: user.TokenGeneration,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(manager.secretKey))
+	key := manager.keySet.SigningKey()
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != "" {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signKey)
 }
 
 // Verify verifies the access token string and return a user claim if the token is valid
 func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
+	return manager.verify(accessToken, accessTokenType)
+}
+
+// VerifyRefreshToken verifies the refresh token string and return a user claim if the token is valid
+func (manager *JWTManager) VerifyRefreshToken(refreshToken string) (*UserClaims, error) {
+	return manager.verify(refreshToken, refreshTokenType)
+}
+
+// VerifyChallengeToken verifies the challenge token string and return a user claim if the token is valid
+func (manager *JWTManager) VerifyChallengeToken(challengeToken string) (*UserClaims, error) {
+	return manager.verify(challengeToken, challengeTokenType)
+}
+
+func (manager *JWTManager) verify(tokenString string, tokenType string) (*UserClaims, error) {
 	token, err := jwt.ParseWithClaims(
-		accessToken,
+		tokenString,
 		&UserClaims{},
 		func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodHMAC)
-			if !ok {
+			keyID, _ := token.Header["kid"].(string)
+			key := manager.keySet.Find(keyID)
+			if key == nil {
+				return nil, fmt.Errorf("unknown signing key: %s", keyID)
+			}
+
+			// the algorithm must match the key to prevent algorithm confusion attacks
+			if token.Method.Alg() != key.Method.Alg() {
 				return nil, fmt.Errorf("unexpected token signing method")
 			}
 
-			return []byte(manager.secretKey), nil
+			return key.verifyKey, nil
 		},
 	)
 
@@ -63,5 +177,33 @@ func (manager *JWTManager) Verify(accessToken string) (*UserClaims, error) {
 		return nil, fmt.Errorf("invalid token claims")
 	}
 
+	if claims.TokenType != tokenType {
+		return nil, fmt.Errorf("invalid token type: %s", claims.TokenType)
+	}
+
+	if claims.Id == "" {
+		return nil, fmt.Errorf("token id is not provided")
+	}
+
+	revoked, err := manager.revocationStore.IsRevoked(claims.Id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot check token revocation: %w", err)
+	}
+
+	if revoked {
+		return nil, fmt.Errorf("token has been revoked")
+	}
+
 	return claims, nil
 }
+
+// PublicKeys returns the JSON web keys that can be used to verify tokens
+func (manager *JWTManager) PublicKeys() []*pb.JsonWebKey {
+	return manager.keySet.PublicKeys()
+}
+
+// Revoke revokes the token with the given claims until it expires,
+// or returns ErrTokenRevoked if it has already been revoked
+func (manager *JWTManager) Revoke(claims *UserClaims) error {
+	return manager.revocationStore.Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0))
+}
@@ -0,0 +1,131 @@
+package service_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+)
+
+func generateTestSigningKey(t *testing.T, alg string) *service.SigningKey {
+	var privateKey crypto.Signer
+	var err error
+
+	switch alg {
+	case "RS256":
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	}
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+
+	key, err := service.ParsePrivateKeyPEM(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	require.NoError(t, err)
+	require.Equal(t, alg, key.Method.Alg())
+	require.NotEmpty(t, key.ID)
+
+	return key
+}
+
+func TestJWTManagerAsymmetricKeys(t *testing.T) {
+	t.Parallel()
+
+	user, err := service.NewUser("user1", "secret", "user")
+	require.NoError(t, err)
+
+	for _, alg := range []string{"RS256", "ES256", "EdDSA"} {
+		alg := alg
+		t.Run(alg, func(t *testing.T) {
+			t.Parallel()
+
+			key := generateTestSigningKey(t, alg)
+			keySet, err := service.NewKeySet(key)
+			require.NoError(t, err)
+
+			jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+			token, err := jwtManager.Generate(user)
+			require.NoError(t, err)
+
+			claims, err := jwtManager.Verify(token)
+			require.NoError(t, err)
+			require.Equal(t, "user1", claims.Username)
+
+			jwks := jwtManager.PublicKeys()
+			require.Len(t, jwks, 1)
+			require.Equal(t, key.ID, jwks[0].GetKid())
+			require.Equal(t, alg, jwks[0].GetAlg())
+		})
+	}
+}
+
+func TestJWTManagerKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	user, err := service.NewUser("user1", "secret", "user")
+	require.NoError(t, err)
+
+	oldKey := generateTestSigningKey(t, "ES256")
+	newKey := generateTestSigningKey(t, "EdDSA")
+
+	keySet, err := service.NewKeySet(oldKey)
+	require.NoError(t, err)
+
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	oldToken, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+
+	require.NoError(t, keySet.Rotate(newKey))
+	require.Len(t, jwtManager.PublicKeys(), 2)
+
+	newToken, err := jwtManager.Generate(user)
+	require.NoError(t, err)
+
+	_, err = jwtManager.Verify(oldToken)
+	require.NoError(t, err)
+	_, err = jwtManager.Verify(newToken)
+	require.NoError(t, err)
+
+	require.Error(t, keySet.Remove(newKey.ID))
+	require.NoError(t, keySet.Remove(oldKey.ID))
+
+	_, err = jwtManager.Verify(oldToken)
+	require.Error(t, err)
+	_, err = jwtManager.Verify(newToken)
+	require.NoError(t, err)
+
+	// uma chave somente de verificação não pode assinar tokens
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	publicKey, err := service.NewPublicKey(pub)
+	require.NoError(t, err)
+	require.Error(t, keySet.Rotate(publicKey))
+}
+
+func TestSigningKeyThumbprint(t *testing.T) {
+	t.Parallel()
+
+	// exemplo da RFC 7638, seção 3.1
+	n, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	require.NoError(t, err)
+
+	key, err := service.NewPublicKey(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537})
+	require.NoError(t, err)
+	require.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", key.ID)
+	require.False(t, key.CanSign())
+}
@@ -2,6 +2,7 @@ package service_test
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -16,6 +17,8 @@ import (
 	"github.com/pcbook-go/service"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestClientCreateLaptop(t *testing.T) {
@@ -36,7 +39,7 @@ func TestClientCreateLaptop(t *testing.T) {
 	require.Equal(t, expectedID, res.Id)
 
 	// verificando se o laptop ja existe
-	other, err := laptopStore.Find(res.Id)
+	other, err := laptopStore.Find(context.Background(), res.Id)
 	require.NoError(t, err)
 	require.NotNil(t, other)
 
@@ -44,10 +47,16 @@ func TestClientCreateLaptop(t *testing.T) {
 	requireSameLaptop(t, laptop, other)
 }
 
-func startTestLaptopServer(t *testing.T, laptopStore service.LaptopStore, imageStore service.ImageStore, ratingStore service.RatingStore) string {
+func startTestLaptopServer(
+	t *testing.T,
+	laptopStore service.LaptopStore,
+	imageStore service.ImageStore,
+	ratingStore service.RatingStore,
+	options ...grpc.ServerOption,
+) string {
 	laptopServer := service.NewLaptopServer(laptopStore, imageStore, ratingStore)
 
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(options...)
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
 
 	listener, err := net.Listen("tcp", ":0") // random available port
@@ -99,7 +108,7 @@ func TestClientSearchLaptop(t *testing.T) {
 			expectedIDs[laptop.Id] = true
 		}
 
-		err := laptopStore.Save(laptop)
+		err := laptopStore.Save(context.Background(), laptop)
 		require.NoError(t, err)
 	}
 	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
@@ -133,11 +142,13 @@ func TestClientUploadImage(t *testing.T) {
 	laptopStore := service.NewInMemoryLaptopStore()
 	imageStore := service.NewDiskIMageStore(testImageFolder)
 
+	// somente o dono pode enviar imagens do laptop
 	laptop := sample.NewLaptop()
-	err := laptopStore.Save(laptop)
+	laptop.Owner = "admin1"
+	err := laptopStore.Save(context.Background(), laptop)
 	require.NoError(t, err)
 
-	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil, withTestUser(&service.UserClaims{Username: "admin1", Role: "admin"})...)
 	laptopClient := newTestLaptopClient(t, serverAddress)
 
 	imagePath := fmt.Sprintf("%s/laptop2.jpg", testImageFolder)
@@ -195,6 +206,42 @@ func TestClientUploadImage(t *testing.T) {
 
 }
 
+func TestClientUploadImageType(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageFolder := t.TempDir()
+	imageStore := service.NewDiskIMageStore(imageFolder)
+
+	laptop := sample.NewLaptop()
+	laptop.Owner = "admin1"
+	require.NoError(t, laptopStore.Save(context.Background(), laptop))
+
+	serverAddress := startTestLaptopServer(t, laptopStore, imageStore, nil, withTestUser(&service.UserClaims{Username: "admin1", Role: "admin"})...)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	// só as extensões de imagem são aceitas, o tipo não pode sair da pasta das imagens
+	for _, imageType := range []string{".html", ".svg", "", "/../../laptop.jpg", `\..\laptop.jpg`} {
+		stream, err := laptopClient.UploadImage(context.Background())
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Info{Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: imageType}},
+		})
+		require.NoError(t, err)
+
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, codes.InvalidArgument, status.Code(err), imageType)
+	}
+
+	entries, err := os.ReadDir(imageFolder)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	_, err = imageStore.Save(context.Background(), laptop.GetId(), "/../laptop.jpg", bytes.Buffer{})
+	require.Error(t, err)
+}
+
 func TestClientRateLaptop(t *testing.T) {
 	t.Parallel()
 
@@ -202,7 +249,7 @@ func TestClientRateLaptop(t *testing.T) {
 	ratingStore := service.NewInMemoryRatingStore()
 
 	laptop := sample.NewLaptop()
-	err := laptopStore.Save(laptop)
+	err := laptopStore.Save(context.Background(), laptop)
 	require.NoError(t, err)
 
 	serverAddress := startTestLaptopServer(t, laptopStore, nil, ratingStore)
@@ -250,15 +297,49 @@ func TestFindLaptop(t *testing.T) {
 	laptop := sample.NewLaptop()
 	expectedIDs := laptop.Id
 
-	err := laptopStore.Save(laptop)
+	err := laptopStore.Save(context.Background(), laptop)
 	require.NoError(t, err)
 
-	laptopRetornado, err := laptopStore.Find(laptop.Id)
+	laptopRetornado, err := laptopStore.Find(context.Background(), laptop.Id)
 	require.NoError(t, err)
 	require.NotEmpty(t,laptopRetornado)
 
 	require.Equal(t, expectedIDs, laptopRetornado.Id)
 }
+
+// withTestUser autentica todas as chamadas do servidor de teste com o usuário,
+// como o AuthInterceptor faz depois de verificar o token
+func withTestUser(claims *service.UserClaims) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(
+			ctx context.Context,
+			req interface{},
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (interface{}, error) {
+			return handler(service.ContextWithUserClaims(ctx, claims), req)
+		}),
+		grpc.StreamInterceptor(func(
+			srv interface{},
+			stream grpc.ServerStream,
+			info *grpc.StreamServerInfo,
+			handler grpc.StreamHandler,
+		) error {
+			ctx := service.ContextWithUserClaims(stream.Context(), claims)
+			return handler(srv, &userServerStream{ServerStream: stream, ctx: ctx})
+		}),
+	}
+}
+
+type userServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *userServerStream) Context() context.Context {
+	return stream.ctx
+}
+
 func newTestLaptopClient(t *testing.T, serverAddress string) pb.LaptopServiceClient {
 	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
 	require.NoError(t, err)
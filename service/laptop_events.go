@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	"github.com/pcbook-go/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	// ErrEventsExpired é retornado ao retomar depois de um evento que já saiu do histórico
+	ErrEventsExpired = errors.New("os eventos pedidos não estão mais no histórico")
+	// ErrUnknownSequence é retornado ao retomar depois de um evento que ainda não aconteceu
+	ErrUnknownSequence = errors.New("o número de sequência ainda não existe")
+	// ErrSubscriptionDropped encerra a inscrição de um observador que não acompanhou os eventos
+	ErrSubscriptionDropped = errors.New("o observador não acompanhou os eventos")
+	// ErrEventBusClosed encerra as inscrições quando o servidor é desligado
+	ErrEventBusClosed = errors.New("o barramento de eventos foi fechado")
+)
+
+// laptopEventBufferSize é o número de eventos que um observador pode ter pendentes antes de ser desconectado
+const laptopEventBufferSize = 64
+
+// LaptopChange é um evento com o laptop antes da alteração, para que um observador com filtro
+// saiba quando uma atualização tira o laptop do filtro
+type LaptopChange struct {
+	Event *pb.LaptopEvent
+	// Previous é o laptop antes de uma atualização, nil nos outros eventos
+	Previous *pb.Laptop
+}
+
+// LaptopEventBus distribui os eventos das alterações dos laptops aos observadores,
+// e guarda os últimos eventos para que um observador reconectado retome sem perder nenhum.
+// A época identifica o barramento: os números de sequência de outro servidor, ou de antes
+// de reiniciar, não valem para ele.
+type LaptopEventBus struct {
+	mutex       sync.Mutex
+	epoch       string
+	sequence    uint64
+	history     []*LaptopChange
+	historySize int
+	subscribers map[*LaptopSubscription]bool
+	closed      bool
+}
+
+// NewLaptopEventBus retorna um novo barramento guardando os últimos historySize eventos
+func NewLaptopEventBus(historySize int) *LaptopEventBus {
+	return &LaptopEventBus{
+		epoch:       uuid.New().String(),
+		historySize: historySize,
+		subscribers: make(map[*LaptopSubscription]bool),
+	}
+}
+
+// Publish numera o evento de um laptop e o envia aos observadores, com o laptop antes da alteração se houver.
+// Os observadores que não têm espaço para o evento são desconectados, para não atrasar os outros.
+func (bus *LaptopEventBus) Publish(eventType pb.LaptopEvent_Type, laptop *pb.Laptop, previous *pb.Laptop) *pb.LaptopEvent {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.sequence++
+	event := &pb.LaptopEvent{
+		Sequence: bus.sequence,
+		Type:     eventType,
+		Laptop:   laptop,
+		Time:     ptypes.TimestampNow(),
+	}
+
+	change := &LaptopChange{Event: event, Previous: previous}
+	bus.history = append(bus.history, change)
+	if len(bus.history) > bus.historySize {
+		bus.history = append([]*LaptopChange(nil), bus.history[len(bus.history)-bus.historySize:]...)
+	}
+
+	for subscription := range bus.subscribers {
+		select {
+		case subscription.events <- change:
+		default:
+			bus.unsubscribe(subscription, ErrSubscriptionDropped)
+		}
+	}
+
+	return event
+}
+
+// Epoch retorna a época do barramento, que não muda enquanto ele existe
+func (bus *LaptopEventBus) Epoch() string {
+	return bus.epoch
+}
+
+// Sequence retorna o número de sequência do último evento
+func (bus *LaptopEventBus) Sequence() uint64 {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	return bus.sequence
+}
+
+// Subscribe inscreve um observador nos eventos depois de afterSequence, ou nos novos eventos se afterSequence é 0.
+// Os eventos do histórico são retornados por Replay, e os seguintes recebidos de Events, sem falhas nem repetições.
+func (bus *LaptopEventBus) Subscribe(afterSequence uint64) (*LaptopSubscription, error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	if bus.closed {
+		return nil, ErrEventBusClosed
+	}
+
+	if afterSequence > bus.sequence {
+		return nil, fmt.Errorf("%w: %d > %d", ErrUnknownSequence, afterSequence, bus.sequence)
+	}
+
+	subscription := &LaptopSubscription{
+		bus:      bus,
+		sequence: bus.sequence,
+		events:   make(chan *LaptopChange, laptopEventBufferSize),
+	}
+
+	if afterSequence > 0 && afterSequence < bus.sequence {
+		oldest := bus.sequence - uint64(len(bus.history)) + 1
+		if afterSequence+1 < oldest {
+			return nil, fmt.Errorf("%w: o evento mais antigo é %d", ErrEventsExpired, oldest)
+		}
+
+		subscription.replay = append([]*LaptopChange(nil), bus.history[afterSequence+1-oldest:]...)
+	}
+
+	bus.subscribers[subscription] = true
+	return subscription, nil
+}
+
+// Close encerra as inscrições de todos os observadores
+func (bus *LaptopEventBus) Close() {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	bus.closed = true
+	for subscription := range bus.subscribers {
+		bus.unsubscribe(subscription, ErrEventBusClosed)
+	}
+}
+
+func (bus *LaptopEventBus) unsubscribe(subscription *LaptopSubscription, err error) {
+	if !bus.subscribers[subscription] {
+		return
+	}
+
+	delete(bus.subscribers, subscription)
+	subscription.err = err
+	close(subscription.events)
+}
+
+// LaptopSubscription é a inscrição de um observador nos eventos dos laptops
+type LaptopSubscription struct {
+	bus      *LaptopEventBus
+	sequence uint64
+	replay   []*LaptopChange
+	events   chan *LaptopChange
+	err      error
+}
+
+// Sequence retorna o número de sequência do último evento no momento da inscrição
+func (subscription *LaptopSubscription) Sequence() uint64 {
+	return subscription.sequence
+}
+
+// Replay retorna os eventos do histórico pedidos na inscrição
+func (subscription *LaptopSubscription) Replay() []*LaptopChange {
+	return subscription.replay
+}
+
+// Events retorna os novos eventos, o canal é fechado quando a inscrição termina
+func (subscription *LaptopSubscription) Events() <-chan *LaptopChange {
+	return subscription.events
+}
+
+// Err retorna o motivo do fim da inscrição, depois que o canal de eventos é fechado
+func (subscription *LaptopSubscription) Err() error {
+	subscription.bus.mutex.Lock()
+	defer subscription.bus.mutex.Unlock()
+
+	return subscription.err
+}
+
+// Close cancela a inscrição
+func (subscription *LaptopSubscription) Close() {
+	subscription.bus.mutex.Lock()
+	defer subscription.bus.mutex.Unlock()
+
+	subscription.bus.unsubscribe(subscription, nil)
+}
+
+// EventLaptopStore publica um evento a cada laptop criado, atualizado ou removido em uma loja de laptops.
+// As alterações são feitas e publicadas sob o mesmo lock, para que os eventos sigam a ordem das alterações da loja.
+type EventLaptopStore struct {
+	LaptopStore
+	bus   *LaptopEventBus
+	mutex sync.Mutex
+}
+
+// NewEventLaptopStore retorna uma loja de laptops que publica as alterações da loja no barramento
+func NewEventLaptopStore(store LaptopStore, bus *LaptopEventBus) *EventLaptopStore {
+	return &EventLaptopStore{
+		LaptopStore: store,
+		bus:         bus,
+	}
+}
+
+func (store *EventLaptopStore) Save(ctx context.Context, laptop *pb.Laptop) error {
+	other, err := deepCopy(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err = store.LaptopStore.Save(ctx, laptop)
+	if err != nil {
+		return err
+	}
+
+	store.bus.Publish(pb.LaptopEvent_CREATED, other, nil)
+	return nil
+}
+
+func (store *EventLaptopStore) SaveAll(ctx context.Context, laptops []*pb.Laptop) error {
+	others := make([]*pb.Laptop, len(laptops))
+	for i, laptop := range laptops {
+		other, err := deepCopy(laptop)
+		if err != nil {
+			return &LaptopBatchError{Index: i, Err: err}
+		}
+		others[i] = other
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.LaptopStore.SaveAll(ctx, laptops)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range others {
+		store.bus.Publish(pb.LaptopEvent_CREATED, other, nil)
+	}
+	return nil
+}
+
+// Update publica o laptop atualizado junto com o laptop como ele estava antes
+func (store *EventLaptopStore) Update(ctx context.Context, laptop *pb.Laptop, updatedAt *timestamppb.Timestamp) error {
+	other, err := deepCopy(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	previous, err := store.LaptopStore.Find(ctx, laptop.GetId())
+	if err != nil {
+		return err
+	}
+
+	err = store.LaptopStore.Update(ctx, laptop, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	store.bus.Publish(pb.LaptopEvent_UPDATED, other, previous)
+	return nil
+}
+
+// Delete publica o laptop como ele estava antes de ser removido
+func (store *EventLaptopStore) Delete(ctx context.Context, id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	laptop, err := store.LaptopStore.Find(ctx, id)
+	if err != nil {
+		return err
+	}
+	if laptop == nil {
+		return ErrNotFound
+	}
+
+	err = store.LaptopStore.Delete(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	store.bus.Publish(pb.LaptopEvent_DELETED, laptop, nil)
+	return nil
+}
@@ -0,0 +1,268 @@
+package service_test
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestLaptopEventBus(t *testing.T) {
+	t.Parallel()
+
+	bus := service.NewLaptopEventBus(3)
+	store := service.NewEventLaptopStore(service.NewInMemoryLaptopStore(), bus)
+	ctx := context.Background()
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(ctx, laptop))
+	require.NoError(t, store.Update(ctx, laptop, laptop.GetUpdatedAt()))
+	require.NoError(t, store.Delete(ctx, laptop.GetId()))
+	require.ErrorIs(t, store.Delete(ctx, laptop.GetId()), service.ErrNotFound)
+	require.Equal(t, uint64(3), bus.Sequence())
+
+	// os eventos depois do primeiro são reenviados a quem retoma
+	subscription, err := bus.Subscribe(1)
+	require.NoError(t, err)
+	replay := subscription.Replay()
+	require.Len(t, replay, 2)
+	require.Equal(t, pb.LaptopEvent_UPDATED, replay[0].Event.GetType())
+	requireSameLaptop(t, laptop, replay[0].Previous)
+	require.Equal(t, pb.LaptopEvent_DELETED, replay[1].Event.GetType())
+	require.Equal(t, laptop.GetId(), replay[1].Event.GetLaptop().GetId())
+	require.Nil(t, replay[1].Previous)
+
+	other := sample.NewLaptop()
+	require.NoError(t, store.Save(ctx, other))
+	change := <-subscription.Events()
+	require.Equal(t, uint64(4), change.Event.GetSequence())
+	require.Equal(t, pb.LaptopEvent_CREATED, change.Event.GetType())
+
+	_, err = bus.Subscribe(5)
+	require.ErrorIs(t, err, service.ErrUnknownSequence)
+
+	// um observador que não acompanha os eventos é desconectado
+	for i := 0; i < 100; i++ {
+		bus.Publish(pb.LaptopEvent_UPDATED, other, other)
+	}
+	for range subscription.Events() {
+	}
+	require.ErrorIs(t, subscription.Err(), service.ErrSubscriptionDropped)
+
+	// o histórico guarda somente os 3 últimos eventos
+	_, err = bus.Subscribe(100)
+	require.ErrorIs(t, err, service.ErrEventsExpired)
+	subscription, err = bus.Subscribe(101)
+	require.NoError(t, err)
+	require.Len(t, subscription.Replay(), 3)
+
+	bus.Close()
+	_, err = bus.Subscribe(0)
+	require.ErrorIs(t, err, service.ErrEventBusClosed)
+}
+
+// slowLaptopStore demora para retornar depois de atualizar um laptop,
+// como uma loja remota demoraria para responder
+type slowLaptopStore struct {
+	service.LaptopStore
+}
+
+func (store *slowLaptopStore) Update(ctx context.Context, laptop *pb.Laptop, updatedAt *timestamppb.Timestamp) error {
+	err := store.LaptopStore.Update(ctx, laptop, updatedAt)
+	time.Sleep(time.Duration(rand.Intn(1000)) * time.Microsecond)
+	return err
+}
+
+func TestEventLaptopStoreOrder(t *testing.T) {
+	t.Parallel()
+
+	bus := service.NewLaptopEventBus(1000)
+	store := service.NewEventLaptopStore(&slowLaptopStore{LaptopStore: service.NewInMemoryLaptopStore()}, bus)
+	ctx := context.Background()
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(ctx, laptop))
+
+	subscription, err := bus.Subscribe(0)
+	require.NoError(t, err)
+	defer subscription.Close()
+
+	// os eventos das atualizações simultâneas seguem a ordem em que a loja as aplicou
+	const updates = 50
+	var wg sync.WaitGroup
+	for i := 0; i < updates; i++ {
+		update := proto.Clone(laptop).(*pb.Laptop)
+		update.PriceUsd = float64(i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, store.Update(ctx, update, update.GetUpdatedAt()))
+		}()
+	}
+	wg.Wait()
+
+	var last *service.LaptopChange
+	for i := 0; i < updates; i++ {
+		last = <-subscription.Events()
+	}
+
+	saved, err := store.Find(ctx, laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, saved.GetPriceUsd(), last.Event.GetLaptop().GetPriceUsd())
+}
+
+func startTestWatchServer(t *testing.T) (service.LaptopStore, *service.LaptopEventBus, pb.LaptopServiceClient) {
+	bus := service.NewLaptopEventBus(100)
+	laptopStore := service.NewEventLaptopStore(service.NewInMemoryLaptopStore(), bus)
+	laptopServer := service.NewLaptopServer(laptopStore, nil, nil, service.WithLaptopEvents(bus))
+
+	// os laptops salvos direto na loja não têm dono, e só podem ser removidos por um superadmin
+	grpcServer := grpc.NewServer(withTestUser(&service.UserClaims{Username: "root", Role: service.SuperadminRole})...)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	return laptopStore, bus, newTestLaptopClient(t, listener.Addr().String())
+}
+
+func TestClientWatchLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore, bus, laptopClient := startTestWatchServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cheap := sample.NewLaptop()
+	cheap.PriceUsd = 1000
+	require.NoError(t, laptopStore.Save(ctx, cheap))
+
+	// sem after_sequence somente os novos eventos são enviados
+	stream, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{Filter: &pb.Filter{MaxPriceUsd: 2000}})
+	require.NoError(t, err)
+	header, err := stream.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{"1"}, header.Get(service.WatchSequenceHeader))
+	require.Equal(t, []string{bus.Epoch()}, header.Get(service.WatchEpochHeader))
+
+	expensive := sample.NewLaptop()
+	expensive.PriceUsd = 3000
+	require.NoError(t, laptopStore.Save(ctx, expensive))
+	require.NoError(t, laptopStore.Update(ctx, cheap, cheap.GetUpdatedAt()))
+
+	// o laptop caro não passa no filtro
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), res.GetEvent().GetSequence())
+	require.Equal(t, pb.LaptopEvent_UPDATED, res.GetEvent().GetType())
+	requireSameLaptop(t, cheap, res.GetEvent().GetLaptop())
+
+	_, err = laptopClient.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: cheap.GetId()})
+	require.NoError(t, err)
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, pb.LaptopEvent_DELETED, res.GetEvent().GetType())
+
+	_, err = laptopClient.DeleteLaptop(ctx, &pb.DeleteLaptopRequest{Id: cheap.GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// o observador reconectado recebe os eventos que perdeu
+	epochCtx := metadata.AppendToOutgoingContext(ctx, service.WatchEpochHeader, bus.Epoch())
+	resumed, err := laptopClient.WatchLaptops(epochCtx, &pb.WatchLaptopsRequest{AfterSequence: 1})
+	require.NoError(t, err)
+	for _, sequence := range []uint64{2, 3, 4} {
+		res, err := resumed.Recv()
+		require.NoError(t, err)
+		require.Equal(t, sequence, res.GetEvent().GetSequence())
+	}
+
+	resumed, err = laptopClient.WatchLaptops(epochCtx, &pb.WatchLaptopsRequest{AfterSequence: 10})
+	require.NoError(t, err)
+	_, err = resumed.Recv()
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	// os números de sequência de outro servidor, ou sem a época, não são retomados
+	for _, resumeCtx := range []context.Context{
+		ctx,
+		metadata.AppendToOutgoingContext(ctx, service.WatchEpochHeader, service.NewLaptopEventBus(1).Epoch()),
+	} {
+		resumed, err = laptopClient.WatchLaptops(resumeCtx, &pb.WatchLaptopsRequest{AfterSequence: 1})
+		require.NoError(t, err)
+		_, err = resumed.Recv()
+		require.Equal(t, codes.OutOfRange, status.Code(err))
+	}
+
+	// os observadores são desconectados quando o servidor desliga
+	bus.Close()
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestClientWatchLaptopsLeaveFilter(t *testing.T) {
+	t.Parallel()
+
+	laptopStore, _, laptopClient := startTestWatchServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	laptop := sample.NewLaptop()
+	laptop.PriceUsd = 1000
+	require.NoError(t, laptopStore.Save(ctx, laptop))
+
+	stream, err := laptopClient.WatchLaptops(ctx, &pb.WatchLaptopsRequest{Filter: &pb.Filter{MaxPriceUsd: 2000}})
+	require.NoError(t, err)
+	_, err = stream.Header()
+	require.NoError(t, err)
+
+	// a atualização que tira o laptop do filtro é enviada, as seguintes não
+	expensive := proto.Clone(laptop).(*pb.Laptop)
+	expensive.PriceUsd = 3000
+	require.NoError(t, laptopStore.Update(ctx, expensive, laptop.GetUpdatedAt()))
+	saved, err := laptopStore.Find(ctx, laptop.GetId())
+	require.NoError(t, err)
+	require.NoError(t, laptopStore.Update(ctx, saved, saved.GetUpdatedAt()))
+	saved, err = laptopStore.Find(ctx, laptop.GetId())
+	require.NoError(t, err)
+	saved.PriceUsd = 1500
+	require.NoError(t, laptopStore.Update(ctx, saved, saved.GetUpdatedAt()))
+
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), res.GetEvent().GetSequence())
+	require.Equal(t, pb.LaptopEvent_UPDATED, res.GetEvent().GetType())
+	require.Equal(t, float64(3000), res.GetEvent().GetLaptop().GetPriceUsd())
+
+	// o laptop volta ao filtro
+	res, err = stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, uint64(4), res.GetEvent().GetSequence())
+	require.Equal(t, float64(1500), res.GetEvent().GetLaptop().GetPriceUsd())
+}
+
+func TestWatchLaptopsUnimplemented(t *testing.T) {
+	t.Parallel()
+
+	serverAddress := startTestLaptopServer(t, service.NewInMemoryLaptopStore(), nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	stream, err := laptopClient.WatchLaptops(context.Background(), &pb.WatchLaptopsRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bufio"
+	"math"
+	"strings"
+
+	"github.com/pcbook-go/logging"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/serializer"
+	"google.golang.org/grpc/status"
+)
+
+// exportChunkSize é o tamanho das partes em que o arquivo é enviado por ExportLaptops
+const exportChunkSize = 64 * 1024
+
+// exportChunkWriter envia cada parte gravada em uma mensagem do stream
+type exportChunkWriter struct {
+	stream pb.LaptopService_ExportLaptopsServer
+}
+
+func (writer *exportChunkWriter) Write(data []byte) (int, error) {
+	err := contextError(writer.stream.Context())
+	if err != nil {
+		return 0, err
+	}
+
+	// a mensagem é serializada antes que o bufio reutilize o buffer
+	err = writer.stream.Send(&pb.ExportLaptopsResponse{ChunkData: data})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+// ExportLaptops é um RPC de streaming de servidor que envia os laptops em um arquivo no formato pedido.
+// O arquivo é enviado em partes, e pode ser lido pelo serializer e enviado de volta por ImportLaptops.
+func (server *LaptopServer) ExportLaptops(
+	req *pb.ExportLaptopsRequest,
+	stream pb.LaptopService_ExportLaptopsServer,
+) error {
+	ctx := stream.Context()
+	format := serializer.Format(strings.ToLower(req.GetFormat().String()))
+	logger := logging.FromContext(ctx)
+	logger.Debug("export laptops request received", "filter", req.GetFilter().String(), "format", format)
+
+	// sem filtro todos os laptops são exportados
+	filter := req.GetFilter()
+	if filter == nil {
+		filter = &pb.Filter{MaxPriceUsd: math.MaxFloat64}
+	}
+
+	buffer := bufio.NewWriterSize(&exportChunkWriter{stream: stream}, exportChunkSize)
+	writer, err := serializer.NewMessageWriter(buffer, format)
+	if err != nil {
+		return newError(ReasonInvalidRequest, "", "formato inválido: %v", err)
+	}
+
+	// os laptops são copiados antes de serem enviados, para que um cliente lento
+	// não segure a loja e bloqueie as alterações enquanto recebe o arquivo
+	var laptops []*pb.Laptop
+	err = server.laptopStore.Search(ctx, filter, func(laptop *pb.Laptop) error {
+		laptops = append(laptops, laptop)
+		return nil
+	})
+	if err != nil {
+		return laptopStoreError(err, "")
+	}
+
+	for _, laptop := range laptops {
+		err = writer.Write(laptop)
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err == nil {
+		err = buffer.Flush()
+	}
+
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		return newError(ReasonInternal, "", "erro ao exportar laptops: %v", err)
+	}
+
+	logger.Info("laptops exported", "format", format, "count", len(laptops))
+	return nil
+}
@@ -0,0 +1,166 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/serializer"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+)
+
+// exportLaptops junta as partes do arquivo de um ExportLaptops
+func exportLaptops(t *testing.T, laptopClient pb.LaptopServiceClient, req *pb.ExportLaptopsRequest) []byte {
+	stream, err := laptopClient.ExportLaptops(context.Background(), req)
+	require.NoError(t, err)
+
+	var data bytes.Buffer
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return data.Bytes()
+		}
+		require.NoError(t, err)
+		data.Write(res.GetChunkData())
+	}
+}
+
+func sortLaptops(laptops []*pb.Laptop) {
+	sort.Slice(laptops, func(i, j int) bool {
+		return laptops[i].GetId() < laptops[j].GetId()
+	})
+}
+
+func TestClientExportLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptops := make([]*pb.Laptop, 300)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+		require.NoError(t, laptopStore.Save(context.Background(), laptops[i]))
+	}
+	laptops[0].Weight = &pb.Laptop_WeightLb{WeightLb: 4.5}
+	require.NoError(t, laptopStore.Update(context.Background(), laptops[0], laptops[0].GetUpdatedAt()))
+	sortLaptops(laptops)
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	for format := range pb.ExportLaptopsRequest_Format_value {
+		format := format
+
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			// o arquivo exportado é lido e importado em outro servidor
+			data := exportLaptops(t, laptopClient, &pb.ExportLaptopsRequest{
+				Format: pb.ExportLaptopsRequest_Format(pb.ExportLaptopsRequest_Format_value[format]),
+			})
+
+			reader, err := serializer.NewMessageReader(bytes.NewReader(data), serializer.Format(strings.ToLower(format)))
+			require.NoError(t, err)
+
+			var exported []*pb.Laptop
+			for {
+				laptop := &pb.Laptop{}
+				err := reader.Read(laptop)
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				exported = append(exported, laptop)
+			}
+
+			otherStore := service.NewInMemoryLaptopStore()
+			otherAddress := startTestLaptopServer(t, otherStore, nil, nil)
+			res := importLaptops(t, newTestLaptopClient(t, otherAddress), pb.ImportOptions_ALL_OR_NOTHING, exported)
+			require.EqualValues(t, len(laptops), res.GetImported())
+
+			imported := make([]*pb.Laptop, 0, len(laptops))
+			err = otherStore.Search(context.Background(), &pb.Filter{MaxPriceUsd: 1e9}, func(laptop *pb.Laptop) error {
+				imported = append(imported, laptop)
+				return nil
+			})
+			require.NoError(t, err)
+			sortLaptops(imported)
+
+			require.Len(t, imported, len(laptops))
+			for i := range laptops {
+				require.True(t, proto.Equal(laptops[i], imported[i]), "%v\n%v", laptops[i], imported[i])
+			}
+		})
+	}
+}
+
+func TestClientExportLaptopsFilter(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	cheap := sample.NewLaptop()
+	cheap.PriceUsd = 1000
+	expensive := sample.NewLaptop()
+	expensive.PriceUsd = 3000
+	require.NoError(t, laptopStore.Save(context.Background(), cheap))
+	require.NoError(t, laptopStore.Save(context.Background(), expensive))
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	// só o laptop do filtro é exportado
+	data := exportLaptops(t, laptopClient, &pb.ExportLaptopsRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 2000},
+		Format: pb.ExportLaptopsRequest_NDJSON,
+	})
+	require.Equal(t, 1, bytes.Count(data, []byte("\n")))
+	require.Contains(t, string(data), cheap.GetId())
+
+	// sem laptops o arquivo JSON é um array vazio
+	data = exportLaptops(t, laptopClient, &pb.ExportLaptopsRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 1},
+		Format: pb.ExportLaptopsRequest_JSON,
+	})
+	require.Equal(t, "[]\n", string(data))
+}
+
+func TestClientExportLaptopsSlowReader(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	for i := 0; i < 2000; i++ {
+		require.NoError(t, laptopStore.Save(context.Background(), sample.NewLaptop()))
+	}
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// o cliente recebe a primeira parte e para de ler, deixando o servidor esperando para enviar o resto
+	stream, err := laptopClient.ExportLaptops(ctx, &pb.ExportLaptopsRequest{Format: pb.ExportLaptopsRequest_JSON})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	// a loja continua aceitando alterações enquanto o arquivo é enviado
+	saved := make(chan error, 1)
+	go func() {
+		saved <- laptopStore.Save(context.Background(), sample.NewLaptop())
+	}()
+
+	select {
+	case err := <-saved:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("a loja ficou bloqueada pelo ExportLaptops")
+	}
+}
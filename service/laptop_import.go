@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/pcbook-go/logging"
+	"github.com/pcbook-go/pb"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxImportLaptops é o número máximo padrão de laptops de um ImportLaptops
+const DefaultMaxImportLaptops = 50000
+
+// importBatchSize é o número de laptops salvos de uma vez no modo BEST_EFFORT
+const importBatchSize = 100
+
+// maxImportErrors limita os erros detalhados na resposta, os outros só são contados
+const maxImportErrors = 100
+
+// WithMaxImportLaptops define o número máximo de laptops recebidos por um ImportLaptops
+func WithMaxImportLaptops(maxImportLaptops int) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxImportLaptops = maxImportLaptops
+	}
+}
+
+// importItem é um laptop recebido pelo ImportLaptops, com a sua posição no stream
+type importItem struct {
+	index  uint32
+	laptop *pb.Laptop
+}
+
+// laptopImport guarda o estado de um ImportLaptops
+type laptopImport struct {
+	server  *LaptopServer
+	ctx     context.Context
+	mode    pb.ImportOptions_Mode
+	ids     map[string]bool
+	pending []importItem
+	res     *pb.ImportLaptopsResponse
+}
+
+// ImportLaptops é um RPC de streaming de cliente para criar muitos laptops de uma vez.
+// No modo BEST_EFFORT os laptops válidos são salvos em lotes, no modo ALL_OR_NOTHING
+// nenhum laptop é salvo se algum deles falha. Os erros de cada laptop são retornados no resumo.
+func (server *LaptopServer) ImportLaptops(stream pb.LaptopService_ImportLaptopsServer) error {
+	ctx := stream.Context()
+	logger := logging.FromContext(ctx)
+
+	laptopImport := &laptopImport{
+		server: server,
+		ctx:    ctx,
+		ids:    make(map[string]bool),
+		res:    &pb.ImportLaptopsResponse{},
+	}
+
+	for {
+		err := contextError(ctx)
+		if err != nil {
+			return err
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newError(ReasonStreamBroken, "", "erro ao receber laptop: %v", err)
+		}
+
+		if options := req.GetOptions(); options != nil {
+			if laptopImport.res.Received > 0 {
+				return newError(ReasonInvalidRequest, "", "as opções devem vir na primeira mensagem")
+			}
+
+			laptopImport.mode = options.GetMode()
+			logger.Debug("import laptops request received", "mode", laptopImport.mode.String())
+			continue
+		}
+
+		if int(laptopImport.res.Received) >= server.maxImportLaptops {
+			return newError(ReasonImportTooLarge, "", "a importação tem mais de %d laptops", server.maxImportLaptops)
+		}
+
+		laptopImport.add(req.GetLaptop())
+	}
+
+	laptopImport.finish()
+
+	res := laptopImport.res
+	err := stream.SendAndClose(res)
+	if err != nil {
+		return newError(ReasonStreamBroken, "", "erro ao enviar resposta: %v", err)
+	}
+
+	logger.Info("laptops imported", "mode", laptopImport.mode.String(), "received", res.Received, "imported", res.Imported, "failed", res.Failed)
+	return nil
+}
+
+// add verifica um laptop recebido, e salva os laptops pendentes quando um lote está completo
+func (laptopImport *laptopImport) add(laptop *pb.Laptop) {
+	index := laptopImport.res.Received
+	laptopImport.res.Received++
+
+	err := prepareNewLaptop(laptopImport.ctx, laptop)
+	if err != nil {
+		laptopImport.fail(index, laptop.GetId(), err)
+		return
+	}
+
+	if laptopImport.ids[laptop.GetId()] {
+		laptopImport.fail(index, laptop.GetId(), newError(ReasonLaptopAlreadyExists, laptop.GetId(), "laptop id %s repetido na importação", laptop.GetId()))
+		return
+	}
+	laptopImport.ids[laptop.GetId()] = true
+
+	laptopImport.pending = append(laptopImport.pending, importItem{index: index, laptop: laptop})
+
+	if laptopImport.mode == pb.ImportOptions_BEST_EFFORT && len(laptopImport.pending) >= importBatchSize {
+		laptopImport.save()
+	}
+}
+
+// finish salva os laptops pendentes
+func (laptopImport *laptopImport) finish() {
+	if laptopImport.mode != pb.ImportOptions_ALL_OR_NOTHING {
+		laptopImport.save()
+		return
+	}
+
+	// todos os conflitos são reportados, e não só o primeiro encontrado pelo SaveAll
+	for _, item := range laptopImport.pending {
+		existing, err := laptopImport.server.laptopStore.Find(laptopImport.ctx, item.laptop.GetId())
+		if err != nil {
+			laptopImport.fail(item.index, item.laptop.GetId(), err)
+		} else if existing != nil {
+			laptopImport.fail(item.index, item.laptop.GetId(), ErrAlreadyExists)
+		}
+	}
+
+	if laptopImport.res.Failed > 0 {
+		laptopImport.pending = nil
+		return
+	}
+
+	laptops := laptopImport.laptops()
+	err := laptopImport.server.laptopStore.SaveAll(laptopImport.ctx, laptops)
+	if err != nil {
+		laptopImport.failBatch(err)
+		laptopImport.pending = nil
+		return
+	}
+
+	laptopImport.saved(laptops)
+}
+
+// save salva os laptops pendentes, tirando do lote os laptops que falham até que os outros sejam salvos
+func (laptopImport *laptopImport) save() {
+	for len(laptopImport.pending) > 0 {
+		laptops := laptopImport.laptops()
+
+		err := laptopImport.server.laptopStore.SaveAll(laptopImport.ctx, laptops)
+		if err == nil {
+			laptopImport.saved(laptops)
+			return
+		}
+
+		var batchErr *LaptopBatchError
+		if !errors.As(err, &batchErr) {
+			// o erro não é de um laptop, então todos falham
+			for _, item := range laptopImport.pending {
+				laptopImport.fail(item.index, item.laptop.GetId(), err)
+			}
+			laptopImport.pending = nil
+			return
+		}
+
+		item := laptopImport.pending[batchErr.Index]
+		laptopImport.fail(item.index, item.laptop.GetId(), batchErr.Err)
+		laptopImport.pending = append(laptopImport.pending[:batchErr.Index], laptopImport.pending[batchErr.Index+1:]...)
+	}
+}
+
+func (laptopImport *laptopImport) laptops() []*pb.Laptop {
+	laptops := make([]*pb.Laptop, len(laptopImport.pending))
+	for i, item := range laptopImport.pending {
+		laptops[i] = item.laptop
+	}
+	return laptops
+}
+
+// saved conta os laptops salvos e notifica os webhooks
+func (laptopImport *laptopImport) saved(laptops []*pb.Laptop) {
+	laptopImport.res.Imported += uint32(len(laptops))
+	laptopImport.pending = nil
+
+	for _, laptop := range laptops {
+		laptopImport.server.notifyWebhooks(&pb.WebhookEvent{Type: WebhookLaptopCreated, Laptop: laptop})
+	}
+}
+
+// failBatch reporta o erro do SaveAll no laptop que o causou, ou em todos
+func (laptopImport *laptopImport) failBatch(err error) {
+	var batchErr *LaptopBatchError
+	if errors.As(err, &batchErr) {
+		item := laptopImport.pending[batchErr.Index]
+		laptopImport.fail(item.index, item.laptop.GetId(), batchErr.Err)
+		return
+	}
+
+	for _, item := range laptopImport.pending {
+		laptopImport.fail(item.index, item.laptop.GetId(), err)
+	}
+}
+
+// fail conta um laptop que falhou, e guarda o erro se o limite de erros não foi atingido
+func (laptopImport *laptopImport) fail(index uint32, id string, err error) {
+	laptopImport.res.Failed++
+	if len(laptopImport.res.Errors) >= maxImportErrors {
+		return
+	}
+
+	err = laptopStoreError(err, id)
+	st := status.Convert(err)
+	reason, _ := ErrorReasonOf(err)
+
+	laptopImport.res.Errors = append(laptopImport.res.Errors, &pb.ImportError{
+		Index:   index,
+		Id:      id,
+		Code:    st.Code().String(),
+		Message: st.Message(),
+		Reason:  string(reason),
+	})
+}
@@ -0,0 +1,135 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// importLaptops envia os laptops em um ImportLaptops com o modo, e retorna o resumo
+func importLaptops(t *testing.T, laptopClient pb.LaptopServiceClient, mode pb.ImportOptions_Mode, laptops []*pb.Laptop) *pb.ImportLaptopsResponse {
+	stream, err := laptopClient.ImportLaptops(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.ImportLaptopsRequest{Data: &pb.ImportLaptopsRequest_Options{Options: &pb.ImportOptions{Mode: mode}}})
+	require.NoError(t, err)
+
+	for _, laptop := range laptops {
+		err := stream.Send(&pb.ImportLaptopsRequest{Data: &pb.ImportLaptopsRequest_Laptop{Laptop: laptop}})
+		require.NoError(t, err)
+	}
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.EqualValues(t, len(laptops), res.GetReceived())
+	return res
+}
+
+// requireImportErrors verifica as posições e os códigos dos erros de uma importação
+func requireImportErrors(t *testing.T, res *pb.ImportLaptopsResponse, expected map[uint32]codes.Code) {
+	require.EqualValues(t, len(expected), res.GetFailed())
+	require.Len(t, res.GetErrors(), len(expected))
+
+	for _, importErr := range res.GetErrors() {
+		code, ok := expected[importErr.GetIndex()]
+		require.True(t, ok, importErr.String())
+		require.Equal(t, code.String(), importErr.GetCode())
+		require.NotEmpty(t, importErr.GetMessage())
+	}
+}
+
+func TestClientImportLaptopsBestEffort(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	existing := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(context.Background(), existing))
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	// mais de um lote, com falhas no primeiro e no segundo
+	laptops := make([]*pb.Laptop, 250)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+	laptops[3].Id = "invalid"
+	laptops[10].Id = existing.GetId()
+	laptops[150].Id = laptops[120].GetId()
+	laptops[200].Id = ""
+
+	res := importLaptops(t, laptopClient, pb.ImportOptions_BEST_EFFORT, laptops)
+	require.EqualValues(t, 247, res.GetImported())
+	requireImportErrors(t, res, map[uint32]codes.Code{
+		3:   codes.InvalidArgument,
+		10:  codes.AlreadyExists,
+		150: codes.AlreadyExists,
+	})
+	require.Equal(t, existing.GetId(), res.GetErrors()[1].GetId())
+
+	// o laptop sem ID recebe um novo ID
+	require.Equal(t, 248, laptopStore.Count())
+}
+
+func TestClientImportLaptopsAllOrNothing(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	existing := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(context.Background(), existing))
+
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	laptops[1].Id = existing.GetId()
+	laptops[3].Id = "invalid"
+
+	// todos os erros são reportados e nenhum laptop é salvo
+	res := importLaptops(t, laptopClient, pb.ImportOptions_ALL_OR_NOTHING, laptops)
+	require.Zero(t, res.GetImported())
+	requireImportErrors(t, res, map[uint32]codes.Code{
+		1: codes.AlreadyExists,
+		3: codes.InvalidArgument,
+	})
+	require.Equal(t, 1, laptopStore.Count())
+
+	laptops = []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	res = importLaptops(t, laptopClient, pb.ImportOptions_ALL_OR_NOTHING, laptops)
+	require.EqualValues(t, 3, res.GetImported())
+	require.Zero(t, res.GetFailed())
+	require.Equal(t, 4, laptopStore.Count())
+
+	// as opções só podem vir na primeira mensagem
+	stream, err := laptopClient.ImportLaptops(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.ImportLaptopsRequest{Data: &pb.ImportLaptopsRequest_Laptop{Laptop: sample.NewLaptop()}}))
+	require.NoError(t, stream.Send(&pb.ImportLaptopsRequest{Data: &pb.ImportLaptopsRequest_Options{Options: &pb.ImportOptions{}}}))
+	_, err = stream.CloseAndRecv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestInMemoryLaptopStoreSaveAll(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	laptops[2].Id = laptops[0].GetId()
+
+	// o ID repetido impede que qualquer laptop seja salvo
+	err := store.SaveAll(context.Background(), laptops)
+	var batchErr *service.LaptopBatchError
+	require.ErrorAs(t, err, &batchErr)
+	require.Equal(t, 2, batchErr.Index)
+	require.ErrorIs(t, err, service.ErrAlreadyExists)
+	require.Zero(t, store.Count())
+
+	require.NoError(t, store.SaveAll(context.Background(), laptops[:2]))
+	require.Equal(t, 2, store.Count())
+}
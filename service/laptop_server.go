@@ -5,33 +5,102 @@ import (
 	"context"
 	"errors"
 	"io"
-	"log"
+	"strconv"
+	"strings"
 
+	"github.com/golang/protobuf/ptypes"
 	"github.com/google/uuid"
+	"github.com/pcbook-go/logging"
 	"github.com/pcbook-go/pb"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/metadata"
 )
 
 // LaptopServer é um servidor que provê serviços do laptop
 type LaptopServer struct {
 	pb.UnimplementedLaptopServiceServer
-	laptopStore LaptopStore
-	imageStore  ImageStore
-	ratingStore RatingStore
+	laptopStore      LaptopStore
+	imageStore       ImageStore
+	ratingStore      RatingStore
+	maxImageSize     int
+	laptopEvents     *LaptopEventBus
+	webhooks         *WebhookDispatcher
+	maxImportLaptops int
+	// organizationSharing permite que a organização do dono também modifique os laptops
+	organizationSharing bool
+}
+
+// LaptopServerOption configura uma opção do servidor de laptops
+type LaptopServerOption func(server *LaptopServer)
+
+// WithMaxImageSize define o tamanho máximo, em bytes, das imagens enviadas por UploadImage
+func WithMaxImageSize(maxImageSize int) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.maxImageSize = maxImageSize
+	}
+}
+
+// WithLaptopEvents habilita o WatchLaptops com os eventos do barramento,
+// que deve receber as alterações da loja de laptops por um EventLaptopStore
+func WithLaptopEvents(bus *LaptopEventBus) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.laptopEvents = bus
+	}
+}
+
+// WithWebhooks notifica os webhooks dos laptops criados, dos preços alterados e das imagens enviadas
+func WithWebhooks(dispatcher *WebhookDispatcher) LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.webhooks = dispatcher
+	}
+}
+
+// WithOrganizationSharing permite que os usuários da mesma organização do dono também modifiquem os laptops,
+// que por padrão só podem ser modificados pelo dono ou por um superadmin
+func WithOrganizationSharing() LaptopServerOption {
+	return func(server *LaptopServer) {
+		server.organizationSharing = true
+	}
 }
 
 // NewLaptopServer retorna um novo LaptopServer
-func NewLaptopServer(laptopStore LaptopStore, imageStore ImageStore, ratingStore RatingStore) *LaptopServer {
-	return &LaptopServer{
+func NewLaptopServer(
+	laptopStore LaptopStore,
+	imageStore ImageStore,
+	ratingStore RatingStore,
+	options ...LaptopServerOption,
+) *LaptopServer {
+	server := &LaptopServer{
 		UnimplementedLaptopServiceServer: pb.UnimplementedLaptopServiceServer{},
 		laptopStore:                      laptopStore,
 		imageStore:                       imageStore,
 		ratingStore:                      ratingStore,
+		maxImageSize:                     DefaultMaxImageSize,
+		maxImportLaptops:                 DefaultMaxImportLaptops,
 	}
+
+	for _, option := range options {
+		option(server)
+	}
+
+	return server
 }
 
-const maxImageSize = 1 << 20
+// DefaultMaxImageSize é o tamanho máximo padrão das imagens: 1 MiB
+const DefaultMaxImageSize = 1 << 20
+
+// AllowedImageTypes são as extensões das imagens aceitas pelo UploadImage.
+// A extensão faz parte do caminho do arquivo e define o Content-Type com que a imagem é servida,
+// por isso os outros tipos, como .html ou .svg, são recusados.
+var AllowedImageTypes = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+}
+
+// SuperadminRole pode modificar qualquer laptop, mesmo sem ser o dono
+const SuperadminRole = "superadmin"
 
 // CreateLaptop é um RPC unario para criar um novo Laptop
 func (server *LaptopServer) CreateLaptop(
@@ -39,20 +108,12 @@ func (server *LaptopServer) CreateLaptop(
 	req *pb.CreateLaptopRequest,
 ) (*pb.CreateLaptopResponse, error) {
 	laptop := req.GetLaptop()
-	log.Printf("uma solicitação de criação de um laptop foi recebido: %s", laptop.Id)
+	logger := logging.FromContext(ctx)
+	logger.Debug("create laptop request received", "laptop_id", laptop.GetId())
 
-	if len(laptop.Id) > 0 {
-		// verificando se o id é valido
-		_, err := uuid.Parse(laptop.Id)
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "o ID do laptop não é valido UUID: %v", err)
-		}
-	} else {
-		id, err := uuid.NewRandom()
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "erro ao gerar um novo laptop ID: %v", err)
-		}
-		laptop.Id = id.String()
+	err := prepareNewLaptop(ctx, laptop)
+	if err != nil {
+		return nil, err
 	}
 
 	// algum processamento pesado
@@ -63,17 +124,14 @@ func (server *LaptopServer) CreateLaptop(
 	}
 
 	// salvando o laptop na loja
-	err := server.laptopStore.Save(laptop)
+	err = server.laptopStore.Save(ctx, laptop)
 	if err != nil {
-		code := codes.Internal
-		if errors.Is(err, ErrAlreadyExists) {
-			code = codes.AlreadyExists
-		}
-
-		return nil, status.Errorf(code, "não foi possivel salvar o laptop na loja: %v", err)
+		return nil, laptopStoreError(err, laptop.GetId())
 	}
 
-	log.Printf("o laptop foi salvo de id: %v", laptop.Id)
+	server.notifyWebhooks(&pb.WebhookEvent{Type: WebhookLaptopCreated, Laptop: laptop})
+
+	logger.Info("laptop saved", "laptop_id", laptop.Id)
 
 	res := &pb.CreateLaptopResponse{
 		Id: laptop.Id,
@@ -82,13 +140,96 @@ func (server *LaptopServer) CreateLaptop(
 	return res, nil
 }
 
+// prepareNewLaptop valida um novo laptop e gera um ID se ele está vazio,
+// e define o usuário autenticado como o dono do laptop
+func prepareNewLaptop(ctx context.Context, laptop *pb.Laptop) error {
+	err := ValidateLaptop(laptop)
+	if err != nil {
+		return err
+	}
+
+	if len(laptop.Id) == 0 {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return newError(ReasonInternal, "", "erro ao gerar um novo laptop ID: %v", err)
+		}
+		laptop.Id = id.String()
+	}
+
+	// o dono do laptop é sempre o usuário autenticado, nunca o informado pelo cliente
+	laptop.Owner = ""
+	laptop.Organization = ""
+	if claims, ok := UserClaimsFromContext(ctx); ok {
+		laptop.Owner = claims.Username
+		laptop.Organization = claims.Organization
+	}
+
+	return nil
+}
+
+// UpdateLaptop é um RPC unario para atualizar um laptop existente
+func (server *LaptopServer) UpdateLaptop(
+	ctx context.Context,
+	req *pb.UpdateLaptopRequest,
+) (*pb.UpdateLaptopResponse, error) {
+	laptop := req.GetLaptop()
+	logger := logging.FromContext(ctx)
+	logger.Debug("update laptop request received", "laptop_id", laptop.GetId())
+
+	err := validateLaptopUpdate(laptop)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := server.findLaptop(ctx, laptop.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.checkOwnership(ctx, existing)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// a propriedade do laptop não pode ser transferida por uma atualização
+	laptop.Owner = existing.GetOwner()
+	laptop.Organization = existing.GetOrganization()
+	laptop.UpdatedAt = ptypes.TimestampNow()
+
+	// a atualização falha se o laptop foi atualizado por outra requisição depois de ser lido
+	err = server.laptopStore.Update(ctx, laptop, existing.GetUpdatedAt())
+	if err != nil {
+		return nil, laptopStoreError(err, laptop.GetId())
+	}
+
+	if laptop.GetPriceUsd() != existing.GetPriceUsd() {
+		server.notifyWebhooks(&pb.WebhookEvent{
+			Type:             WebhookLaptopRepriced,
+			Laptop:           laptop,
+			PreviousPriceUsd: existing.GetPriceUsd(),
+		})
+	}
+
+	logger.Info("laptop updated", "laptop_id", laptop.Id)
+
+	res := &pb.UpdateLaptopResponse{
+		Id: laptop.Id,
+	}
+	return res, nil
+}
+
 // SearchLaptop é um RPC de streaming de servidor para procurar laptops
 func (server *LaptopServer) SearchLaptop(
 	req *pb.SearchLaptopRequest,
 	stream pb.LaptopService_SearchLaptopServer,
 ) error {
 	filter := req.GetFilter()
-	log.Printf("receber uma solicitação de pesquisa de laptop com filtro: %v", filter)
+	logger := logging.FromContext(stream.Context())
+	logger.Debug("search laptop request received", "filter", filter.String())
 
 	err := server.laptopStore.Search(
 		stream.Context(),
@@ -100,13 +241,13 @@ func (server *LaptopServer) SearchLaptop(
 				return err
 			}
 
-			log.Printf("enviou laptop com id: %s", laptop.GetId())
+			logger.Debug("laptop sent", "laptop_id", laptop.GetId())
 			return nil
 		},
 	)
 
 	if err != nil {
-		return status.Errorf(codes.Internal, "erro inesperado: %v", err)
+		return laptopStoreError(err, "")
 	}
 
 	return nil
@@ -115,20 +256,27 @@ func (server *LaptopServer) SearchLaptop(
 func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServer) error {
 	req, err := stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "erro ao receber informações da imagem"))
+		return newError(ReasonStreamBroken, "", "erro ao receber informações da imagem: %v", err)
 	}
 
 	laptopID := req.GetInfo().GetLaptopId()
-	imageType := req.GetInfo().GetImageType()
+	imageType := strings.ToLower(req.GetInfo().GetImageType())
+
+	logger := logging.FromContext(stream.Context())
+	logger.Debug("upload image request received", "laptop_id", laptopID, "image_type", imageType)
 
-	log.Printf("solicitação de upload de imagem: %s para o laptop: %s", imageType, laptopID)
+	if !AllowedImageTypes[imageType] {
+		return newError(ReasonInvalidRequest, "", "tipo de imagem não aceito: %q", req.GetInfo().GetImageType())
+	}
 
-	laptop, err := server.laptopStore.Find(laptopID)
+	laptop, err := server.findLaptop(stream.Context(), laptopID)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "erro ao buscar o laptop: %v", err))
+		return err
 	}
-	if laptop == nil {
-		return logError(status.Errorf(codes.InvalidArgument, "laptop id %s não existe", laptopID))
+
+	err = server.checkOwnership(stream.Context(), laptop)
+	if err != nil {
+		return err
 	}
 
 	imageData := bytes.Buffer{}
@@ -139,35 +287,35 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 		if err != nil {
 			return err
 		}
-		log.Print("aguardando para receber mais dados")
+		logger.Debug("waiting to receive more data")
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("não há mais dados")
+			logger.Debug("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "erro ao receber partes dos dados: %v", err))
+			return newError(ReasonStreamBroken, "", "erro ao receber partes dos dados: %v", err)
 		}
 
 		chunk := req.GetChunkData()
 		size := len(chunk)
 
-		log.Printf("recebendo uma parte do total: %d", size)
+		logger.Debug("chunk received", "size", size)
 
 		imageSize += size
-		if imageSize > maxImageSize {
-			return logError(status.Errorf(codes.InvalidArgument, "a imagem é muito grande: %d > %d", imageSize, maxImageSize))
+		if imageSize > server.maxImageSize {
+			return newError(ReasonImageTooLarge, "", "a imagem é muito grande: %d > %d", imageSize, server.maxImageSize)
 		}
 		_, err = imageData.Write(chunk)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "erro ao gravar parte do dado: %v", err))
+			return newError(ReasonInternal, "", "erro ao gravar parte do dado: %v", err)
 		}
 	}
 
-	imageID, err := server.imageStore.Save(laptopID, imageType, imageData)
+	imageID, err := server.imageStore.Save(stream.Context(), laptopID, imageType, imageData)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "erro ao salvar a imagem: %v", err))
+		return newError(ReasonStoreFailure, "", "erro ao salvar a imagem: %v", err)
 	}
 
 	res := &pb.UploadImageResponse{
@@ -177,14 +325,87 @@ func (server *LaptopServer) UploadImage(stream pb.LaptopService_UploadImageServe
 
 	err = stream.SendAndClose(res)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "erro ao enviar resposta: %v", err))
+		return newError(ReasonStreamBroken, "", "erro ao enviar resposta: %v", err)
+	}
+
+	logger.Info("image saved", "laptop_id", laptopID, "image_id", imageID, "size", imageSize)
+
+	server.notifyWebhooks(&pb.WebhookEvent{
+		Type:   WebhookLaptopImageUploaded,
+		Laptop: laptop,
+		Image: &pb.WebhookImage{
+			Id:        imageID,
+			LaptopId:  laptopID,
+			ImageType: imageType,
+			Size:      uint32(imageSize),
+		},
+	})
+	return nil
+}
+
+// imageChunkSize é o tamanho das partes em que as imagens são enviadas por DownloadImage
+const imageChunkSize = 1024
+
+// DownloadImage envia as informações de uma imagem, seguidas dos seus dados em partes
+func (server *LaptopServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.LaptopService_DownloadImageServer) error {
+	imageID := req.GetImageId()
+	logger := logging.FromContext(stream.Context())
+	logger.Debug("download image request received", "image_id", imageID)
+
+	image, data, err := server.imageStore.Load(stream.Context(), imageID)
+	if err != nil {
+		return newError(ReasonStoreFailure, imageID, "erro ao carregar a imagem: %v", err)
+	}
+	if image == nil {
+		return newError(ReasonImageNotFound, imageID, "imagem id %s não existe", imageID)
+	}
+
+	res := &pb.DownloadImageResponse{
+		Data: &pb.DownloadImageResponse_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  image.LaptopID,
+				ImageType: image.Type,
+			},
+		},
+	}
+
+	err = stream.Send(res)
+	if err != nil {
+		return newError(ReasonStreamBroken, "", "erro ao enviar resposta: %v", err)
+	}
+
+	for len(data) > 0 {
+		err := contextError(stream.Context())
+		if err != nil {
+			return err
+		}
+
+		size := imageChunkSize
+		if len(data) < size {
+			size = len(data)
+		}
+
+		res := &pb.DownloadImageResponse{
+			Data: &pb.DownloadImageResponse_ChunkData{
+				ChunkData: data[:size],
+			},
+		}
+
+		err = stream.Send(res)
+		if err != nil {
+			return newError(ReasonStreamBroken, "", "erro ao enviar parte dos dados: %v", err)
+		}
+
+		data = data[size:]
 	}
 
-	log.Printf("imagem salva com o id: %s, size: %d", imageID, imageSize)
+	logger.Debug("image sent", "image_id", imageID, "size", image.Size)
 	return nil
 }
 
 func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	logger := logging.FromContext(stream.Context())
+
 	for {
 		err := contextError(stream.Context())
 		if err != nil {
@@ -193,29 +414,26 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("não há mais dados")
+			logger.Debug("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "erro ao receber a solicitação: %v", err))
+			return newError(ReasonStreamBroken, "", "erro ao receber a solicitação: %v", err)
 		}
 
 		laptopID := req.GetLaptopId()
 		score := req.GetScore()
 
-		log.Printf("solicitação de pontuação = %.2f recebida para o laptop: id = %s", score, laptopID)
+		logger.Debug("rate laptop request received", "laptop_id", laptopID, "score", score)
 
-		found, err := server.laptopStore.Find(laptopID)
+		_, err = server.findLaptop(stream.Context(), laptopID)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "erro ao buscar laptop: %v", err))
-		}
-		if found == nil {
-			return logError(status.Errorf(codes.NotFound, "laptopID: %s não encontrado", laptopID))
+			return err
 		}
 
-		rating, err := server.ratingStore.Add(laptopID, score)
+		rating, err := server.ratingStore.Add(stream.Context(), laptopID, score)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "erro ao armazenar pontuação: %v", err))
+			return newError(ReasonStoreFailure, laptopID, "erro ao armazenar pontuação: %v", err)
 		}
 
 		res := &pb.RateLaptopResponse{
@@ -226,7 +444,7 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 		err = stream.Send(res)
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "erro ao enviar resposta: %v", err))
+			return newError(ReasonStreamBroken, "", "erro ao enviar resposta: %v", err)
 		}
 	}
 
@@ -235,16 +453,11 @@ func (server *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer)
 
 func (server *LaptopServer) FindLaptop(ctx context.Context, req *pb.FindLaptopRequest) (res *pb.SearchLaptopResponse, err error) {
 	idLaptop := req.GetId()
-	log.Printf("receber uma solicitação de pesquisa de laptop com id: %v", idLaptop)
+	logging.FromContext(ctx).Debug("find laptop request received", "laptop_id", idLaptop)
 
-	laptop, err := server.laptopStore.Find(idLaptop)
+	laptop, err := server.findLaptop(ctx, idLaptop)
 	if err != nil {
-		log.Fatal("erro ao buscar o laptop pelo ID: ", err)
-		return
-	}
-	if laptop == nil {
-		log.Printf("o laptop não foi encontrado com esse id: %v", idLaptop)
-		return
+		return nil, err
 	}
 
 	res = &pb.SearchLaptopResponse{
@@ -254,20 +467,191 @@ func (server *LaptopServer) FindLaptop(ctx context.Context, req *pb.FindLaptopRe
 
 }
 
+// DeleteLaptop é um RPC unario para remover um laptop
+func (server *LaptopServer) DeleteLaptop(
+	ctx context.Context,
+	req *pb.DeleteLaptopRequest,
+) (*pb.DeleteLaptopResponse, error) {
+	laptopID := req.GetId()
+	logger := logging.FromContext(ctx)
+	logger.Debug("delete laptop request received", "laptop_id", laptopID)
+
+	laptop, err := server.findLaptop(ctx, laptopID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.checkOwnership(ctx, laptop)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	err = server.laptopStore.Delete(ctx, laptopID)
+	if err != nil {
+		return nil, laptopStoreError(err, laptopID)
+	}
+
+	logger.Info("laptop deleted", "laptop_id", laptopID)
+
+	res := &pb.DeleteLaptopResponse{
+		Id: laptopID,
+	}
+	return res, nil
+}
+
+// WatchSequenceHeader é o cabeçalho do WatchLaptops com o número de sequência do último evento
+// antes dos eventos enviados, para que o observador retome a partir dele se ainda não recebeu nenhum
+const WatchSequenceHeader = "x-watch-sequence"
+
+// WatchEpochHeader é o cabeçalho do WatchLaptops com a época dos números de sequência.
+// O observador que retoma com after_sequence envia de volta a época recebida nos metadados da chamada,
+// e recebe OUT_OF_RANGE se ela é de outro servidor ou de antes de reiniciar, para começar de novo.
+const WatchEpochHeader = "x-watch-epoch"
+
+// WatchLaptops é um RPC de streaming de servidor para acompanhar as alterações dos laptops.
+// Os eventos depois de after_sequence são enviados primeiro, para que o observador reconectado não perca nenhum.
+func (server *LaptopServer) WatchLaptops(
+	req *pb.WatchLaptopsRequest,
+	stream pb.LaptopService_WatchLaptopsServer,
+) error {
+	if server.laptopEvents == nil {
+		return newError(ReasonEventsDisabled, "", "os eventos dos laptops não estão habilitados")
+	}
+
+	ctx := stream.Context()
+	filter := req.GetFilter()
+	logger := logging.FromContext(ctx)
+	logger.Debug("watch laptops request received", "filter", filter.String(), "after_sequence", req.GetAfterSequence())
+
+	if req.GetAfterSequence() > 0 {
+		epoch := metadata.ValueFromIncomingContext(ctx, WatchEpochHeader)
+		if len(epoch) != 1 || epoch[0] != server.laptopEvents.Epoch() {
+			return newError(ReasonEventsExpired, "", "não é possivel retomar depois do evento %d: os eventos são de outra época", req.GetAfterSequence())
+		}
+	}
+
+	subscription, err := server.laptopEvents.Subscribe(req.GetAfterSequence())
+	switch {
+	case errors.Is(err, ErrEventsExpired), errors.Is(err, ErrUnknownSequence):
+		return newError(ReasonEventsExpired, "", "não é possivel retomar depois do evento %d: %v", req.GetAfterSequence(), err)
+	case errors.Is(err, ErrEventBusClosed):
+		return newError(ReasonServerShuttingDown, "", "o servidor está desligando")
+	case err != nil:
+		return newError(ReasonInternal, "", "erro inesperado: %v", err)
+	}
+	defer subscription.Close()
+
+	err = stream.SendHeader(metadata.Pairs(
+		WatchSequenceHeader, strconv.FormatUint(subscription.Sequence(), 10),
+		WatchEpochHeader, server.laptopEvents.Epoch(),
+	))
+	if err != nil {
+		return err
+	}
+
+	lastSequence := subscription.Sequence()
+	send := func(change *LaptopChange) error {
+		event := change.Event
+		lastSequence = event.GetSequence()
+
+		// sem filtro todos os eventos são enviados. A atualização que tira um laptop do filtro
+		// também é enviada, com o laptop que não passa mais nele, para que o observador o descarte.
+		if filter != nil && !isQualified(filter, event.GetLaptop()) &&
+			(change.Previous == nil || !isQualified(filter, change.Previous)) {
+			return nil
+		}
+
+		err := stream.Send(&pb.WatchLaptopsResponse{Event: event})
+		if err != nil {
+			return err
+		}
+
+		logger.Debug("laptop event sent", "sequence", event.GetSequence(), "laptop_id", event.GetLaptop().GetId())
+		return nil
+	}
+
+	for _, change := range subscription.Replay() {
+		err := send(change)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return contextError(ctx)
+		case change, ok := <-subscription.Events():
+			if !ok {
+				if errors.Is(subscription.Err(), ErrSubscriptionDropped) {
+					return newError(ReasonWatcherTooSlow, "", "o observador não acompanhou os eventos, retome depois do evento %d", lastSequence)
+				}
+				return newError(ReasonServerShuttingDown, "", "o servidor está desligando, retome depois do evento %d", lastSequence)
+			}
+
+			err := send(change)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// notifyWebhooks envia o evento aos webhooks inscritos, se eles estão habilitados
+func (server *LaptopServer) notifyWebhooks(event *pb.WebhookEvent) {
+	if server.webhooks != nil {
+		server.webhooks.Notify(event)
+	}
+}
+
+// findLaptop busca um laptop na loja, e retorna o erro do catálogo se ele não existe
+func (server *LaptopServer) findLaptop(ctx context.Context, laptopID string) (*pb.Laptop, error) {
+	laptop, err := server.laptopStore.Find(ctx, laptopID)
+	if err != nil {
+		return nil, laptopStoreError(err, laptopID)
+	}
+	if laptop == nil {
+		return nil, laptopStoreError(ErrNotFound, laptopID)
+	}
+
+	return laptop, nil
+}
+
+// checkOwnership verifica se o usuário autenticado pode modificar o laptop:
+// somente o dono ou um superadmin, e a organização do dono se WithOrganizationSharing está habilitado
+func (server *LaptopServer) checkOwnership(ctx context.Context, laptop *pb.Laptop) error {
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return newError(ReasonLaptopPermissionDenied, laptop.GetId(), "o laptop %s pertence a outro usuário", laptop.GetId())
+	}
+
+	if claims.HasRole(SuperadminRole) {
+		return nil
+	}
+
+	// laptops sem dono foram criados sem autenticação e só podem ser modificados por um superadmin
+	if laptop.GetOwner() != "" && claims.Username == laptop.GetOwner() {
+		return nil
+	}
+
+	if server.organizationSharing && laptop.GetOrganization() != "" && claims.Organization == laptop.GetOrganization() {
+		return nil
+	}
+
+	return newError(ReasonLaptopPermissionDenied, laptop.GetId(), "o laptop %s pertence a outro usuário", laptop.GetId())
+}
+
 func contextError(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
-		return logError(status.Error(codes.Canceled, "requisição cancelada"))
+		return newError(ReasonRequestCanceled, "", "requisição cancelada")
 	case context.DeadlineExceeded:
-		return logError(status.Error(codes.DeadlineExceeded, "o tempo foi esgotado"))
+		return newError(ReasonDeadlineExceeded, "", "o tempo foi esgotado")
 	default:
 		return nil
 	}
 }
-
-func logError(err error) error {
-	if err != nil {
-		log.Print(err)
-	}
-	return err
-}
@@ -3,6 +3,7 @@ package service_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/pcbook-go/pb"
 	"github.com/pcbook-go/sample"
@@ -10,6 +11,8 @@ import (
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestServerCreateLaptop(t *testing.T) {
@@ -23,7 +26,7 @@ func TestServerCreateLaptop(t *testing.T) {
 
 	laptopDuplicateID := sample.NewLaptop()
 	storeDuplicateID := service.NewInMemoryLaptopStore()
-	err := storeDuplicateID.Save(laptopDuplicateID)
+	err := storeDuplicateID.Save(context.Background(), laptopDuplicateID)
 	require.Nil(t, err)
 
 	testCases := []struct {
@@ -87,3 +90,171 @@ func TestServerCreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+func TestServerUpdateLaptopOwnership(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	server := service.NewLaptopServer(store, nil, nil)
+
+	owner := &service.UserClaims{Username: "admin1", Role: "admin", Organization: "pcbook"}
+	ctx := service.ContextWithUserClaims(context.Background(), owner)
+
+	laptop := sample.NewLaptop()
+	laptop.Owner = "forjado"
+	_, err := server.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	saved, err := store.Find(context.Background(), laptop.Id)
+	require.NoError(t, err)
+	require.Equal(t, "admin1", saved.GetOwner())
+	require.Equal(t, "pcbook", saved.GetOrganization())
+
+	testCases := []struct {
+		name   string
+		claims *service.UserClaims
+		code   codes.Code
+	}{
+		{
+			name:   "sucesso, dono",
+			claims: owner,
+			code:   codes.OK,
+		},
+		{
+			name:   "falha, mesma organização",
+			claims: &service.UserClaims{Username: "admin2", Role: "admin", Organization: "pcbook"},
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:   "sucesso, superadmin",
+			claims: &service.UserClaims{Username: "root", Role: service.SuperadminRole},
+			code:   codes.OK,
+		},
+		{
+			name:   "falha, outro usuário",
+			claims: &service.UserClaims{Username: "admin3", Role: "admin", Organization: "outra"},
+			code:   codes.PermissionDenied,
+		},
+		{
+			name: "falha, sem autenticação",
+			code: codes.PermissionDenied,
+		},
+	}
+
+	// as atualizações não são paralelas, elas entrariam em conflito
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.claims != nil {
+				ctx = service.ContextWithUserClaims(ctx, tc.claims)
+			}
+
+			update, err := store.Find(context.Background(), laptop.Id)
+			require.NoError(t, err)
+			update.PriceUsd = 1234
+			update.Owner = "outro"
+
+			_, err = server.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: update})
+			require.Equal(t, tc.code, status.Code(err))
+
+			// a propriedade não muda com a atualização
+			other, err := store.Find(context.Background(), laptop.Id)
+			require.NoError(t, err)
+			require.Equal(t, "admin1", other.GetOwner())
+		})
+	}
+
+	_, err = server.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// interleavedLaptopStore atualiza o laptop antes de cada atualização,
+// como outra requisição que chegasse entre a leitura e a atualização do laptop
+type interleavedLaptopStore struct {
+	service.LaptopStore
+}
+
+func (store *interleavedLaptopStore) Update(ctx context.Context, laptop *pb.Laptop, updatedAt *timestamppb.Timestamp) error {
+	other, err := store.Find(ctx, laptop.GetId())
+	if err != nil {
+		return err
+	}
+
+	other.UpdatedAt = timestamppb.New(laptop.GetUpdatedAt().AsTime().Add(-time.Second))
+	err = store.LaptopStore.Update(ctx, other, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	return store.LaptopStore.Update(ctx, laptop, updatedAt)
+}
+
+func TestServerUpdateLaptopConflict(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	server := service.NewLaptopServer(&interleavedLaptopStore{LaptopStore: store}, nil, nil)
+	ctx := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin"})
+
+	laptop := sample.NewLaptop()
+	_, err := server.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	update := proto.Clone(laptop).(*pb.Laptop)
+	update.PriceUsd = 1234
+	_, err = server.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: update})
+	requireCatalogError(t, err, service.ReasonLaptopConflict)
+
+	// a outra atualização é mantida
+	saved, err := store.Find(context.Background(), laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, laptop.GetPriceUsd(), saved.GetPriceUsd())
+
+	// uma atualização com o updated_at atual é aceita
+	require.NoError(t, store.Update(context.Background(), update, saved.GetUpdatedAt()))
+	require.ErrorIs(t, store.Update(context.Background(), update, laptop.GetUpdatedAt()), service.ErrConflict)
+}
+
+func TestServerUpdateLaptopOrganizationSharing(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryLaptopStore()
+	server := service.NewLaptopServer(store, nil, nil, service.WithOrganizationSharing())
+
+	owner := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin", Organization: "pcbook"})
+	colleague := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin2", Role: "admin", Organization: "pcbook"})
+	stranger := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin3", Role: "admin", Organization: "outra"})
+
+	laptop := sample.NewLaptop()
+	_, err := server.CreateLaptop(owner, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	_, err = server.UpdateLaptop(colleague, &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	_, err = server.UpdateLaptop(stranger, &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestServerUpdateLaptopWithoutOwner(t *testing.T) {
+	t.Parallel()
+
+	// laptops sem dono só podem ser modificados por um superadmin
+	store := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, store.Save(context.Background(), laptop))
+
+	server := service.NewLaptopServer(store, nil, nil)
+
+	_, err := server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	admin := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin"})
+	_, err = server.DeleteLaptop(admin, &pb.DeleteLaptopRequest{Id: laptop.GetId()})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	superadmin := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "root", Role: service.SuperadminRole})
+	_, err = server.DeleteLaptop(superadmin, &pb.DeleteLaptopRequest{Id: laptop.GetId()})
+	require.NoError(t, err)
+}
@@ -4,23 +4,54 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
 	"sync"
 
 	"github.com/jinzhu/copier"
+	"github.com/pcbook-go/logging"
 	"github.com/pcbook-go/pb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var ErrAlreadyExists = errors.New("registro ja existe")
 
+var ErrNotFound = errors.New("registro não encontrado")
+
+// ErrConflict é retornado quando o registro foi alterado ao mesmo tempo por outra requisição
+var ErrConflict = errors.New("registro alterado por outra requisição")
+
+// LaptopBatchError é o erro do laptop que impediu o SaveAll
+type LaptopBatchError struct {
+	Index int
+	Err   error
+}
+
+func (err *LaptopBatchError) Error() string {
+	return fmt.Sprintf("laptop %d: %v", err.Index, err.Err)
+}
+
+func (err *LaptopBatchError) Unwrap() error {
+	return err.Err
+}
+
 // LaptopStore é uma interface da loja do laptop
 type LaptopStore interface {
 	// Save salva um laptop na loja
-	Save(laptop *pb.Laptop) error
+	Save(ctx context.Context, laptop *pb.Laptop) error
+	// SaveAll salva vários laptops de uma vez: se um deles não pode ser salvo, nenhum é salvo
+	// e o erro é um *LaptopBatchError com a posição dele
+	SaveAll(ctx context.Context, laptops []*pb.Laptop) error
 	// Find busca um laptop pelo ID na loja
-	Find(id string) (*pb.Laptop, error)
+	Find(ctx context.Context, id string) (*pb.Laptop, error)
+	// Update substitui um laptop existente na loja, se ele ainda tem o updated_at lido antes da atualização.
+	// Retorna ErrConflict se ele foi atualizado por outra requisição desde então.
+	Update(ctx context.Context, laptop *pb.Laptop, updatedAt *timestamppb.Timestamp) error
+	// Delete remove um laptop da loja, retorna ErrNotFound se ele não existe
+	Delete(ctx context.Context, id string) error
 	// Search procura por laptops com filtro, retorna um a um através da função found
-	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop)error) error
+	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
+	// Count retorna o número de laptops na loja
+	Count() int
 }
 
 // InMemoryLaptopStore salva o laptop em memoria
@@ -37,7 +68,7 @@ func NewInMemoryLaptopStore() *InMemoryLaptopStore {
 }
 
 // Save salva o laptop para a loja
-func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
+func (store *InMemoryLaptopStore) Save(ctx context.Context, laptop *pb.Laptop) error {
 	store.mutex.Lock()
 	defer store.mutex.Unlock()
 
@@ -55,8 +86,36 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	return nil
 }
 
+// SaveAll salva os laptops na loja, ou nenhum deles se algum ID já existe
+func (store *InMemoryLaptopStore) SaveAll(ctx context.Context, laptops []*pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	others := make([]*pb.Laptop, len(laptops))
+	ids := make(map[string]bool, len(laptops))
+
+	for i, laptop := range laptops {
+		if store.data[laptop.Id] != nil || ids[laptop.Id] {
+			return &LaptopBatchError{Index: i, Err: ErrAlreadyExists}
+		}
+		ids[laptop.Id] = true
+
+		// copia profunda
+		other, err := deepCopy(laptop)
+		if err != nil {
+			return &LaptopBatchError{Index: i, Err: err}
+		}
+		others[i] = other
+	}
+
+	for _, other := range others {
+		store.data[other.Id] = other
+	}
+	return nil
+}
+
 // Find busca um laptop pelo ID
-func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
+func (store *InMemoryLaptopStore) Find(ctx context.Context, id string) (*pb.Laptop, error) {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
@@ -74,13 +133,58 @@ func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	return other, nil
 }
 
-func (store *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop)error) error {
+// Update substitui um laptop existente na loja, se ele não foi atualizado depois de updatedAt
+func (store *InMemoryLaptopStore) Update(ctx context.Context, laptop *pb.Laptop, updatedAt *timestamppb.Timestamp) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	existing := store.data[laptop.Id]
+	if existing == nil {
+		return ErrNotFound
+	}
+
+	if !proto.Equal(existing.GetUpdatedAt(), updatedAt) {
+		return ErrConflict
+	}
+
+	// copia profunda
+	other, err := deepCopy(laptop)
+	if err != nil {
+		return err
+	}
+
+	store.data[other.Id] = other
+	return nil
+}
+
+// Delete remove um laptop da loja
+func (store *InMemoryLaptopStore) Delete(ctx context.Context, id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.data[id] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.data, id)
+	return nil
+}
+
+// Count retorna o número de laptops na loja
+func (store *InMemoryLaptopStore) Count() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.data)
+}
+
+func (store *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
 	for _, laptop := range store.data {
 		if ctx.Err() == context.Canceled || ctx.Err() == context.DeadlineExceeded {
-			log.Print("context está cancelado")
+			logging.FromContext(ctx).Debug("search context is canceled")
 		}
 
 		if isQualified(filter, laptop) {
@@ -89,7 +193,7 @@ func (store *InMemoryLaptopStore) Search(ctx context.Context, filter *pb.Filter,
 				return err
 			}
 			err = found(other)
-			if err != nil{
+			if err != nil {
 				return err
 			}
 		}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pcbook-go/pb"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// minReleaseYear é o primeiro ano de lançamento aceito para um laptop
+const minReleaseYear = 1970
+
+// laptopValidator junta as violações encontradas em um laptop, com o caminho de cada campo
+type laptopValidator struct {
+	violations []*errdetails.BadRequest_FieldViolation
+}
+
+// ValidateLaptop verifica todos os campos do laptop e das mensagens dentro dele.
+// Todas as violações são retornadas em um único erro LAPTOP_INVALID, com um detalhe BadRequest.
+// O ID é opcional, porque é gerado na criação de um laptop sem ID.
+func ValidateLaptop(laptop *pb.Laptop) error {
+	validator := &laptopValidator{}
+	validator.laptop("laptop", laptop)
+	return validator.err()
+}
+
+// validateLaptopUpdate verifica um laptop atualizado, que ao contrário de um novo laptop precisa do ID
+func validateLaptopUpdate(laptop *pb.Laptop) error {
+	validator := &laptopValidator{}
+	validator.laptop("laptop", laptop)
+	if laptop != nil {
+		validator.required("laptop.id", laptop.GetId())
+	}
+	return validator.err()
+}
+
+func (validator *laptopValidator) add(field string, format string, args ...interface{}) {
+	validator.violations = append(validator.violations, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// err retorna as violações encontradas em um erro InvalidArgument, ou nil se não há nenhuma
+func (validator *laptopValidator) err() error {
+	if len(validator.violations) == 0 {
+		return nil
+	}
+
+	messages := make([]string, len(validator.violations))
+	for i, violation := range validator.violations {
+		messages[i] = violation.GetField() + ": " + violation.GetDescription()
+	}
+
+	st := newErrorStatus(ReasonLaptopInvalid, "", "laptop inválido: %s", strings.Join(messages, "; "))
+	detailed, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: validator.violations})
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+func (validator *laptopValidator) laptop(field string, laptop *pb.Laptop) {
+	if laptop == nil {
+		validator.add(field, "é obrigatório")
+		return
+	}
+
+	if laptop.GetId() != "" {
+		if _, err := uuid.Parse(laptop.GetId()); err != nil {
+			validator.add(field+".id", "não é um UUID válido: %v", err)
+		}
+	}
+
+	validator.required(field+".brand", laptop.GetBrand())
+	validator.required(field+".name", laptop.GetName())
+	validator.cpu(field+".cpu", laptop.GetCpu())
+	validator.memory(field+".ram", laptop.GetRam())
+
+	for i, gpu := range laptop.GetGpus() {
+		validator.gpu(fmt.Sprintf("%s.gpus[%d]", field, i), gpu)
+	}
+
+	for i, storage := range laptop.GetStorages() {
+		validator.storage(fmt.Sprintf("%s.storages[%d]", field, i), storage)
+	}
+
+	validator.screen(field+".screen", laptop.GetScreen())
+
+	if keyboard := laptop.GetKeyboard(); keyboard != nil {
+		validator.enum(field+".keyboard.layout", keyboard.GetLayout(), false)
+	}
+
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		validator.positive(field+".weight_kg", weight.WeightKg)
+	case *pb.Laptop_WeightLb:
+		validator.positive(field+".weight_lb", weight.WeightLb)
+	}
+
+	price := laptop.GetPriceUsd()
+	if math.IsNaN(price) || math.IsInf(price, 0) || price < 0 {
+		validator.add(field+".price_usd", "deve ser maior ou igual a zero")
+	}
+
+	if year := laptop.GetReleaseYear(); year != 0 && year < minReleaseYear {
+		validator.add(field+".release_year", "deve ser a partir de %d", minReleaseYear)
+	}
+}
+
+func (validator *laptopValidator) cpu(field string, cpu *pb.CPU) {
+	if cpu == nil {
+		validator.add(field, "é obrigatório")
+		return
+	}
+
+	validator.required(field+".brand", cpu.GetBrand())
+	validator.required(field+".name", cpu.GetName())
+
+	if cpu.GetNumberCores() == 0 {
+		validator.add(field+".number_cores", "deve ser maior que zero")
+	}
+	if cpu.GetNumberThreads() < cpu.GetNumberCores() {
+		validator.add(field+".number_threads", "deve ser maior ou igual a number_cores (%d)", cpu.GetNumberCores())
+	}
+
+	validator.frequency(field, cpu.GetMinGhz(), cpu.GetMaxGhz())
+}
+
+func (validator *laptopValidator) gpu(field string, gpu *pb.GPU) {
+	if gpu == nil {
+		validator.add(field, "é obrigatório")
+		return
+	}
+
+	validator.required(field+".brand", gpu.GetBrand())
+	validator.required(field+".name", gpu.GetName())
+	validator.frequency(field, gpu.GetMinGhz(), gpu.GetMaxGhz())
+	validator.memory(field+".memory", gpu.GetMemory())
+}
+
+func (validator *laptopValidator) storage(field string, storage *pb.Storage) {
+	if storage == nil {
+		validator.add(field, "é obrigatório")
+		return
+	}
+
+	validator.enum(field+".driver", storage.GetDriver(), true)
+	validator.memory(field+".memory", storage.GetMemory())
+}
+
+func (validator *laptopValidator) screen(field string, screen *pb.Screen) {
+	if screen == nil {
+		validator.add(field, "é obrigatório")
+		return
+	}
+
+	validator.positive(field+".size_inch", float64(screen.GetSizeInch()))
+
+	resolution := screen.GetResolution()
+	if resolution == nil {
+		validator.add(field+".resolution", "é obrigatório")
+	} else {
+		if resolution.GetWidth() == 0 {
+			validator.add(field+".resolution.width", "deve ser maior que zero")
+		}
+		if resolution.GetHeight() == 0 {
+			validator.add(field+".resolution.height", "deve ser maior que zero")
+		}
+	}
+
+	validator.enum(field+".panel", screen.GetPanel(), false)
+}
+
+func (validator *laptopValidator) memory(field string, memory *pb.Memory) {
+	if memory == nil {
+		validator.add(field, "é obrigatório")
+		return
+	}
+
+	if memory.GetValue() == 0 {
+		validator.add(field+".value", "deve ser maior que zero")
+	}
+
+	// sem a unidade não é possível comparar a memória nas buscas
+	validator.enum(field+".unit", memory.GetUnit(), true)
+}
+
+// frequency verifica as frequências mínima e máxima de um processador
+func (validator *laptopValidator) frequency(field string, minGhz float64, maxGhz float64) {
+	validator.positive(field+".min_ghz", minGhz)
+	if !(maxGhz >= minGhz) {
+		validator.add(field+".max_ghz", "deve ser maior ou igual a min_ghz (%g)", minGhz)
+	}
+}
+
+// positive verifica que o valor é um número finito maior que zero
+func (validator *laptopValidator) positive(field string, value float64) {
+	if !(value > 0) || math.IsInf(value, 0) {
+		validator.add(field, "deve ser maior que zero")
+	}
+}
+
+func (validator *laptopValidator) required(field string, value string) {
+	if strings.TrimSpace(value) == "" {
+		validator.add(field, "é obrigatório")
+	}
+}
+
+// enum verifica que o valor existe no enum, e que não é o valor zero (UNKNOWN) se o campo é obrigatório
+func (validator *laptopValidator) enum(field string, value protoreflect.Enum, required bool) {
+	number := value.Number()
+	if value.Descriptor().Values().ByNumber(number) == nil {
+		validator.add(field, "valor desconhecido %d", number)
+		return
+	}
+
+	if required && number == 0 {
+		validator.add(field, "é obrigatório")
+	}
+}
@@ -0,0 +1,199 @@
+package service_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// requireFieldViolations verifica que o erro é um InvalidArgument com as violações dos campos
+func requireFieldViolations(t *testing.T, err error, fields ...string) {
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	reason, ok := service.ErrorReasonOf(err)
+	require.True(t, ok)
+	require.Equal(t, service.ReasonLaptopInvalid, reason)
+
+	var badRequest *errdetails.BadRequest
+	for _, detail := range st.Details() {
+		if detail, ok := detail.(*errdetails.BadRequest); ok {
+			badRequest = detail
+		}
+	}
+	require.NotNil(t, badRequest)
+
+	violated := make([]string, len(badRequest.GetFieldViolations()))
+	for i, violation := range badRequest.GetFieldViolations() {
+		violated[i] = violation.GetField()
+		require.NotEmpty(t, violation.GetDescription())
+	}
+	require.ElementsMatch(t, fields, violated)
+}
+
+func TestValidateLaptop(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		change func(laptop *pb.Laptop)
+		fields []string
+	}{
+		{
+			name:   "laptop valido",
+			change: func(laptop *pb.Laptop) {},
+		},
+		{
+			name:   "laptop sem ID",
+			change: func(laptop *pb.Laptop) { laptop.Id = "" },
+		},
+		{
+			name: "laptop sem peso nem teclado",
+			change: func(laptop *pb.Laptop) {
+				laptop.Weight = nil
+				laptop.Keyboard = nil
+			},
+		},
+		{
+			name:   "ID invalido",
+			change: func(laptop *pb.Laptop) { laptop.Id = "invalid" },
+			fields: []string{"laptop.id"},
+		},
+		{
+			name:   "sem CPU",
+			change: func(laptop *pb.Laptop) { laptop.Cpu = nil },
+			fields: []string{"laptop.cpu"},
+		},
+		{
+			name: "CPU sem nucleos e com frequencias invertidas",
+			change: func(laptop *pb.Laptop) {
+				laptop.Cpu.NumberCores = 0
+				laptop.Cpu.MinGhz = 3
+				laptop.Cpu.MaxGhz = 2
+			},
+			fields: []string{"laptop.cpu.number_cores", "laptop.cpu.max_ghz"},
+		},
+		{
+			name: "CPU com menos threads que nucleos",
+			change: func(laptop *pb.Laptop) {
+				laptop.Cpu.NumberCores = 8
+				laptop.Cpu.NumberThreads = 4
+			},
+			fields: []string{"laptop.cpu.number_threads"},
+		},
+		{
+			name: "memoria com unidade desconhecida",
+			change: func(laptop *pb.Laptop) {
+				laptop.Ram.Unit = pb.Memory_UNKNOWN
+				laptop.Storages[1].Memory.Unit = pb.Memory_Unit(42)
+			},
+			fields: []string{"laptop.ram.unit", "laptop.storages[1].memory.unit"},
+		},
+		{
+			name: "GPU e disco invalidos",
+			change: func(laptop *pb.Laptop) {
+				laptop.Gpus[0].Memory = nil
+				laptop.Gpus[0].MinGhz = math.NaN()
+				laptop.Storages[0].Driver = pb.Storage_UNKNOWN
+			},
+			fields: []string{"laptop.gpus[0].memory", "laptop.gpus[0].min_ghz", "laptop.gpus[0].max_ghz", "laptop.storages[0].driver"},
+		},
+		{
+			name: "tela e teclado invalidos",
+			change: func(laptop *pb.Laptop) {
+				laptop.Screen.SizeInch = 0
+				laptop.Screen.Resolution = nil
+				laptop.Keyboard.Layout = pb.Keyboard_Layout(9)
+			},
+			fields: []string{"laptop.screen.size_inch", "laptop.screen.resolution", "laptop.keyboard.layout"},
+		},
+		{
+			name: "preco negativo e peso zero",
+			change: func(laptop *pb.Laptop) {
+				laptop.PriceUsd = -1
+				laptop.Weight = &pb.Laptop_WeightLb{WeightLb: 0}
+				laptop.Brand = " "
+			},
+			fields: []string{"laptop.price_usd", "laptop.weight_lb", "laptop.brand"},
+		},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := sample.NewLaptop()
+			tc.change(laptop)
+
+			err := service.ValidateLaptop(laptop)
+			if len(tc.fields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			requireFieldViolations(t, err, tc.fields...)
+		})
+	}
+
+	requireFieldViolations(t, service.ValidateLaptop(nil), "laptop")
+}
+
+func TestServerValidateLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	server := service.NewLaptopServer(laptopStore, nil, nil)
+
+	// o laptop inválido não é criado
+	laptop := sample.NewLaptop()
+	laptop.Cpu.NumberCores = 0
+	laptop.PriceUsd = -100
+	_, err := server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	requireFieldViolations(t, err, "laptop.cpu.number_cores", "laptop.price_usd")
+	require.Zero(t, laptopStore.Count())
+
+	laptop = sample.NewLaptop()
+	_, err = server.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	// a atualização também é validada, e precisa do ID
+	laptop.Ram = nil
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptop})
+	requireFieldViolations(t, err, "laptop.ram")
+
+	laptop = sample.NewLaptop()
+	laptop.Id = ""
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{Laptop: laptop})
+	requireFieldViolations(t, err, "laptop.id")
+
+	_, err = server.UpdateLaptop(context.Background(), &pb.UpdateLaptopRequest{})
+	requireFieldViolations(t, err, "laptop")
+}
+
+func TestClientImportLaptopsValidation(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	serverAddress := startTestLaptopServer(t, laptopStore, nil, nil)
+	laptopClient := newTestLaptopClient(t, serverAddress)
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	laptops[1].Screen = nil
+
+	// o laptop inválido é reportado com o campo da violação
+	res := importLaptops(t, laptopClient, pb.ImportOptions_BEST_EFFORT, laptops)
+	require.EqualValues(t, 2, res.GetImported())
+	requireImportErrors(t, res, map[uint32]codes.Code{1: codes.InvalidArgument})
+	require.Contains(t, res.GetErrors()[0].GetMessage(), "laptop.screen")
+	require.Equal(t, 2, laptopStore.Count())
+}
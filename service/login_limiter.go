@@ -0,0 +1,114 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// LoginLimiter tracks failed login attempts by key (username or peer address).
+// Each failure blocks the key for an exponentially growing delay and, after
+// maxFailures consecutive failures, the key is locked out for lockoutDuration.
+type LoginLimiter struct {
+	mutex           sync.Mutex
+	maxFailures     int
+	baseDelay       time.Duration
+	maxDelay        time.Duration
+	lockoutDuration time.Duration
+	attempts        map[string]*loginAttempts
+}
+
+type loginAttempts struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// NewLoginLimiter returns a new LoginLimiter
+func NewLoginLimiter(maxFailures int, baseDelay, maxDelay, lockoutDuration time.Duration) *LoginLimiter {
+	return &LoginLimiter{
+		maxFailures:     maxFailures,
+		baseDelay:       baseDelay,
+		maxDelay:        maxDelay,
+		lockoutDuration: lockoutDuration,
+		attempts:        make(map[string]*loginAttempts),
+	}
+}
+
+// Check returns how long the caller must wait before trying to login with any of the keys
+func (limiter *LoginLimiter) Check(keys ...string) time.Duration {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		if attempts == nil {
+			continue
+		}
+
+		if remaining := attempts.blockedUntil.Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+
+	return wait
+}
+
+// Failure records a failed login attempt for all the keys
+func (limiter *LoginLimiter) Failure(keys ...string) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	now := time.Now()
+	limiter.prune(now)
+
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		if attempts == nil {
+			attempts = &loginAttempts{}
+			limiter.attempts[key] = attempts
+		}
+
+		attempts.failures++
+		attempts.lastFailure = now
+
+		if attempts.failures >= limiter.maxFailures {
+			attempts.blockedUntil = now.Add(limiter.lockoutDuration)
+			continue
+		}
+
+		delay := limiter.baseDelay << (attempts.failures - 1)
+		if delay > limiter.maxDelay || delay <= 0 {
+			delay = limiter.maxDelay
+		}
+		attempts.blockedUntil = now.Add(delay)
+	}
+}
+
+// Reset forgets the failed attempts of the keys, after a successful login or an admin unlock.
+// It returns true if any of the keys had failed attempts.
+func (limiter *LoginLimiter) Reset(keys ...string) bool {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	found := false
+	for _, key := range keys {
+		if limiter.attempts[key] != nil {
+			found = true
+			delete(limiter.attempts, key)
+		}
+	}
+
+	return found
+}
+
+// prune forgets keys that are not blocked and did not fail for a whole lockout duration
+func (limiter *LoginLimiter) prune(now time.Time) {
+	for key, attempts := range limiter.attempts {
+		if now.After(attempts.blockedUntil) && now.Sub(attempts.lastFailure) > limiter.lockoutDuration {
+			delete(limiter.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher is the hashing policy used to store user passwords
+type PasswordHasher interface {
+	// Hash hashes the password with the current parameters
+	Hash(password string) (string, error)
+	// NeedsRehash checks if the hash uses another algorithm or weaker parameters than the current ones
+	NeedsRehash(hashedPassword string) bool
+}
+
+// DefaultPasswordHasher is the hashing policy used by NewUser
+var DefaultPasswordHasher PasswordHasher = &BcryptHasher{Cost: bcrypt.DefaultCost}
+
+// BcryptHasher hashes passwords with bcrypt
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash hashes the password with bcrypt
+func (hasher *BcryptHasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), hasher.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashedPassword), nil
+}
+
+// NeedsRehash checks if the hash is not a bcrypt hash or has a lower cost
+func (hasher *BcryptHasher) NeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	if err != nil {
+		return true
+	}
+
+	return cost < hasher.Cost
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Argon2idHasher hashes passwords with argon2id, encoded in the PHC string format
+type Argon2idHasher struct {
+	Time       uint32
+	Memory     uint32
+	Threads    uint8
+	KeyLength  uint32
+	SaltLength uint32
+}
+
+// NewArgon2idHasher returns an Argon2idHasher with the parameters recommended by RFC 9106
+// for memory-constrained environments
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Time:       3,
+		Memory:     64 * 1024,
+		Threads:    4,
+		KeyLength:  32,
+		SaltLength: 16,
+	}
+}
+
+// Hash hashes the password with argon2id and a random salt
+func (hasher *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, hasher.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate salt: %w", err)
+	}
+
+	params := argon2idParams{
+		time:    hasher.Time,
+		memory:  hasher.Memory,
+		threads: hasher.Threads,
+		salt:    salt,
+	}
+	key := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, hasher.KeyLength)
+
+	return params.encode(key), nil
+}
+
+// NeedsRehash checks if the hash is not an argon2id hash or has weaker parameters
+func (hasher *Argon2idHasher) NeedsRehash(hashedPassword string) bool {
+	params, key, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		return true
+	}
+
+	return params.time < hasher.Time ||
+		params.memory < hasher.Memory ||
+		params.threads < hasher.Threads ||
+		uint32(len(key)) < hasher.KeyLength ||
+		uint32(len(params.salt)) < hasher.SaltLength
+}
+
+type argon2idParams struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	salt    []byte
+}
+
+func (params argon2idParams) encode(key []byte) string {
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		params.memory,
+		params.time,
+		params.threads,
+		base64.RawStdEncoding.EncodeToString(params.salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+}
+
+func parseArgon2id(hashedPassword string) (argon2idParams, []byte, error) {
+	var params argon2idParams
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, fmt.Errorf("not an argon2id hash")
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads)
+	if err != nil {
+		return params, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	params.salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, fmt.Errorf("invalid argon2id key")
+	}
+
+	return params, key, nil
+}
+
+// verifyPassword checks the password against a bcrypt or argon2id hash,
+// whatever the current hashing policy is
+func verifyPassword(hashedPassword string, password string) bool {
+	if !strings.HasPrefix(hashedPassword, argon2idPrefix) {
+		err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+		return err == nil
+	}
+
+	params, key, err := parseArgon2id(hashedPassword)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
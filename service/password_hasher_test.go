@@ -0,0 +1,86 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestArgon2idHasher() *service.Argon2idHasher {
+	return &service.Argon2idHasher{Time: 1, Memory: 1024, Threads: 1, KeyLength: 32, SaltLength: 16}
+}
+
+func TestPasswordHasher(t *testing.T) {
+	t.Parallel()
+
+	bcryptHasher := &service.BcryptHasher{Cost: bcrypt.MinCost}
+	argon2idHasher := newTestArgon2idHasher()
+
+	testCases := []struct {
+		name   string
+		hasher service.PasswordHasher
+	}{
+		{name: "bcrypt", hasher: bcryptHasher},
+		{name: "argon2id", hasher: argon2idHasher},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			user, err := service.NewUserWithHasher("user1", "secret", "user", tc.hasher)
+			require.NoError(t, err)
+			require.True(t, user.IsCorrectPassword("secret"))
+			require.False(t, user.IsCorrectPassword("wrong"))
+			require.False(t, tc.hasher.NeedsRehash(user.HashedPassword))
+		})
+	}
+
+	// um hash de outro algoritmo ou com parâmetros mais fracos precisa ser refeito
+	bcryptHash, err := bcryptHasher.Hash("secret")
+	require.NoError(t, err)
+	require.True(t, argon2idHasher.NeedsRehash(bcryptHash))
+	require.True(t, (&service.BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(bcryptHash))
+
+	argon2idHash, err := argon2idHasher.Hash("secret")
+	require.NoError(t, err)
+	require.True(t, bcryptHasher.NeedsRehash(argon2idHash))
+
+	stronger := newTestArgon2idHasher()
+	stronger.Memory *= 2
+	require.True(t, stronger.NeedsRehash(argon2idHash))
+}
+
+func TestServerLoginUpgradesPasswordHash(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	user, err := service.NewUserWithHasher("user1", "secret", "user", &service.BcryptHasher{Cost: bcrypt.MinCost})
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(user))
+
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+
+	hasher := newTestArgon2idHasher()
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	server := service.NewAuthServer(userStore, jwtManager, service.WithPasswordHasher(hasher))
+
+	_, err = server.Login(context.Background(), &pb.LoginRequest{Username: "user1", Password: "secret"})
+	require.NoError(t, err)
+
+	upgraded, err := userStore.Find("user1")
+	require.NoError(t, err)
+	require.NotEqual(t, user.HashedPassword, upgraded.HashedPassword)
+	require.False(t, hasher.NeedsRehash(upgraded.HashedPassword))
+
+	// a senha continua válida com o novo hash
+	_, err = server.Login(context.Background(), &pb.LoginRequest{Username: "user1", Password: "secret"})
+	require.NoError(t, err)
+}
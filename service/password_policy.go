@@ -0,0 +1,139 @@
+package service
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is returned when a password does not comply with the password policy
+var ErrWeakPassword = errors.New("password does not comply with the policy")
+
+// commonPasswords is a small built-in deny-list, extended with PasswordPolicy.LoadDenyListFile
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567890", "password", "password1", "password123",
+	"qwerty", "qwerty123", "abc123", "111111", "123123", "iloveyou", "admin", "admin123",
+	"letmein", "welcome", "welcome1", "monkey", "dragon", "football", "baseball", "sunshine",
+	"princess", "passw0rd", "p@ssw0rd", "p@ssword", "changeme", "secret", "trustno1",
+}
+
+// PasswordPolicy defines the passwords accepted when creating or changing a user password
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+
+	// denyList holds lowercase passwords that are too common to be accepted
+	denyList map[string]bool
+}
+
+// DefaultPasswordPolicy returns a policy requiring 12 characters with mixed case and digits,
+// rejecting the built-in common passwords
+func DefaultPasswordPolicy() *PasswordPolicy {
+	policy := &PasswordPolicy{
+		MinLength:    12,
+		MaxLength:    128,
+		RequireUpper: true,
+		RequireLower: true,
+		RequireDigit: true,
+	}
+
+	policy.Deny(commonPasswords...)
+	return policy
+}
+
+// Deny adds passwords to the deny-list, which is case-insensitive
+func (policy *PasswordPolicy) Deny(passwords ...string) {
+	if policy.denyList == nil {
+		policy.denyList = make(map[string]bool)
+	}
+
+	for _, password := range passwords {
+		policy.denyList[strings.ToLower(password)] = true
+	}
+}
+
+// LoadDenyListFile adds the passwords of a file, one per line, to the deny-list
+func (policy *PasswordPolicy) LoadDenyListFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("cannot open deny-list file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		password := strings.TrimSpace(scanner.Text())
+		if password != "" && !strings.HasPrefix(password, "#") {
+			policy.Deny(password)
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return fmt.Errorf("cannot read deny-list file: %w", err)
+	}
+
+	return nil
+}
+
+// Validate checks the password of the user against the policy.
+// The returned error wraps ErrWeakPassword and lists every violated rule.
+func (policy *PasswordPolicy) Validate(username string, password string) error {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if length < policy.MinLength || length == 0 {
+		violations = append(violations, fmt.Sprintf("must have at least %d characters", policy.MinLength))
+	}
+	if policy.MaxLength > 0 && length > policy.MaxLength {
+		violations = append(violations, fmt.Sprintf("must have at most %d characters", policy.MaxLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if policy.RequireUpper && !hasUpper {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if policy.RequireLower && !hasLower {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		violations = append(violations, "must contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if username != "" && strings.EqualFold(password, username) {
+		violations = append(violations, "must not be the username")
+	}
+	if policy.denyList[strings.ToLower(password)] {
+		violations = append(violations, "is too common")
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%w: password %s", ErrWeakPassword, strings.Join(violations, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy := service.DefaultPasswordPolicy()
+	policy.Deny("Pcbook-Laptop1", "Password1234")
+
+	testCases := []struct {
+		name     string
+		username string
+		password string
+		valid    bool
+	}{
+		{name: "valid", username: "user1", password: "Correct-Horse1", valid: true},
+		{name: "empty", username: "user1", password: ""},
+		{name: "short", username: "user1", password: "Short1"},
+		{name: "no_upper", username: "user1", password: "correct-horse1"},
+		{name: "no_lower", username: "user1", password: "CORRECT-HORSE1"},
+		{name: "no_digit", username: "user1", password: "Correct-Horse"},
+		{name: "username", username: "Longusername1", password: "longUsername1"},
+		{name: "common", username: "user1", password: "Password1234"},
+		{name: "deny_list", username: "user1", password: "pcbook-laptop1"},
+	}
+
+	for i := range testCases {
+		tc := testCases[i]
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := policy.Validate(tc.username, tc.password)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, service.ErrWeakPassword)
+			}
+		})
+	}
+}
+
+func TestServerPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	for _, role := range []string{"user", "admin", "superadmin"} {
+		user, err := service.NewUser(role+"1", "Old-Password1", role)
+		require.NoError(t, err)
+		require.NoError(t, userStore.Save(user))
+	}
+
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	interceptor := service.NewAuthInterceptor(jwtManager, nil, policy)
+	server := service.NewAuthServer(
+		userStore,
+		jwtManager,
+		service.WithPasswordReset(service.NewInMemoryPasswordResetStore(), time.Minute, interceptor),
+	)
+
+	userCtx := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "user1", Role: "user"})
+	adminCtx := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin"})
+
+	stolen, err := server.Login(context.Background(), &pb.LoginRequest{Username: "user1", Password: "Old-Password1"})
+	require.NoError(t, err)
+
+	// troca da própria senha
+	_, err = server.ChangePassword(userCtx, &pb.ChangePasswordRequest{OldPassword: "wrong", NewPassword: "New-Password1"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = server.ChangePassword(userCtx, &pb.ChangePasswordRequest{OldPassword: "Old-Password1", NewPassword: "weak"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.ChangePassword(userCtx, &pb.ChangePasswordRequest{OldPassword: "Old-Password1", NewPassword: "New-Password1"})
+	require.NoError(t, err)
+
+	// a troca da senha encerra as sessões abertas com a senha antiga
+	_, err = server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: stolen.GetRefreshToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	stolen, err = server.Login(context.Background(), &pb.LoginRequest{Username: "user1", Password: "New-Password1"})
+	require.NoError(t, err)
+
+	// um admin não pode tomar a conta de um superadmin
+	_, err = server.CreatePasswordResetToken(adminCtx, &pb.CreatePasswordResetTokenRequest{Username: "superadmin1"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = server.CreatePasswordResetToken(adminCtx, &pb.CreatePasswordResetTokenRequest{Username: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	res, err := server.CreatePasswordResetToken(adminCtx, &pb.CreatePasswordResetTokenRequest{Username: "user1"})
+	require.NoError(t, err)
+	require.NotEmpty(t, res.GetResetToken())
+	require.True(t, res.GetExpiresAt().AsTime().After(time.Now()))
+
+	// uma senha fraca não consome o token
+	_, err = server.ResetPassword(context.Background(), &pb.ResetPasswordRequest{ResetToken: res.GetResetToken(), NewPassword: "user1"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.ResetPassword(context.Background(), &pb.ResetPasswordRequest{ResetToken: res.GetResetToken(), NewPassword: "Reset-Password1"})
+	require.NoError(t, err)
+
+	// o token só pode ser usado uma vez
+	_, err = server.ResetPassword(context.Background(), &pb.ResetPasswordRequest{ResetToken: res.GetResetToken(), NewPassword: "Other-Password1"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// assim como a redefinição da senha
+	_, err = server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: stolen.GetRefreshToken()})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	login, err := server.Login(context.Background(), &pb.LoginRequest{Username: "user1", Password: "Reset-Password1"})
+	require.NoError(t, err)
+
+	_, err = server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: login.GetRefreshToken()})
+	require.NoError(t, err)
+}
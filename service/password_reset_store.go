@@ -0,0 +1,115 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PasswordResetToken is a one-time token allowing a user to choose a new password.
+// Only the SHA-256 hash of the token is stored.
+type PasswordResetToken struct {
+	HashedToken string
+	Username    string
+	CreatedBy   string
+	ExpiresAt   time.Time
+}
+
+// NewPasswordResetToken generates a reset token for the user, valid for the given duration.
+// It returns the token and the secret to give to the user, which cannot be recovered later.
+func NewPasswordResetToken(username string, createdBy string, duration time.Duration) (*PasswordResetToken, string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot generate reset token: %w", err)
+	}
+
+	plainToken := base64.RawURLEncoding.EncodeToString(secret)
+	token := &PasswordResetToken{
+		HashedToken: HashPasswordResetToken(plainToken),
+		Username:    username,
+		CreatedBy:   createdBy,
+		ExpiresAt:   time.Now().Add(duration),
+	}
+
+	return token, plainToken, nil
+}
+
+// HashPasswordResetToken returns the hash under which a plain reset token is stored
+func HashPasswordResetToken(plainToken string) string {
+	sum := sha256.Sum256([]byte(plainToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsExpired checks if the token can no longer be redeemed
+func (token *PasswordResetToken) IsExpired() bool {
+	return !time.Now().Before(token.ExpiresAt)
+}
+
+// PasswordResetStore is an interface to store password reset tokens
+type PasswordResetStore interface {
+	// Save saves a new token, replacing any previous token of the same user
+	Save(token *PasswordResetToken) error
+	// Find finds a token by its hash
+	Find(hashedToken string) (*PasswordResetToken, error)
+	// Delete deletes a token, it returns ErrNotFound if the token has already been deleted
+	Delete(hashedToken string) error
+}
+
+// InMemoryPasswordResetStore stores password reset tokens in memory
+type InMemoryPasswordResetStore struct {
+	mutex  sync.Mutex
+	tokens map[string]*PasswordResetToken
+}
+
+// NewInMemoryPasswordResetStore returns a new InMemoryPasswordResetStore
+func NewInMemoryPasswordResetStore() *InMemoryPasswordResetStore {
+	return &InMemoryPasswordResetStore{
+		tokens: make(map[string]*PasswordResetToken),
+	}
+}
+
+func (store *InMemoryPasswordResetStore) Save(token *PasswordResetToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for hashedToken, other := range store.tokens {
+		// a new token invalidates the previous ones of the user, expired ones are forgotten
+		if other.Username == token.Username || other.IsExpired() {
+			delete(store.tokens, hashedToken)
+		}
+	}
+
+	other := *token
+	store.tokens[token.HashedToken] = &other
+	return nil
+}
+
+func (store *InMemoryPasswordResetStore) Find(hashedToken string) (*PasswordResetToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token := store.tokens[hashedToken]
+	if token == nil {
+		return nil, nil
+	}
+
+	other := *token
+	return &other, nil
+}
+
+func (store *InMemoryPasswordResetStore) Delete(hashedToken string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.tokens[hashedToken] == nil {
+		return ErrNotFound
+	}
+
+	delete(store.tokens, hashedToken)
+	return nil
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy is a role-based access control policy for the gRPC methods
+type Policy struct {
+	// DefaultDeny rejects methods that are not listed in the policy,
+	// otherwise they are accessible without authentication
+	DefaultDeny bool                    `yaml:"default_deny"`
+	Roles       map[string]RolePolicy   `yaml:"roles"`
+	Methods     map[string]MethodPolicy `yaml:"methods"`
+
+	// Certificates maps the subject common name of mutual TLS client certificates to a user
+	Certificates map[string]CertificateIdentity `yaml:"certificates"`
+
+	// RequireMFA lists the roles that must login with a second factor,
+	// which also applies to the roles inheriting from them
+	RequireMFA []string `yaml:"require_mfa"`
+
+	// grants maps each role to all the roles it holds, including inherited ones
+	grants map[string]map[string]bool
+}
+
+// RolePolicy defines a role and the roles it inherits permissions from
+type RolePolicy struct {
+	Inherits []string `yaml:"inherits"`
+}
+
+// MethodPolicy defines who can call a method. The method name may be a full
+// method like "/pcbook.LaptopService/CreateLaptop" or a whole service like "/pcbook.LaptopService/*"
+type MethodPolicy struct {
+	Public bool     `yaml:"public"`
+	Roles  []string `yaml:"roles"`
+
+	// AllowWithoutMFA lets users required to have a second factor call the method
+	// before they have one, e.g. to enroll
+	AllowWithoutMFA bool `yaml:"allow_without_mfa"`
+}
+
+// CertificateIdentity is the user a client certificate authenticates as
+type CertificateIdentity struct {
+	Username     string `yaml:"username"`
+	Role         string `yaml:"role"`
+	Organization string `yaml:"organization"`
+}
+
+// LoadPolicyFile loads a policy from a YAML or JSON file
+func LoadPolicyFile(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy file: %w", err)
+	}
+
+	return ParsePolicy(data)
+}
+
+// ParsePolicy parses a policy from YAML or JSON data
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := &Policy{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(policy)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse policy: %w", err)
+	}
+
+	err = policy.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// compile validates the policy and resolves the role inheritance
+func (policy *Policy) compile() error {
+	policy.grants = make(map[string]map[string]bool)
+
+	for role := range policy.Roles {
+		granted := make(map[string]bool)
+		err := policy.resolve(role, granted, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+
+		policy.grants[role] = granted
+	}
+
+	for method, rule := range policy.Methods {
+		if !strings.HasPrefix(method, "/") {
+			return fmt.Errorf("invalid method name %q: must start with /", method)
+		}
+
+		if rule.Public && len(rule.Roles) > 0 {
+			return fmt.Errorf("method %s cannot be public and restricted to roles", method)
+		}
+
+		for _, role := range rule.Roles {
+			if _, ok := policy.Roles[role]; !ok {
+				return fmt.Errorf("method %s references unknown role %q", method, role)
+			}
+		}
+	}
+
+	for _, role := range policy.RequireMFA {
+		if _, ok := policy.Roles[role]; !ok {
+			return fmt.Errorf("require_mfa references unknown role %q", role)
+		}
+	}
+
+	for subject, identity := range policy.Certificates {
+		if identity.Username == "" {
+			return fmt.Errorf("certificate %q must map to a username", subject)
+		}
+
+		if _, ok := policy.Roles[identity.Role]; !ok {
+			return fmt.Errorf("certificate %q references unknown role %q", subject, identity.Role)
+		}
+	}
+
+	return nil
+}
+
+func (policy *Policy) resolve(role string, granted map[string]bool, visiting map[string]bool) error {
+	if visiting[role] {
+		return fmt.Errorf("role %q inherits from itself", role)
+	}
+
+	rolePolicy, ok := policy.Roles[role]
+	if !ok {
+		return fmt.Errorf("unknown inherited role %q", role)
+	}
+
+	visiting[role] = true
+	defer delete(visiting, role)
+
+	granted[role] = true
+	for _, parent := range rolePolicy.Inherits {
+		err := policy.resolve(parent, granted, visiting)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lookup finds the rule for a full method name, falling back to the service wildcard
+func (policy *Policy) lookup(method string) (MethodPolicy, bool) {
+	rule, ok := policy.Methods[method]
+	if ok {
+		return rule, true
+	}
+
+	i := strings.LastIndex(method, "/")
+	if i < 0 {
+		return MethodPolicy{}, false
+	}
+
+	rule, ok = policy.Methods[method[:i+1]+"*"]
+	return rule, ok
+}
+
+// IsPublic checks if the method can be called without authentication
+func (policy *Policy) IsPublic(method string) bool {
+	rule, ok := policy.lookup(method)
+	if !ok {
+		return !policy.DefaultDeny
+	}
+
+	return rule.Public
+}
+
+// IsAllowed checks if the method is listed in the policy, or unlisted methods are allowed
+func (policy *Policy) IsAllowed(method string) bool {
+	_, ok := policy.lookup(method)
+	return ok || !policy.DefaultDeny
+}
+
+// HasAccess checks if a user with any of the given roles can call the method
+func (policy *Policy) HasAccess(method string, roles ...string) bool {
+	rule, ok := policy.lookup(method)
+	if !ok {
+		return !policy.DefaultDeny
+	}
+
+	if rule.Public {
+		return true
+	}
+
+	for _, role := range roles {
+		for _, allowed := range rule.Roles {
+			if policy.Grants(role, allowed) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RequiresMFA checks if a user with any of the given roles must login with a second factor
+// to call the method
+func (policy *Policy) RequiresMFA(method string, roles ...string) bool {
+	rule, ok := policy.lookup(method)
+	if ok && rule.AllowWithoutMFA {
+		return false
+	}
+
+	for _, role := range roles {
+		for _, required := range policy.RequireMFA {
+			if policy.Grants(role, required) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// HasRole checks if the role is defined by the policy
+func (policy *Policy) HasRole(role string) bool {
+	_, ok := policy.Roles[role]
+	return ok
+}
+
+// Grants checks if the role is the granted role or inherits from it
+func (policy *Policy) Grants(role string, granted string) bool {
+	return policy.grants[role][granted]
+}
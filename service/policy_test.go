@@ -0,0 +1,117 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	policy, err := service.ParsePolicy([]byte(`
+default_deny: true
+roles:
+  user: {}
+  admin:
+    inherits: [user]
+methods:
+  /pcbook.AuthService/*:
+    public: true
+  /pcbook.LaptopService/CreateLaptop:
+    roles: [admin]
+  /pcbook.LaptopService/RateLaptop:
+    roles: [user]
+`))
+	require.NoError(t, err)
+
+	require.True(t, policy.IsPublic("/pcbook.AuthService/Login"))
+	require.False(t, policy.IsPublic("/pcbook.LaptopService/CreateLaptop"))
+
+	require.True(t, policy.HasAccess("/pcbook.LaptopService/CreateLaptop", "admin"))
+	require.False(t, policy.HasAccess("/pcbook.LaptopService/CreateLaptop", "user"))
+	require.True(t, policy.HasAccess("/pcbook.LaptopService/RateLaptop", "admin"))
+	require.True(t, policy.HasAccess("/pcbook.LaptopService/RateLaptop", "user"))
+
+	// métodos que não estão listados são negados
+	require.False(t, policy.IsAllowed("/pcbook.LaptopService/SearchLaptop"))
+	require.False(t, policy.HasAccess("/pcbook.LaptopService/SearchLaptop", "admin"))
+}
+
+func TestPolicyRequireMFA(t *testing.T) {
+	t.Parallel()
+
+	policy, err := service.ParsePolicy([]byte(`
+default_deny: true
+roles:
+  user: {}
+  admin:
+    inherits: [user]
+  superadmin:
+    inherits: [admin]
+methods:
+  /pcbook.AuthService/EnrollTOTP:
+    roles: [user]
+    allow_without_mfa: true
+  /pcbook.LaptopService/UploadImage:
+    roles: [admin]
+  /pcbook.LaptopService/RateLaptop:
+    roles: [user]
+require_mfa: [admin]
+`))
+	require.NoError(t, err)
+
+	// o segundo fator é exigido dos admins e de quem herda deles, exceto para o cadastro
+	require.True(t, policy.RequiresMFA("/pcbook.LaptopService/UploadImage", "admin"))
+	require.True(t, policy.RequiresMFA("/pcbook.LaptopService/RateLaptop", service.SuperadminRole))
+	require.False(t, policy.RequiresMFA("/pcbook.LaptopService/RateLaptop", "user"))
+	require.False(t, policy.RequiresMFA("/pcbook.AuthService/EnrollTOTP", "admin"))
+}
+
+func TestPolicyInvalid(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"herança circular": `
+roles:
+  a: {inherits: [b]}
+  b: {inherits: [a]}
+`,
+		"papel desconhecido": `
+roles:
+  user: {}
+methods:
+  /pcbook.LaptopService/CreateLaptop:
+    roles: [admin]
+`,
+		"campo desconhecido": `
+deny_by_default: true
+`,
+	}
+
+	for name, data := range testCases {
+		data := data
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := service.ParsePolicy([]byte(data))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestPolicyFile(t *testing.T) {
+	t.Parallel()
+
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+	require.True(t, policy.HasAccess("/pcbook.LaptopService/UploadImage", "admin"))
+	require.False(t, policy.HasAccess("/pcbook.LaptopService/UploadImage", "user"))
+
+	// os usuários de demonstração não têm TOTP, o exemplo não exige o segundo fator
+	require.False(t, policy.RequiresMFA("/pcbook.LaptopService/UploadImage", "admin"))
+
+	// os balanceadores de carga verificam a saúde sem autenticação
+	require.True(t, policy.IsPublic("/grpc.health.v1.Health/Check"))
+}
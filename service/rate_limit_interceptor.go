@@ -0,0 +1,274 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimitConfig defines how many requests each caller can send, keyed by username or peer address
+type RateLimitConfig struct {
+	// Default applies to the methods that are not listed in Methods
+	Default RateLimit `yaml:"default"`
+	// Methods overrides the limit of a full method like "/pcbook.LaptopService/SearchLaptop"
+	// or a whole service like "/pcbook.LaptopService/*". For streams, the limit applies to opening them.
+	Methods map[string]RateLimit `yaml:"methods"`
+	// MaxConcurrentStreams caps the streams a caller can have open at the same time, 0 means no cap
+	MaxConcurrentStreams int `yaml:"max_concurrent_streams"`
+}
+
+// RateLimit is a token bucket refilled with Rate tokens per second, holding at most Burst tokens.
+// A zero Rate means no limit.
+type RateLimit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// LoadRateLimitFile loads a rate limit configuration from a YAML or JSON file
+func LoadRateLimitFile(filename string) (*RateLimitConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read rate limit file: %w", err)
+	}
+
+	return ParseRateLimitConfig(data)
+}
+
+// ParseRateLimitConfig parses a rate limit configuration from YAML or JSON data
+func ParseRateLimitConfig(data []byte) (*RateLimitConfig, error) {
+	config := &RateLimitConfig{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	err := decoder.Decode(config)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse rate limit config: %w", err)
+	}
+
+	err = config.Default.validate("default")
+	if err != nil {
+		return nil, err
+	}
+
+	for method, limit := range config.Methods {
+		if !strings.HasPrefix(method, "/") {
+			return nil, fmt.Errorf("invalid method name %q: must start with /", method)
+		}
+
+		err = limit.validate(method)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.MaxConcurrentStreams < 0 {
+		return nil, fmt.Errorf("max_concurrent_streams cannot be negative")
+	}
+
+	return config, nil
+}
+
+func (limit RateLimit) validate(name string) error {
+	if limit.Rate < 0 {
+		return fmt.Errorf("rate limit of %s cannot be negative", name)
+	}
+
+	if limit.Rate > 0 && limit.Burst < 1 {
+		return fmt.Errorf("rate limit of %s must have a burst of at least 1", name)
+	}
+
+	return nil
+}
+
+// limit finds the limit of a full method name, falling back to the service wildcard and the default
+func (config *RateLimitConfig) limit(method string) RateLimit {
+	limit, ok := config.Methods[method]
+	if ok {
+		return limit
+	}
+
+	i := strings.LastIndex(method, "/")
+	if i >= 0 {
+		limit, ok = config.Methods[method[:i+1]+"*"]
+		if ok {
+			return limit
+		}
+	}
+
+	return config.Default
+}
+
+// bucketIdleTimeout is how long a bucket is kept after its last use, it is full again by then in practice
+const bucketIdleTimeout = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimitInterceptor is a server interceptor limiting the requests and concurrent streams of each caller.
+// It must be chained after the AuthInterceptor, to find the username of authenticated callers.
+type RateLimitInterceptor struct {
+	mutex     sync.Mutex
+	config    *RateLimitConfig
+	buckets   map[string]*tokenBucket
+	streams   map[string]int
+	lastPrune time.Time
+}
+
+// NewRateLimitInterceptor returns a new rate limit interceptor
+func NewRateLimitInterceptor(config *RateLimitConfig) *RateLimitInterceptor {
+	return &RateLimitInterceptor{
+		config:    config,
+		buckets:   make(map[string]*tokenBucket),
+		streams:   make(map[string]int),
+		lastPrune: time.Now(),
+	}
+}
+
+func (interceptor *RateLimitInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		wait := interceptor.take(info.FullMethod, rateLimitKey(ctx))
+		if wait > 0 {
+			_ = grpc.SetTrailer(ctx, retryAfterMetadata(wait))
+			return nil, retryLaterError(wait, "rate limit exceeded for %s", info.FullMethod)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+func (interceptor *RateLimitInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		key := rateLimitKey(stream.Context())
+
+		wait := interceptor.take(info.FullMethod, key)
+		if wait > 0 {
+			stream.SetTrailer(retryAfterMetadata(wait))
+			return retryLaterError(wait, "rate limit exceeded for %s", info.FullMethod)
+		}
+
+		if !interceptor.openStream(key) {
+			return status.Errorf(codes.ResourceExhausted, "too many concurrent streams")
+		}
+		defer interceptor.closeStream(key)
+
+		return handler(srv, stream)
+	}
+}
+
+// take takes a token from the bucket of the caller for the method,
+// or returns how long the caller must wait for the next token
+func (interceptor *RateLimitInterceptor) take(method string, key string) time.Duration {
+	limit := interceptor.config.limit(method)
+	if limit.Rate == 0 {
+		return 0
+	}
+
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	now := time.Now()
+	interceptor.prune(now)
+
+	bucketKey := method + " " + key
+	bucket := interceptor.buckets[bucketKey]
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), lastSeen: now}
+		interceptor.buckets[bucketKey] = bucket
+	}
+
+	elapsed := now.Sub(bucket.lastSeen).Seconds()
+	bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return 0
+}
+
+// prune forgets the buckets that have not been used for a while, at most once per idle timeout
+func (interceptor *RateLimitInterceptor) prune(now time.Time) {
+	if now.Sub(interceptor.lastPrune) < bucketIdleTimeout {
+		return
+	}
+
+	for key, bucket := range interceptor.buckets {
+		if now.Sub(bucket.lastSeen) > bucketIdleTimeout {
+			delete(interceptor.buckets, key)
+		}
+	}
+
+	interceptor.lastPrune = now
+}
+
+func (interceptor *RateLimitInterceptor) openStream(key string) bool {
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	max := interceptor.config.MaxConcurrentStreams
+	if max > 0 && interceptor.streams[key] >= max {
+		return false
+	}
+
+	interceptor.streams[key]++
+	return true
+}
+
+func (interceptor *RateLimitInterceptor) closeStream(key string) {
+	interceptor.mutex.Lock()
+	defer interceptor.mutex.Unlock()
+
+	interceptor.streams[key]--
+	if interceptor.streams[key] <= 0 {
+		delete(interceptor.streams, key)
+	}
+}
+
+// rateLimitKey identifies the caller by username, or by peer address for public methods
+func rateLimitKey(ctx context.Context) string {
+	claims, ok := UserClaimsFromContext(ctx)
+	if ok {
+		return usernameLimiterKey(claims.Username)
+	}
+
+	p, ok := peer.FromContext(ctx)
+	if ok && p.Addr != nil {
+		return peerLimiterKey(p.Addr.String())
+	}
+
+	return "unknown"
+}
+
+// retryAfterMetadata returns the retry-after trailer, in seconds
+func retryAfterMetadata(wait time.Duration) metadata.MD {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	return metadata.Pairs("retry-after", strconv.FormatInt(seconds, 10))
+}
@@ -0,0 +1,148 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *testServerStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *testServerStream) SetTrailer(md metadata.MD) {}
+
+func TestRateLimitInterceptor(t *testing.T) {
+	t.Parallel()
+
+	config, err := service.ParseRateLimitConfig([]byte(`
+default:
+  rate: 0.001
+  burst: 2
+methods:
+  /pcbook.LaptopService/FindLaptop:
+    rate: 0
+`))
+	require.NoError(t, err)
+
+	interceptor := service.NewRateLimitInterceptor(config)
+	unary := interceptor.Unary()
+
+	user1 := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "user1"})
+	user2 := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "user2"})
+
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	call := func(ctx context.Context, method string) error {
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return err
+	}
+
+	search := "/pcbook.LaptopService/SearchLaptop"
+	require.NoError(t, call(user1, search))
+	require.NoError(t, call(user1, search))
+
+	// o burst acabou para o user1, mas não para os outros usuários e métodos
+	err = call(user1, search)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	st, _ := status.FromError(err)
+	require.Len(t, st.Details(), 1)
+
+	require.NoError(t, call(user2, search))
+	require.NoError(t, call(user1, "/pcbook.LaptopService/CreateLaptop"))
+
+	// um rate 0 deixa o método sem limite
+	for i := 0; i < 10; i++ {
+		require.NoError(t, call(user1, "/pcbook.LaptopService/FindLaptop"))
+	}
+}
+
+func TestRateLimitInterceptorConcurrentStreams(t *testing.T) {
+	t.Parallel()
+
+	config, err := service.ParseRateLimitConfig([]byte(`
+max_concurrent_streams: 1
+`))
+	require.NoError(t, err)
+
+	stream := service.NewRateLimitInterceptor(config).Stream()
+	ctx := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "user1"})
+	info := &grpc.StreamServerInfo{FullMethod: "/pcbook.LaptopService/RateLaptop"}
+
+	opened := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+
+	go func() {
+		done <- stream(nil, &testServerStream{ctx: ctx}, info, func(srv interface{}, stream grpc.ServerStream) error {
+			close(opened)
+			<-release
+			return nil
+		})
+	}()
+	<-opened
+
+	noop := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+
+	// o segundo stream do mesmo usuário é recusado enquanto o primeiro está aberto
+	err = stream(nil, &testServerStream{ctx: ctx}, info, noop)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	close(release)
+	require.NoError(t, <-done)
+
+	require.NoError(t, stream(nil, &testServerStream{ctx: ctx}, info, noop))
+}
+
+func TestRateLimitConfigInvalid(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]string{
+		"sem burst": `
+default:
+  rate: 1
+`,
+		"rate negativo": `
+methods:
+  /pcbook.LaptopService/SearchLaptop:
+    rate: -1
+    burst: 1
+`,
+		"método inválido": `
+methods:
+  SearchLaptop:
+    rate: 1
+    burst: 1
+`,
+		"campo desconhecido": `
+max_streams: 1
+`,
+	}
+
+	for name, data := range testCases {
+		data := data
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := service.ParseRateLimitConfig([]byte(data))
+			require.Error(t, err)
+		})
+	}
+
+	_, err := service.LoadRateLimitFile("../rate_limits.yaml")
+	require.NoError(t, err)
+}
@@ -1,9 +1,14 @@
 package service
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 type RatingStore interface {
-	Add(laptopID string, score float64) (*Rating, error)
+	Add(ctx context.Context, laptopID string, score float64) (*Rating, error)
+	// Count retorna o número total de avaliações de todos os laptops
+	Count() uint64
 }
 
 type Rating struct {
@@ -22,7 +27,7 @@ func NewInMemoryRatingStore() *InMemoryRatingStore {
     }
 }
 
-func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
+func (store *InMemoryRatingStore) Add(ctx context.Context, laptopID string, score float64) (*Rating, error) {
     store.mutex.Lock()
     defer store.mutex.Unlock()
 
@@ -39,4 +44,17 @@ func (store *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating,
 
     store.rating[laptopID] = rating
     return rating, nil
-}
\ No newline at end of file
+}
+
+// Count retorna o número total de avaliações de todos os laptops
+func (store *InMemoryRatingStore) Count() uint64 {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	var count uint64
+	for _, rating := range store.rating {
+		count += uint64(rating.Count)
+	}
+
+	return count
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrTokenRevoked is returned when revoking a token that has already been revoked
+var ErrTokenRevoked = errors.New("token has already been revoked")
+
+// RevocationStore is an interface to store revoked token IDs
+type RevocationStore interface {
+	// Revoke marks the token ID as revoked until it expires.
+	// It returns ErrTokenRevoked if the token ID is already revoked, so that
+	// of concurrent calls revoking a single-use token only one succeeds.
+	Revoke(tokenID string, expiresAt time.Time) error
+	// IsRevoked checks if the token ID has been revoked
+	IsRevoked(tokenID string) (bool, error)
+}
+
+// InMemoryRevocationStore stores revoked token IDs in memory
+type InMemoryRevocationStore struct {
+	mutex   sync.RWMutex
+	revoked map[string]time.Time
+	// expirations orders the revoked token IDs by expiration, so that the expired ones are pruned
+	// from the top without scanning the whole map
+	expirations revocationHeap
+}
+
+// NewInMemoryRevocationStore returns a new InMemoryRevocationStore
+func NewInMemoryRevocationStore() *InMemoryRevocationStore {
+	return &InMemoryRevocationStore{
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// Revoke marks the token ID as revoked until it expires, or returns ErrTokenRevoked if it already is
+func (store *InMemoryRevocationStore) Revoke(tokenID string, expiresAt time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.revoked[tokenID]; ok {
+		return ErrTokenRevoked
+	}
+
+	// expired tokens are rejected anyway, no need to remember them
+	now := time.Now()
+	for len(store.expirations) > 0 && now.After(store.expirations[0].expiresAt) {
+		expired := heap.Pop(&store.expirations).(revocation)
+		delete(store.revoked, expired.tokenID)
+	}
+
+	store.revoked[tokenID] = expiresAt
+	heap.Push(&store.expirations, revocation{tokenID: tokenID, expiresAt: expiresAt})
+	return nil
+}
+
+// IsRevoked checks if the token ID has been revoked
+func (store *InMemoryRevocationStore) IsRevoked(tokenID string) (bool, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	_, ok := store.revoked[tokenID]
+	return ok, nil
+}
+
+type revocation struct {
+	tokenID   string
+	expiresAt time.Time
+}
+
+// revocationHeap is a min-heap of revocations by expiration, used with container/heap
+type revocationHeap []revocation
+
+func (h revocationHeap) Len() int           { return len(h) }
+func (h revocationHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h revocationHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *revocationHeap) Push(x any) {
+	*h = append(*h, x.(revocation))
+}
+
+func (h *revocationHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
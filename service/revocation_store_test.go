@@ -0,0 +1,32 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInMemoryRevocationStore(t *testing.T) {
+	t.Parallel()
+
+	store := service.NewInMemoryRevocationStore()
+	now := time.Now()
+
+	require.NoError(t, store.Revoke("valid", now.Add(time.Hour)))
+	require.NoError(t, store.Revoke("expired", now.Add(-time.Minute)))
+	require.ErrorIs(t, store.Revoke("valid", now.Add(time.Hour)), service.ErrTokenRevoked)
+
+	revoked, err := store.IsRevoked("expired")
+	require.NoError(t, err)
+	require.True(t, revoked)
+
+	// os tokens expirados são esquecidos na próxima revogação, os outros continuam revogados
+	require.NoError(t, store.Revoke("other", now.Add(time.Hour)))
+	for tokenID, expected := range map[string]bool{"valid": true, "other": true, "expired": false} {
+		revoked, err := store.IsRevoked(tokenID)
+		require.NoError(t, err)
+		require.Equal(t, expected, revoked, tokenID)
+	}
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// LoadServerTLSCredentials loads the server certificate and private key.
+// If clientCAFile is not empty, clients must present a certificate signed by it (mutual TLS).
+func LoadServerTLSCredentials(certFile, keyFile, clientCAFile string) (credentials.TransportCredentials, error) {
+	serverCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load server certificate: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.NoClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pemClientCA, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client CA certificate: %w", err)
+		}
+
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(pemClientCA) {
+			return nil, fmt.Errorf("cannot add client CA certificate")
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = certPool
+	}
+
+	return credentials.NewTLS(config), nil
+}
@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/client"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// writeTestCertificate gera um certificado assinado por parent (ou auto-assinado) e grava os arquivos PEM
+func writeTestCertificate(t *testing.T, dir string, name string, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Minute)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+"-cert.pem"), certPEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600))
+
+	return &testCertificate{cert, key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	ca := writeTestCertificate(t, dir, "ca", &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "pcbook-ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	writeTestCertificate(t, dir, "server", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	writeTestCertificate(t, dir, "import-job", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "import-job"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+	writeTestCertificate(t, dir, "unknown", &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "unknown"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+
+	path := func(name string) string {
+		return filepath.Join(dir, name)
+	}
+
+	tlsCredentials, err := service.LoadServerTLSCredentials(path("server-cert.pem"), path("server-key.pem"), path("ca-cert.pem"))
+	require.NoError(t, err)
+
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	interceptor := service.NewAuthInterceptor(jwtManager, nil, policy)
+	laptopStore := service.NewInMemoryLaptopStore()
+
+	grpcServer := grpc.NewServer(
+		grpc.Creds(tlsCredentials),
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	createLaptop := func(certName string) (string, error) {
+		var certFile, keyFile string
+		if certName != "" {
+			certFile, keyFile = path(certName+"-cert.pem"), path(certName+"-key.pem")
+		}
+
+		transportOption, err := client.TransportOption(path("ca-cert.pem"), certFile, keyFile)
+		require.NoError(t, err)
+
+		conn, err := grpc.Dial(listener.Addr().String(), transportOption)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		res, err := pb.NewLaptopServiceClient(conn).CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+		return res.GetId(), err
+	}
+
+	// o CN do certificado é mapeado para um admin pela política
+	id, err := createLaptop("import-job")
+	require.NoError(t, err)
+
+	laptop, err := laptopStore.Find(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, "import-job", laptop.GetOwner())
+
+	_, err = createLaptop("unknown")
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// sem certificado de cliente o handshake falha
+	_, err = createLaptop("")
+	require.Error(t, err)
+}
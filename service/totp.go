@@ -0,0 +1,41 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const recoveryCodeCount = 10
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes generates single-use recovery codes.
+// It returns the codes to give to the user and their hashes to store.
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashedCodes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		data := make([]byte, 5)
+		_, err := rand.Read(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot generate recovery code: %w", err)
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(data))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashedCodes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashedCodes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
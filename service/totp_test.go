@@ -0,0 +1,137 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/service"
+	"github.com/pcbook-go/totp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// loginWithTOTP faz o login em duas etapas de um usuário com o segundo fator habilitado
+func loginWithTOTP(t *testing.T, authClient pb.AuthServiceClient, username string, password string, secret string) string {
+	login, err := authClient.Login(context.Background(), &pb.LoginRequest{Username: username, Password: password})
+	require.NoError(t, err)
+	require.True(t, login.GetMfaRequired())
+	require.Empty(t, login.GetAccessToken())
+
+	code, err := totp.Code(secret, time.Now())
+	require.NoError(t, err)
+
+	res, err := authClient.VerifySecondFactor(context.Background(), &pb.VerifySecondFactorRequest{
+		ChallengeToken: login.GetChallengeToken(),
+		Factor:         &pb.VerifySecondFactorRequest_Code{Code: code},
+	})
+	require.NoError(t, err)
+
+	return res.GetAccessToken()
+}
+
+// loadMFAPolicy retorna a política de exemplo exigindo o segundo fator dos admins
+func loadMFAPolicy(t *testing.T) *service.Policy {
+	data, err := os.ReadFile("../policy.yaml")
+	require.NoError(t, err)
+
+	policy, err := service.ParsePolicy(bytes.Replace(data, []byte("require_mfa: []"), []byte("require_mfa: [admin]"), 1))
+	require.NoError(t, err)
+	require.True(t, policy.RequiresMFA("/pcbook.LaptopService/CreateLaptop", "admin"))
+
+	return policy
+}
+
+func TestTOTPSecondFactor(t *testing.T) {
+	t.Parallel()
+
+	userStore := service.NewInMemoryUserStore()
+	admin, err := service.NewUser("admin1", "secret", "admin")
+	require.NoError(t, err)
+	require.NoError(t, userStore.Save(admin))
+
+	serverAddress := startTestAuthServer(t, userStore, service.NewInMemoryAPIKeyStore(), loadMFAPolicy(t))
+	conn, err := grpc.Dial(serverAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+
+	authClient := pb.NewAuthServiceClient(conn)
+
+	login, err := authClient.Login(context.Background(), &pb.LoginRequest{Username: "admin1", Password: "secret"})
+	require.NoError(t, err)
+	require.False(t, login.GetMfaRequired())
+	adminCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", login.GetAccessToken())
+
+	// a política exige o segundo fator do admin, que só pode se cadastrar
+	_, err = authClient.ListAPIKeys(adminCtx, &pb.ListAPIKeysRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	enroll, err := authClient.EnrollTOTP(adminCtx, &pb.EnrollTOTPRequest{})
+	require.NoError(t, err)
+	require.Contains(t, enroll.GetUri(), "otpauth://totp/pcbook:admin1?")
+
+	_, err = authClient.ConfirmTOTP(adminCtx, &pb.ConfirmTOTPRequest{Code: "000000"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	code, err := totp.Code(enroll.GetSecret(), time.Now())
+	require.NoError(t, err)
+
+	confirm, err := authClient.ConfirmTOTP(adminCtx, &pb.ConfirmTOTPRequest{Code: code})
+	require.NoError(t, err)
+	require.Len(t, confirm.GetRecoveryCodes(), 10)
+
+	// depois de confirmado, o cadastro não pode ser substituído
+	_, err = authClient.EnrollTOTP(adminCtx, &pb.EnrollTOTPRequest{})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// o mesmo código não pode ser usado duas vezes
+	login, err = authClient.Login(context.Background(), &pb.LoginRequest{Username: "admin1", Password: "secret"})
+	require.NoError(t, err)
+	require.True(t, login.GetMfaRequired())
+
+	_, err = authClient.VerifySecondFactor(context.Background(), &pb.VerifySecondFactorRequest{
+		ChallengeToken: login.GetChallengeToken(),
+		Factor:         &pb.VerifySecondFactorRequest_Code{Code: code},
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	recovered, err := authClient.VerifySecondFactor(context.Background(), &pb.VerifySecondFactorRequest{
+		ChallengeToken: login.GetChallengeToken(),
+		Factor:         &pb.VerifySecondFactorRequest_RecoveryCode{RecoveryCode: confirm.GetRecoveryCodes()[0]},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(9), recovered.GetRemainingRecoveryCodes())
+
+	mfaCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", recovered.GetAccessToken())
+	_, err = authClient.ListAPIKeys(mfaCtx, &pb.ListAPIKeysRequest{})
+	require.NoError(t, err)
+
+	// o challenge token e o código de recuperação só podem ser usados uma vez
+	_, err = authClient.VerifySecondFactor(context.Background(), &pb.VerifySecondFactorRequest{
+		ChallengeToken: login.GetChallengeToken(),
+		Factor:         &pb.VerifySecondFactorRequest_RecoveryCode{RecoveryCode: confirm.GetRecoveryCodes()[1]},
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	login, err = authClient.Login(context.Background(), &pb.LoginRequest{Username: "admin1", Password: "secret"})
+	require.NoError(t, err)
+
+	_, err = authClient.VerifySecondFactor(context.Background(), &pb.VerifySecondFactorRequest{
+		ChallengeToken: login.GetChallengeToken(),
+		Factor:         &pb.VerifySecondFactorRequest_RecoveryCode{RecoveryCode: confirm.GetRecoveryCodes()[0]},
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// o refresh token mantém o segundo fator
+	refreshed, err := authClient.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: recovered.GetRefreshToken()})
+	require.NoError(t, err)
+
+	refreshedCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", refreshed.GetAccessToken())
+	_, err = authClient.ListAPIKeys(refreshedCtx, &pb.ListAPIKeysRequest{})
+	require.NoError(t, err)
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pcbook-go/pb"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const tracerName = "github.com/pcbook-go/service"
+
+// TracedLaptopStore registra um span para cada operação de uma loja de laptops
+type TracedLaptopStore struct {
+	store  LaptopStore
+	tracer trace.Tracer
+}
+
+// NewTracedLaptopStore retorna uma loja de laptops que registra os spans das operações da loja
+func NewTracedLaptopStore(store LaptopStore, provider trace.TracerProvider) *TracedLaptopStore {
+	return &TracedLaptopStore{
+		store:  store,
+		tracer: provider.Tracer(tracerName),
+	}
+}
+
+func (traced *TracedLaptopStore) Save(ctx context.Context, laptop *pb.Laptop) error {
+	ctx, span := traced.tracer.Start(ctx, "LaptopStore.Save", trace.WithAttributes(laptopIDAttribute(laptop.GetId())))
+	defer span.End()
+
+	return endStoreSpan(span, traced.store.Save(ctx, laptop))
+}
+
+func (traced *TracedLaptopStore) SaveAll(ctx context.Context, laptops []*pb.Laptop) error {
+	ctx, span := traced.tracer.Start(ctx, "LaptopStore.SaveAll", trace.WithAttributes(attribute.Int("laptop.count", len(laptops))))
+	defer span.End()
+
+	return endStoreSpan(span, traced.store.SaveAll(ctx, laptops))
+}
+
+func (traced *TracedLaptopStore) Find(ctx context.Context, id string) (*pb.Laptop, error) {
+	ctx, span := traced.tracer.Start(ctx, "LaptopStore.Find", trace.WithAttributes(laptopIDAttribute(id)))
+	defer span.End()
+
+	laptop, err := traced.store.Find(ctx, id)
+	span.SetAttributes(attribute.Bool("laptop.found", laptop != nil))
+	return laptop, endStoreSpan(span, err)
+}
+
+func (traced *TracedLaptopStore) Update(ctx context.Context, laptop *pb.Laptop, updatedAt *timestamppb.Timestamp) error {
+	ctx, span := traced.tracer.Start(ctx, "LaptopStore.Update", trace.WithAttributes(laptopIDAttribute(laptop.GetId())))
+	defer span.End()
+
+	return endStoreSpan(span, traced.store.Update(ctx, laptop, updatedAt))
+}
+
+func (traced *TracedLaptopStore) Delete(ctx context.Context, id string) error {
+	ctx, span := traced.tracer.Start(ctx, "LaptopStore.Delete", trace.WithAttributes(laptopIDAttribute(id)))
+	defer span.End()
+
+	return endStoreSpan(span, traced.store.Delete(ctx, id))
+}
+
+// Search registra o número de laptops encontrados, e o tempo gasto no envio de cada um como um evento
+func (traced *TracedLaptopStore) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	ctx, span := traced.tracer.Start(ctx, "LaptopStore.Search")
+	defer span.End()
+
+	count := 0
+	err := traced.store.Search(ctx, filter, func(laptop *pb.Laptop) error {
+		count++
+		span.AddEvent("laptop found", trace.WithAttributes(laptopIDAttribute(laptop.GetId())))
+		return found(laptop)
+	})
+
+	span.SetAttributes(attribute.Int("laptop.count", count))
+	return endStoreSpan(span, err)
+}
+
+func (traced *TracedLaptopStore) Count() int {
+	return traced.store.Count()
+}
+
+// TracedImageStore registra um span para cada imagem salva em uma loja de imagens
+type TracedImageStore struct {
+	store  ImageStore
+	tracer trace.Tracer
+}
+
+// NewTracedImageStore retorna uma loja de imagens que registra os spans das operações da loja
+func NewTracedImageStore(store ImageStore, provider trace.TracerProvider) *TracedImageStore {
+	return &TracedImageStore{
+		store:  store,
+		tracer: provider.Tracer(tracerName),
+	}
+}
+
+func (traced *TracedImageStore) Save(ctx context.Context, laptopID string, imageType string, imageData bytes.Buffer) (string, error) {
+	ctx, span := traced.tracer.Start(ctx, "ImageStore.Save", trace.WithAttributes(
+		laptopIDAttribute(laptopID),
+		attribute.String("image.type", imageType),
+		attribute.Int("image.size", imageData.Len()),
+	))
+	defer span.End()
+
+	imageID, err := traced.store.Save(ctx, laptopID, imageType, imageData)
+	span.SetAttributes(attribute.String("image.id", imageID))
+	return imageID, endStoreSpan(span, err)
+}
+
+func (traced *TracedImageStore) Load(ctx context.Context, imageID string) (*ImageInfo, []byte, error) {
+	ctx, span := traced.tracer.Start(ctx, "ImageStore.Load", trace.WithAttributes(attribute.String("image.id", imageID)))
+	defer span.End()
+
+	image, data, err := traced.store.Load(ctx, imageID)
+	span.SetAttributes(attribute.Bool("image.found", image != nil))
+	return image, data, endStoreSpan(span, err)
+}
+
+func (traced *TracedImageStore) TotalSize() int64 {
+	return traced.store.TotalSize()
+}
+
+// TracedRatingStore registra um span para cada avaliação adicionada a uma loja de avaliações
+type TracedRatingStore struct {
+	store  RatingStore
+	tracer trace.Tracer
+}
+
+// NewTracedRatingStore retorna uma loja de avaliações que registra os spans das operações da loja
+func NewTracedRatingStore(store RatingStore, provider trace.TracerProvider) *TracedRatingStore {
+	return &TracedRatingStore{
+		store:  store,
+		tracer: provider.Tracer(tracerName),
+	}
+}
+
+func (traced *TracedRatingStore) Add(ctx context.Context, laptopID string, score float64) (*Rating, error) {
+	ctx, span := traced.tracer.Start(ctx, "RatingStore.Add", trace.WithAttributes(
+		laptopIDAttribute(laptopID),
+		attribute.Float64("rating.score", score),
+	))
+	defer span.End()
+
+	rating, err := traced.store.Add(ctx, laptopID, score)
+	return rating, endStoreSpan(span, err)
+}
+
+func (traced *TracedRatingStore) Count() uint64 {
+	return traced.store.Count()
+}
+
+func laptopIDAttribute(id string) attribute.KeyValue {
+	return attribute.String("laptop.id", id)
+}
+
+// endStoreSpan marca o span como falho se a operação retornou um erro
+func endStoreSpan(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
@@ -1,26 +1,46 @@
 package service
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"time"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/pcbook-go/totp"
 )
 
 type User struct {
-	Username       string
-	HashedPassword string
-	Role           string
+	Username       string `json:"username"`
+	HashedPassword string `json:"hashed_password"`
+	Role           string `json:"role"`
+	Organization   string `json:"organization,omitempty"`
+
+	// TOTPSecret is set on enrollment, but only required at login once TOTPEnabled is confirmed
+	TOTPSecret      string   `json:"totp_secret,omitempty"`
+	TOTPEnabled     bool     `json:"totp_enabled,omitempty"`
+	TOTPLastCounter int64    `json:"totp_last_counter,omitempty"`
+	RecoveryCodes   []string `json:"recovery_codes,omitempty"`
+
+	// TokenGeneration is incremented when the password changes, invalidating the refresh tokens issued before
+	TokenGeneration int `json:"token_generation,omitempty"`
 }
 
 func NewUser(username string, password string, role string) (*User, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return NewUserWithHasher(username, password, role, DefaultPasswordHasher)
+}
+
+func NewUserWithHasher(username string, password string, role string, hasher PasswordHasher) (*User, error) {
+	if password == "" {
+		return nil, fmt.Errorf("a senha não pode ser vazia")
+	}
+
+	hashedPassword, err := hasher.Hash(password)
 	if err != nil {
 		return nil, fmt.Errorf("erro ao encriptar a senha: %w", err)
 	}
 
 	user := &User{
 		Username:       username,
-		HashedPassword: string(hashedPassword),
+		HashedPassword: hashedPassword,
 		Role:           role,
 	}
 
@@ -28,14 +48,59 @@ func NewUser(username string, password string, role string) (*User, error) {
 }
 
 func (user *User) IsCorrectPassword(password string) bool {
-    err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(password))
-    return err == nil
+	return verifyPassword(user.HashedPassword, password)
+}
+
+// SetPassword replaces the password hash using the given hashing policy
+func (user *User) SetPassword(password string, hasher PasswordHasher) error {
+	if password == "" {
+		return fmt.Errorf("a senha não pode ser vazia")
+	}
+
+	hashedPassword, err := hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("erro ao encriptar a senha: %w", err)
+	}
+
+	user.HashedPassword = hashedPassword
+	return nil
+}
+
+// VerifyTOTP checks a TOTP code and remembers it, so that it cannot be used again
+func (user *User) VerifyTOTP(code string, t time.Time) bool {
+	counter, ok := totp.Validate(user.TOTPSecret, code, t, user.TOTPLastCounter)
+	if !ok {
+		return false
+	}
+
+	user.TOTPLastCounter = counter
+	return true
+}
+
+// UseRecoveryCode checks a recovery code and removes it, since each code can only be used once
+func (user *User) UseRecoveryCode(code string) bool {
+	hashedCode := hashRecoveryCode(code)
+
+	for i, other := range user.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(other), []byte(hashedCode)) == 1 {
+			user.RecoveryCodes = append(user.RecoveryCodes[:i:i], user.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
 }
 
 func (user *User) Clone() *User {
-    return &User{
-        Username:       user.Username,
-        HashedPassword: user.HashedPassword,
-        Role:           user.Role,
-    }
-}
\ No newline at end of file
+	return &User{
+		Username:        user.Username,
+		HashedPassword:  user.HashedPassword,
+		Role:            user.Role,
+		Organization:    user.Organization,
+		TOTPSecret:      user.TOTPSecret,
+		TOTPEnabled:     user.TOTPEnabled,
+		TOTPLastCounter: user.TOTPLastCounter,
+		RecoveryCodes:   append([]string(nil), user.RecoveryCodes...),
+		TokenGeneration: user.TokenGeneration,
+	}
+}
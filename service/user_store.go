@@ -5,6 +5,7 @@ import "sync"
 type UserStore interface {
 	Save(user *User) error
 	Find(username string) (*User, error)
+	Update(user *User) error
 }
 
 type InMemoryUserStore struct {
@@ -19,25 +20,37 @@ func NewInMemoryUserStore() *InMemoryUserStore {
 }
 
 func (store *InMemoryUserStore) Save(user *User) error {
-    store.mutex.Lock()
-    defer store.mutex.Unlock()
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 
-    if store.users[user.Username] != nil {
-        return ErrAlreadyExists
-    }
+	if store.users[user.Username] != nil {
+		return ErrAlreadyExists
+	}
 
-    store.users[user.Username] = user.Clone()
-    return nil
+	store.users[user.Username] = user.Clone()
+	return nil
 }
 
 func (store *InMemoryUserStore) Find(username string) (*User, error) {
-    store.mutex.RLock()
-    defer store.mutex.RUnlock()
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	user := store.users[username]
+	if user == nil {
+		return nil, nil
+	}
+
+	return user.Clone(), nil
+}
+
+func (store *InMemoryUserStore) Update(user *User) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
 
-    user := store.users[username]
-    if user == nil {
-        return nil, nil
-    }
+	if store.users[user.Username] == nil {
+		return ErrNotFound
+	}
 
-    return user.Clone(), nil
-}
\ No newline at end of file
+	store.users[user.Username] = user.Clone()
+	return nil
+}
@@ -0,0 +1,45 @@
+package service_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileUserStore(t *testing.T) {
+	t.Parallel()
+
+	filename := filepath.Join(t.TempDir(), "users.json")
+
+	store, err := service.NewFileUserStore(filename)
+	require.NoError(t, err)
+
+	user, err := service.NewUser("user1", "secret", "user")
+	require.NoError(t, err)
+	user.Organization = "pcbook"
+
+	require.NoError(t, store.Save(user))
+	require.ErrorIs(t, store.Save(user), service.ErrAlreadyExists)
+
+	other, err := service.NewUser("user2", "secret", "user")
+	require.NoError(t, err)
+	require.ErrorIs(t, store.Update(other), service.ErrNotFound)
+
+	user.Role = "admin"
+	require.NoError(t, store.Update(user))
+
+	// os usuários continuam salvos depois de reabrir o arquivo
+	reloaded, err := service.NewFileUserStore(filename)
+	require.NoError(t, err)
+
+	found, err := reloaded.Find("user1")
+	require.NoError(t, err)
+	require.Equal(t, user, found)
+	require.True(t, found.IsCorrectPassword("secret"))
+
+	found, err = reloaded.Find("user2")
+	require.NoError(t, err)
+	require.Nil(t, found)
+}
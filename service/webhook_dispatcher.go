@@ -0,0 +1,455 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/serializer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The headers of the requests posted to the webhooks
+const (
+	WebhookSignatureHeader = "X-Pcbook-Signature"
+	WebhookTimestampHeader = "X-Pcbook-Timestamp"
+	WebhookEventHeader     = "X-Pcbook-Event"
+	WebhookDeliveryHeader  = "X-Pcbook-Delivery"
+)
+
+// SignWebhookPayload returns the signature of a payload posted at the Unix timestamp:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<payload>" keyed with the webhook secret.
+// Signing the timestamp lets the receivers reject replayed requests.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhookSignature checks the signature of a request received by a webhook
+func VerifyWebhookSignature(secret string, timestamp int64, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(SignWebhookPayload(secret, timestamp, payload)), []byte(signature))
+}
+
+// errPermanentDelivery marks the failures that retrying cannot fix, like a 404 of the endpoint
+var errPermanentDelivery = errors.New("permanent delivery failure")
+
+// ErrWebhookDispatcherClosed is returned when redelivering after the dispatcher is closed
+var ErrWebhookDispatcherClosed = errors.New("webhook dispatcher is closed")
+
+// ErrWebhookQueueFull is returned when a delivery cannot wait for a worker.
+// The delivery is recorded as failed, so that it can be redelivered later.
+var ErrWebhookQueueFull = errors.New("webhook delivery queue is full")
+
+// WebhookDispatcherOption configures a WebhookDispatcher
+type WebhookDispatcherOption func(dispatcher *WebhookDispatcher)
+
+// ErrWebhookAddressNotAllowed is returned for the webhooks on loopback, link-local or private addresses
+var ErrWebhookAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// WithWebhookHTTPClient sets the HTTP client posting the payloads, e.g. to trust more CAs.
+// Its redirects are refused, but it is trusted to restrict the addresses it dials.
+func WithWebhookHTTPClient(client *http.Client) WebhookDispatcherOption {
+	return func(dispatcher *WebhookDispatcher) {
+		dispatcher.client = client
+	}
+}
+
+// WithWebhookTimeout bounds each attempt of the deliveries
+func WithWebhookTimeout(timeout time.Duration) WebhookDispatcherOption {
+	return func(dispatcher *WebhookDispatcher) {
+		dispatcher.timeout = timeout
+	}
+}
+
+// WithWebhookPrivateNetworks allows the webhooks on loopback, link-local and private addresses,
+// which are refused by default so that the webhooks cannot reach the internal network of the server
+func WithWebhookPrivateNetworks() WebhookDispatcherOption {
+	return func(dispatcher *WebhookDispatcher) {
+		dispatcher.privateNetworks = true
+	}
+}
+
+// WithWebhookRetries sets the number of attempts of each delivery and the exponential backoff between them
+func WithWebhookRetries(maxAttempts int, initialBackoff time.Duration, maxBackoff time.Duration) WebhookDispatcherOption {
+	return func(dispatcher *WebhookDispatcher) {
+		dispatcher.maxAttempts = maxAttempts
+		dispatcher.initialBackoff = initialBackoff
+		dispatcher.maxBackoff = maxBackoff
+	}
+}
+
+// WithWebhookWorkers sets the number of deliveries posted at the same time,
+// and the number of deliveries waiting for a worker before the new ones fail
+func WithWebhookWorkers(workers int, queueSize int) WebhookDispatcherOption {
+	return func(dispatcher *WebhookDispatcher) {
+		dispatcher.workers = workers
+		dispatcher.queueSize = queueSize
+	}
+}
+
+// WebhookDispatcher posts the catalog events to the webhooks subscribed to them.
+// The deliveries are queued and posted by a fixed pool of workers, each retrying
+// its delivery until the endpoint accepts it.
+type WebhookDispatcher struct {
+	store           WebhookStore
+	client          *http.Client
+	timeout         time.Duration
+	privateNetworks bool
+	maxAttempts     int
+	initialBackoff  time.Duration
+	maxBackoff      time.Duration
+	workers         int
+	queueSize       int
+
+	queue  chan *WebhookDelivery
+	ctx    context.Context
+	cancel context.CancelFunc
+	mutex  sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewWebhookDispatcher returns a dispatcher of the webhooks of the store
+func NewWebhookDispatcher(store WebhookStore, options ...WebhookDispatcherOption) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dispatcher := &WebhookDispatcher{
+		store:          store,
+		timeout:        10 * time.Second,
+		maxAttempts:    5,
+		initialBackoff: time.Second,
+		maxBackoff:     time.Minute,
+		workers:        8,
+		queueSize:      1000,
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+
+	for _, option := range options {
+		option(dispatcher)
+	}
+
+	client := newWebhookHTTPClient(dispatcher.timeout, dispatcher.privateNetworks)
+	if dispatcher.client != nil {
+		client = &http.Client{}
+		*client = *dispatcher.client
+	}
+
+	// an endpoint must not forward the signed payload to another URL, e.g. over plain HTTP or to an internal address
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	dispatcher.client = client
+
+	dispatcher.queue = make(chan *WebhookDelivery, dispatcher.queueSize)
+	for i := 0; i < dispatcher.workers; i++ {
+		dispatcher.wg.Add(1)
+		go dispatcher.work()
+	}
+
+	return dispatcher
+}
+
+// newWebhookHTTPClient returns the HTTP client posting the payloads,
+// refusing to dial private addresses unless privateNetworks is true.
+// The addresses are checked when dialed, after the host names are resolved.
+func newWebhookHTTPClient(timeout time.Duration, privateNetworks bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !privateNetworks {
+		dialer.Control = func(network string, address string, conn syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// a proxy would dial the addresses itself, without the check
+	transport.Proxy = nil
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, not routable on the internet
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP checks that the address is neither loopback, link-local, private nor unspecified
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// checkEndpoint checks that the payloads can be posted to the URL: the private addresses are refused,
+// unless allowed by WithWebhookPrivateNetworks. The host names are checked again when they are dialed,
+// since they may resolve to another address by then.
+func (dispatcher *WebhookDispatcher) checkEndpoint(endpoint *url.URL) error {
+	if dispatcher.privateNetworks {
+		return nil
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(endpoint.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, host)
+	}
+
+	ip := net.ParseIP(host)
+	if ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddressNotAllowed, host)
+	}
+
+	return nil
+}
+
+// Notify delivers the event to all the webhooks subscribed to its type.
+// The ID and the time of the event are set if they are empty.
+func (dispatcher *WebhookDispatcher) Notify(event *pb.WebhookEvent) {
+	if event.GetId() == "" {
+		event.Id = uuid.New().String()
+	}
+	if event.GetTime() == nil {
+		event.Time = timestamppb.Now()
+	}
+
+	logger := slog.With("event_id", event.GetId(), "event_type", event.GetType())
+
+	payload, err := serializer.ProtobufToCompactJSON(event)
+	if err != nil {
+		logger.Error("cannot marshal webhook event", "error", err)
+		return
+	}
+
+	webhooks, err := dispatcher.store.List()
+	if err != nil {
+		logger.Error("cannot list webhooks", "error", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		if !webhook.IsSubscribed(event.GetType()) {
+			continue
+		}
+
+		delivery := NewWebhookDelivery(webhook.ID, event.GetId(), event.GetType(), payload)
+		err := dispatcher.start(delivery)
+		if err != nil {
+			logger.Error("cannot start webhook delivery", "webhook_id", webhook.ID, "error", err)
+		}
+	}
+}
+
+// Redeliver posts the payload of a delivery again, as a new delivery to the same webhook
+func (dispatcher *WebhookDispatcher) Redeliver(deliveryID string) (*WebhookDelivery, error) {
+	delivery, err := dispatcher.store.FindDelivery(deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot find webhook delivery: %w", err)
+	}
+	if delivery == nil {
+		return nil, ErrNotFound
+	}
+
+	redelivery := NewWebhookDelivery(delivery.WebhookID, delivery.EventID, delivery.EventType, delivery.Payload)
+	redelivery.RedeliveryOf = delivery.ID
+
+	err = dispatcher.start(redelivery)
+	if err != nil {
+		return nil, err
+	}
+
+	return redelivery.Clone(), nil
+}
+
+// Close stops the workers and waits for them to end.
+// The pending and queued deliveries stay pending in the store and can be redelivered later.
+func (dispatcher *WebhookDispatcher) Close() {
+	dispatcher.mutex.Lock()
+	dispatcher.closed = true
+	dispatcher.mutex.Unlock()
+
+	dispatcher.cancel()
+	dispatcher.wg.Wait()
+}
+
+// start saves the delivery and queues it for the workers.
+// When the queue is full the delivery is recorded as failed instead of waiting.
+func (dispatcher *WebhookDispatcher) start(delivery *WebhookDelivery) error {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	if dispatcher.closed {
+		return ErrWebhookDispatcherClosed
+	}
+
+	err := dispatcher.store.SaveDelivery(delivery)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case dispatcher.queue <- delivery:
+		return nil
+	default:
+	}
+
+	record := WebhookAttempt{Time: time.Now(), Error: ErrWebhookQueueFull.Error()}
+	err = dispatcher.store.AddAttempt(delivery.ID, record, WebhookDeliveryFailed)
+	if err != nil {
+		return fmt.Errorf("%w: cannot record webhook attempt: %v", ErrWebhookQueueFull, err)
+	}
+	return ErrWebhookQueueFull
+}
+
+// work posts the queued deliveries until the dispatcher is closed
+func (dispatcher *WebhookDispatcher) work() {
+	defer dispatcher.wg.Done()
+
+	for {
+		select {
+		case <-dispatcher.ctx.Done():
+			return
+		case delivery := <-dispatcher.queue:
+			dispatcher.deliver(delivery)
+		}
+	}
+}
+
+func (dispatcher *WebhookDispatcher) deliver(delivery *WebhookDelivery) {
+	logger := slog.With("webhook_id", delivery.WebhookID, "delivery_id", delivery.ID, "event_type", delivery.EventType)
+
+	for attempt := 1; ; attempt++ {
+		// the queued deliveries are left pending when the dispatcher is closed
+		if dispatcher.ctx.Err() != nil {
+			return
+		}
+
+		// the webhook may have been deleted or changed between the attempts
+		webhook, err := dispatcher.store.Find(delivery.WebhookID)
+		if err != nil || webhook == nil {
+			logger.Warn("webhook delivery abandoned", "error", err)
+			return
+		}
+
+		record := WebhookAttempt{Time: time.Now()}
+		record.StatusCode, err = dispatcher.post(webhook, delivery)
+
+		status := WebhookDeliverySucceeded
+		if err != nil {
+			record.Error = err.Error()
+			status = WebhookDeliveryPending
+			if attempt >= dispatcher.maxAttempts || errors.Is(err, errPermanentDelivery) {
+				status = WebhookDeliveryFailed
+			}
+		}
+
+		err = dispatcher.store.AddAttempt(delivery.ID, record, status)
+		if err != nil {
+			logger.Warn("cannot record webhook attempt", "error", err)
+			return
+		}
+
+		switch status {
+		case WebhookDeliverySucceeded:
+			logger.Info("webhook delivered", "attempts", attempt)
+			return
+		case WebhookDeliveryFailed:
+			logger.Warn("webhook delivery failed", "attempts", attempt, "status_code", record.StatusCode, "error", record.Error)
+			return
+		}
+
+		select {
+		case <-time.After(dispatcher.backoff(attempt)):
+		case <-dispatcher.ctx.Done():
+			return
+		}
+	}
+}
+
+// backoff returns the delay after a failed attempt, doubling after each attempt up to the maximum
+func (dispatcher *WebhookDispatcher) backoff(attempt int) time.Duration {
+	delay := dispatcher.initialBackoff
+	for i := 1; i < attempt && delay < dispatcher.maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > dispatcher.maxBackoff {
+		return dispatcher.maxBackoff
+	}
+	return delay
+}
+
+// post sends the signed payload to the webhook and returns the HTTP status code
+func (dispatcher *WebhookDispatcher) post(webhook *Webhook, delivery *WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(dispatcher.ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errPermanentDelivery, err)
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pcbook-webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, delivery.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, timestamp, delivery.Payload))
+
+	res, err := dispatcher.client.Do(req)
+	if errors.Is(err, ErrWebhookAddressNotAllowed) {
+		return 0, fmt.Errorf("%w: %v", errPermanentDelivery, err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	// the body is drained so that the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return res.StatusCode, nil
+	case res.StatusCode >= 500, res.StatusCode == http.StatusTooManyRequests, res.StatusCode == http.StatusRequestTimeout:
+		return res.StatusCode, fmt.Errorf("unexpected status %d", res.StatusCode)
+	default:
+		// including the redirects, which are not followed
+		return res.StatusCode, fmt.Errorf("%w: unexpected status %d", errPermanentDelivery, res.StatusCode)
+	}
+}
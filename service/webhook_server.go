@@ -0,0 +1,238 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/pcbook-go/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// WebhookServer is the server that manages the webhooks of the partners
+type WebhookServer struct {
+	pb.UnimplementedWebhookServiceServer
+	webhookStore WebhookStore
+	dispatcher   *WebhookDispatcher
+}
+
+// NewWebhookServer returns a new webhook server
+func NewWebhookServer(webhookStore WebhookStore, dispatcher *WebhookDispatcher) *WebhookServer {
+	return &WebhookServer{
+		webhookStore: webhookStore,
+		dispatcher:   dispatcher,
+	}
+}
+
+// RegisterWebhook is a unary RPC to register an HTTPS endpoint notified of the event types
+func (server *WebhookServer) RegisterWebhook(ctx context.Context, req *pb.RegisterWebhookRequest) (*pb.RegisterWebhookResponse, error) {
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	endpoint, err := url.Parse(req.GetUrl())
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "webhook url must be an absolute https url")
+	}
+
+	err = server.dispatcher.checkEndpoint(endpoint)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "webhook url is not allowed: %v", err)
+	}
+
+	if len(req.GetEventTypes()) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "at least one event type is required")
+	}
+
+	for _, eventType := range req.GetEventTypes() {
+		if !containsString(WebhookEventTypes, eventType) {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown event type %q, expected one of %v", eventType, WebhookEventTypes)
+		}
+	}
+
+	webhook, err := NewWebhook(endpoint.String(), req.GetEventTypes(), claims.Username, claims.Organization)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate webhook: %v", err)
+	}
+
+	err = server.webhookStore.Save(webhook)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot save webhook: %v", err)
+	}
+
+	res := &pb.RegisterWebhookResponse{
+		Webhook: webhookToProto(webhook),
+		Secret:  webhook.Secret,
+	}
+	return res, nil
+}
+
+// ListWebhooks is a unary RPC to list the webhooks of the caller and of their organization,
+// or all webhooks for a superadmin, without their secrets
+func (server *WebhookServer) ListWebhooks(ctx context.Context, req *pb.ListWebhooksRequest) (*pb.ListWebhooksResponse, error) {
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	webhooks, err := server.webhookStore.List()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list webhooks: %v", err)
+	}
+
+	res := &pb.ListWebhooksResponse{}
+	for _, webhook := range webhooks {
+		if canManageWebhook(claims, webhook) {
+			res.Webhooks = append(res.Webhooks, webhookToProto(webhook))
+		}
+	}
+	return res, nil
+}
+
+// DeleteWebhook is a unary RPC to delete a webhook of the caller or of their organization,
+// or any webhook for a superadmin. Its pending deliveries are abandoned.
+func (server *WebhookServer) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.DeleteWebhookResponse, error) {
+	_, err := server.findWebhook(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = server.webhookStore.Delete(req.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "webhook %s not found", req.GetId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot delete webhook: %v", err)
+	}
+
+	return &pb.DeleteWebhookResponse{}, nil
+}
+
+// ListWebhookDeliveries is a unary RPC to list the latest deliveries of a webhook with their attempts
+func (server *WebhookServer) ListWebhookDeliveries(
+	ctx context.Context,
+	req *pb.ListWebhookDeliveriesRequest,
+) (*pb.ListWebhookDeliveriesResponse, error) {
+	_, err := server.findWebhook(ctx, req.GetWebhookId())
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := server.webhookStore.ListDeliveries(req.GetWebhookId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "webhook %s not found", req.GetWebhookId())
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot list webhook deliveries: %v", err)
+	}
+
+	res := &pb.ListWebhookDeliveriesResponse{}
+	for _, delivery := range deliveries {
+		res.Deliveries = append(res.Deliveries, webhookDeliveryToProto(delivery))
+	}
+	return res, nil
+}
+
+// RedeliverWebhook is a unary RPC to post the payload of a delivery again, e.g. after the endpoint was fixed
+func (server *WebhookServer) RedeliverWebhook(ctx context.Context, req *pb.RedeliverWebhookRequest) (*pb.RedeliverWebhookResponse, error) {
+	delivery, err := server.webhookStore.FindDelivery(req.GetDeliveryId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find webhook delivery: %v", err)
+	}
+	if delivery == nil {
+		return nil, status.Errorf(codes.NotFound, "webhook delivery %s not found", req.GetDeliveryId())
+	}
+
+	_, err = server.findWebhook(ctx, delivery.WebhookID)
+	if status.Code(err) == codes.NotFound {
+		return nil, status.Errorf(codes.NotFound, "webhook delivery %s not found", req.GetDeliveryId())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	delivery, err = server.dispatcher.Redeliver(req.GetDeliveryId())
+	if errors.Is(err, ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "webhook delivery %s not found", req.GetDeliveryId())
+	}
+	if errors.Is(err, ErrWebhookDispatcherClosed) {
+		return nil, status.Errorf(codes.Unavailable, "server is shutting down")
+	}
+	if errors.Is(err, ErrWebhookQueueFull) {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many webhook deliveries, retry later")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot redeliver webhook: %v", err)
+	}
+
+	return &pb.RedeliverWebhookResponse{Delivery: webhookDeliveryToProto(delivery)}, nil
+}
+
+// findWebhook returns a webhook the caller can manage.
+// The webhooks of other organizations are not found, so that their IDs cannot be guessed.
+func (server *WebhookServer) findWebhook(ctx context.Context, id string) (*Webhook, error) {
+	claims, ok := UserClaimsFromContext(ctx)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "user is not authenticated")
+	}
+
+	webhook, err := server.webhookStore.Find(id)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot find webhook: %v", err)
+	}
+	if webhook == nil || !canManageWebhook(claims, webhook) {
+		return nil, status.Errorf(codes.NotFound, "webhook %s not found", id)
+	}
+
+	return webhook, nil
+}
+
+// canManageWebhook checks if the user owns the webhook or belongs to its organization, or is a superadmin
+func canManageWebhook(claims *UserClaims, webhook *Webhook) bool {
+	if claims.HasRole(SuperadminRole) || claims.Username == webhook.Owner {
+		return true
+	}
+
+	return webhook.Organization != "" && claims.Organization == webhook.Organization
+}
+
+func webhookToProto(webhook *Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Id:         webhook.ID,
+		Url:        webhook.URL,
+		EventTypes: webhook.EventTypes,
+		Owner:      webhook.Owner,
+		CreatedAt:  timestamppb.New(webhook.CreatedAt),
+	}
+}
+
+var webhookDeliveryStatuses = map[WebhookDeliveryStatus]pb.WebhookDelivery_Status{
+	WebhookDeliveryPending:   pb.WebhookDelivery_PENDING,
+	WebhookDeliverySucceeded: pb.WebhookDelivery_SUCCEEDED,
+	WebhookDeliveryFailed:    pb.WebhookDelivery_FAILED,
+}
+
+func webhookDeliveryToProto(delivery *WebhookDelivery) *pb.WebhookDelivery {
+	res := &pb.WebhookDelivery{
+		Id:           delivery.ID,
+		WebhookId:    delivery.WebhookID,
+		EventId:      delivery.EventID,
+		EventType:    delivery.EventType,
+		Status:       webhookDeliveryStatuses[delivery.Status],
+		RedeliveryOf: delivery.RedeliveryOf,
+		CreatedAt:    timestamppb.New(delivery.CreatedAt),
+	}
+
+	for _, attempt := range delivery.Attempts {
+		res.Attempts = append(res.Attempts, &pb.WebhookAttempt{
+			Time:       timestamppb.New(attempt.Time),
+			StatusCode: int32(attempt.StatusCode),
+			Error:      attempt.Error,
+		})
+	}
+
+	return res
+}
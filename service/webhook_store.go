@@ -0,0 +1,291 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The event types the webhooks can be registered for
+const (
+	WebhookLaptopCreated       = "laptop.created"
+	WebhookLaptopRepriced      = "laptop.repriced"
+	WebhookLaptopImageUploaded = "laptop.image_uploaded"
+)
+
+// WebhookEventTypes are all the event types of the webhooks
+var WebhookEventTypes = []string{
+	WebhookLaptopCreated,
+	WebhookLaptopRepriced,
+	WebhookLaptopImageUploaded,
+}
+
+// Webhook is an HTTPS endpoint of a partner notified of the catalog events
+type Webhook struct {
+	ID         string
+	URL        string
+	EventTypes []string
+	// Secret is the HMAC key signing the payloads, shared with the partner
+	Secret string
+	Owner  string
+	// Organization is the organization of the owner, whose members can manage the webhook too
+	Organization string
+	CreatedAt    time.Time
+}
+
+// NewWebhook returns a new webhook with a random secret
+func NewWebhook(url string, eventTypes []string, owner string, organization string) (*Webhook, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate webhook secret: %w", err)
+	}
+
+	webhook := &Webhook{
+		ID:           uuid.New().String(),
+		URL:          url,
+		EventTypes:   append([]string(nil), eventTypes...),
+		Secret:       "whsec_" + base64.RawURLEncoding.EncodeToString(secret),
+		Owner:        owner,
+		Organization: organization,
+		CreatedAt:    time.Now(),
+	}
+
+	return webhook, nil
+}
+
+// IsSubscribed checks if the webhook is notified of the event type
+func (webhook *Webhook) IsSubscribed(eventType string) bool {
+	return containsString(webhook.EventTypes, eventType)
+}
+
+func (webhook *Webhook) Clone() *Webhook {
+	other := *webhook
+	other.EventTypes = append([]string(nil), webhook.EventTypes...)
+	return &other
+}
+
+// WebhookDeliveryStatus is the state of a delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is the delivery of an event to a webhook, with all its attempts
+type WebhookDelivery struct {
+	ID        string
+	WebhookID string
+	EventID   string
+	EventType string
+	// Payload is the JSON body posted to the webhook
+	Payload  []byte
+	Status   WebhookDeliveryStatus
+	Attempts []WebhookAttempt
+	// RedeliveryOf is the ID of the delivery sent again
+	RedeliveryOf string
+	CreatedAt    time.Time
+}
+
+// NewWebhookDelivery returns a new pending delivery of the event payload to the webhook
+func NewWebhookDelivery(webhookID string, eventID string, eventType string, payload []byte) *WebhookDelivery {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return &WebhookDelivery{
+		ID:        hex.EncodeToString(id),
+		WebhookID: webhookID,
+		EventID:   eventID,
+		EventType: eventType,
+		Payload:   payload,
+		Status:    WebhookDeliveryPending,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (delivery *WebhookDelivery) Clone() *WebhookDelivery {
+	other := *delivery
+	other.Attempts = append([]WebhookAttempt(nil), delivery.Attempts...)
+	return &other
+}
+
+// WebhookAttempt records a POST of a delivery
+type WebhookAttempt struct {
+	Time time.Time
+	// StatusCode is 0 when the endpoint could not be reached
+	StatusCode int
+	Error      string
+}
+
+// WebhookStore is an interface to store the webhooks and their deliveries
+type WebhookStore interface {
+	// Save saves a new webhook to the store
+	Save(webhook *Webhook) error
+	// Find finds a webhook by ID
+	Find(id string) (*Webhook, error)
+	// List returns all webhooks
+	List() ([]*Webhook, error)
+	// Delete removes a webhook and its deliveries
+	Delete(id string) error
+	// SaveDelivery saves a new delivery of a webhook
+	SaveDelivery(delivery *WebhookDelivery) error
+	// FindDelivery finds a delivery by ID
+	FindDelivery(id string) (*WebhookDelivery, error)
+	// ListDeliveries returns the deliveries of a webhook, the newest first
+	ListDeliveries(webhookID string) ([]*WebhookDelivery, error)
+	// AddAttempt records an attempt of a delivery and its new status
+	AddAttempt(deliveryID string, attempt WebhookAttempt, status WebhookDeliveryStatus) error
+}
+
+// maxWebhookDeliveries caps the deliveries kept for each webhook, the oldest are forgotten first
+const maxWebhookDeliveries = 100
+
+// InMemoryWebhookStore stores the webhooks and their deliveries in memory
+type InMemoryWebhookStore struct {
+	mutex      sync.RWMutex
+	webhooks   map[string]*Webhook
+	deliveries map[string]*WebhookDelivery
+	// history holds the delivery IDs of each webhook, the oldest first
+	history map[string][]string
+}
+
+// NewInMemoryWebhookStore returns a new InMemoryWebhookStore
+func NewInMemoryWebhookStore() *InMemoryWebhookStore {
+	return &InMemoryWebhookStore{
+		webhooks:   make(map[string]*Webhook),
+		deliveries: make(map[string]*WebhookDelivery),
+		history:    make(map[string][]string),
+	}
+}
+
+func (store *InMemoryWebhookStore) Save(webhook *Webhook) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.webhooks[webhook.ID] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.webhooks[webhook.ID] = webhook.Clone()
+	return nil
+}
+
+func (store *InMemoryWebhookStore) Find(id string) (*Webhook, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	webhook := store.webhooks[id]
+	if webhook == nil {
+		return nil, nil
+	}
+
+	return webhook.Clone(), nil
+}
+
+func (store *InMemoryWebhookStore) List() ([]*Webhook, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(store.webhooks))
+	for _, webhook := range store.webhooks {
+		webhooks = append(webhooks, webhook.Clone())
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt)
+	})
+
+	return webhooks, nil
+}
+
+func (store *InMemoryWebhookStore) Delete(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.webhooks[id] == nil {
+		return ErrNotFound
+	}
+
+	for _, deliveryID := range store.history[id] {
+		delete(store.deliveries, deliveryID)
+	}
+	delete(store.history, id)
+	delete(store.webhooks, id)
+	return nil
+}
+
+func (store *InMemoryWebhookStore) SaveDelivery(delivery *WebhookDelivery) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.webhooks[delivery.WebhookID] == nil {
+		return ErrNotFound
+	}
+	if store.deliveries[delivery.ID] != nil {
+		return ErrAlreadyExists
+	}
+
+	store.deliveries[delivery.ID] = delivery.Clone()
+
+	history := append(store.history[delivery.WebhookID], delivery.ID)
+	if len(history) > maxWebhookDeliveries {
+		for _, deliveryID := range history[:len(history)-maxWebhookDeliveries] {
+			delete(store.deliveries, deliveryID)
+		}
+		history = append([]string(nil), history[len(history)-maxWebhookDeliveries:]...)
+	}
+	store.history[delivery.WebhookID] = history
+
+	return nil
+}
+
+func (store *InMemoryWebhookStore) FindDelivery(id string) (*WebhookDelivery, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	delivery := store.deliveries[id]
+	if delivery == nil {
+		return nil, nil
+	}
+
+	return delivery.Clone(), nil
+}
+
+func (store *InMemoryWebhookStore) ListDeliveries(webhookID string) ([]*WebhookDelivery, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	if store.webhooks[webhookID] == nil {
+		return nil, ErrNotFound
+	}
+
+	history := store.history[webhookID]
+	deliveries := make([]*WebhookDelivery, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, store.deliveries[history[i]].Clone())
+	}
+
+	return deliveries, nil
+}
+
+func (store *InMemoryWebhookStore) AddAttempt(deliveryID string, attempt WebhookAttempt, status WebhookDeliveryStatus) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delivery := store.deliveries[deliveryID]
+	if delivery == nil {
+		return ErrNotFound
+	}
+
+	delivery.Attempts = append(delivery.Attempts, attempt)
+	delivery.Status = status
+	return nil
+}
@@ -0,0 +1,408 @@
+package service_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/serializer"
+	"github.com/pcbook-go/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// webhookReceiver é um endpoint HTTPS de um parceiro, que responde com os status definidos pelo teste
+type webhookReceiver struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func startWebhookReceiver(t *testing.T, statuses ...int) *webhookReceiver {
+	receiver := &webhookReceiver{statuses: statuses}
+
+	receiver.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		receiver.mutex.Lock()
+		defer receiver.mutex.Unlock()
+
+		receiver.requests = append(receiver.requests, r)
+		receiver.bodies = append(receiver.bodies, body)
+
+		// depois dos status definidos, as entregas são aceitas
+		code := http.StatusOK
+		if len(receiver.statuses) > 0 {
+			code = receiver.statuses[0]
+			receiver.statuses = receiver.statuses[1:]
+		}
+		if code >= 300 && code < 400 {
+			w.Header().Set("Location", "http://"+r.Host+r.URL.Path)
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(receiver.server.Close)
+
+	return receiver
+}
+
+func (receiver *webhookReceiver) received() int {
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+
+	return len(receiver.requests)
+}
+
+func newTestWebhookServer(t *testing.T, receiver *webhookReceiver) (*service.WebhookServer, *service.WebhookDispatcher) {
+	store := service.NewInMemoryWebhookStore()
+	dispatcher := service.NewWebhookDispatcher(
+		store,
+		service.WithWebhookHTTPClient(receiver.server.Client()),
+		service.WithWebhookRetries(3, time.Millisecond, 10*time.Millisecond),
+		// o receptor de teste escuta em 127.0.0.1
+		service.WithWebhookPrivateNetworks(),
+	)
+	t.Cleanup(dispatcher.Close)
+
+	return service.NewWebhookServer(store, dispatcher), dispatcher
+}
+
+func adminContext() context.Context {
+	return service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin"})
+}
+
+// waitDeliveries espera as entregas do webhook terminarem e as retorna
+func waitDeliveries(t *testing.T, server *service.WebhookServer, webhookID string, count int) []*pb.WebhookDelivery {
+	var deliveries []*pb.WebhookDelivery
+
+	require.Eventually(t, func() bool {
+		res, err := server.ListWebhookDeliveries(adminContext(), &pb.ListWebhookDeliveriesRequest{WebhookId: webhookID})
+		require.NoError(t, err)
+
+		deliveries = res.GetDeliveries()
+		if len(deliveries) != count {
+			return false
+		}
+		for _, delivery := range deliveries {
+			if delivery.GetStatus() == pb.WebhookDelivery_PENDING {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 5*time.Millisecond)
+
+	return deliveries
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	t.Parallel()
+
+	// a primeira entrega falha e é repetida
+	receiver := startWebhookReceiver(t, http.StatusServiceUnavailable)
+	webhookServer, dispatcher := newTestWebhookServer(t, receiver)
+	ctx := adminContext()
+
+	registered, err := webhookServer.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{
+		Url:        receiver.server.URL + "/hooks",
+		EventTypes: []string{service.WebhookLaptopCreated, service.WebhookLaptopRepriced},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, registered.GetSecret())
+	require.Equal(t, "admin1", registered.GetWebhook().GetOwner())
+
+	laptopServer := service.NewLaptopServer(service.NewInMemoryLaptopStore(), nil, nil, service.WithWebhooks(dispatcher))
+	laptop := sample.NewLaptop()
+	_, err = laptopServer.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	deliveries := waitDeliveries(t, webhookServer, registered.GetWebhook().GetId(), 1)
+	require.Equal(t, pb.WebhookDelivery_SUCCEEDED, deliveries[0].GetStatus())
+	require.Len(t, deliveries[0].GetAttempts(), 2)
+	require.EqualValues(t, http.StatusServiceUnavailable, deliveries[0].GetAttempts()[0].GetStatusCode())
+
+	// uma atualização sem mudança de preço não é notificada
+	_, err = laptopServer.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+	previousPrice := laptop.GetPriceUsd()
+	laptop.PriceUsd = previousPrice + 100
+	_, err = laptopServer.UpdateLaptop(ctx, &pb.UpdateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	deliveries = waitDeliveries(t, webhookServer, registered.GetWebhook().GetId(), 2)
+	require.Equal(t, service.WebhookLaptopRepriced, deliveries[0].GetEventType())
+	require.Equal(t, 3, receiver.received())
+
+	// o parceiro verifica a assinatura do payload com o segredo do webhook
+	request := receiver.requests[2]
+	body := receiver.bodies[2]
+	require.Equal(t, "/hooks", request.URL.Path)
+	require.Equal(t, "application/json", request.Header.Get("Content-Type"))
+	require.Equal(t, service.WebhookLaptopRepriced, request.Header.Get(service.WebhookEventHeader))
+	require.Equal(t, deliveries[0].GetId(), request.Header.Get(service.WebhookDeliveryHeader))
+
+	timestamp, err := strconv.ParseInt(request.Header.Get(service.WebhookTimestampHeader), 10, 64)
+	require.NoError(t, err)
+	signature := request.Header.Get(service.WebhookSignatureHeader)
+	require.True(t, service.VerifyWebhookSignature(registered.GetSecret(), timestamp, body, signature))
+	require.False(t, service.VerifyWebhookSignature("other", timestamp, body, signature))
+	require.False(t, service.VerifyWebhookSignature(registered.GetSecret(), timestamp+1, body, signature))
+
+	event := &pb.WebhookEvent{}
+	require.NoError(t, serializer.JSONToProtobufMessage(string(body), event))
+	require.Equal(t, deliveries[0].GetEventId(), event.GetId())
+	require.Equal(t, previousPrice, event.GetPreviousPriceUsd())
+	require.Equal(t, laptop.GetPriceUsd(), event.GetLaptop().GetPriceUsd())
+}
+
+func TestWebhookRedelivery(t *testing.T) {
+	t.Parallel()
+
+	// um 404 não é repetido, a entrega falha de uma vez
+	receiver := startWebhookReceiver(t, http.StatusNotFound)
+	webhookServer, dispatcher := newTestWebhookServer(t, receiver)
+	ctx := adminContext()
+
+	registered, err := webhookServer.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{
+		Url:        receiver.server.URL,
+		EventTypes: []string{service.WebhookLaptopImageUploaded},
+	})
+	require.NoError(t, err)
+
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopCreated})
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopImageUploaded, Image: &pb.WebhookImage{Id: "image1"}})
+
+	deliveries := waitDeliveries(t, webhookServer, registered.GetWebhook().GetId(), 1)
+	require.Equal(t, pb.WebhookDelivery_FAILED, deliveries[0].GetStatus())
+	require.Len(t, deliveries[0].GetAttempts(), 1)
+
+	// depois que o endpoint é corrigido, a entrega é enviada de novo com o mesmo evento
+	res, err := webhookServer.RedeliverWebhook(ctx, &pb.RedeliverWebhookRequest{DeliveryId: deliveries[0].GetId()})
+	require.NoError(t, err)
+	require.Equal(t, deliveries[0].GetId(), res.GetDelivery().GetRedeliveryOf())
+
+	deliveries = waitDeliveries(t, webhookServer, registered.GetWebhook().GetId(), 2)
+	require.Equal(t, pb.WebhookDelivery_SUCCEEDED, deliveries[0].GetStatus())
+	require.Equal(t, deliveries[1].GetEventId(), deliveries[0].GetEventId())
+	require.Equal(t, receiver.bodies[0], receiver.bodies[1])
+
+	_, err = webhookServer.RedeliverWebhook(ctx, &pb.RedeliverWebhookRequest{DeliveryId: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// as entregas são removidas com o webhook
+	_, err = webhookServer.DeleteWebhook(ctx, &pb.DeleteWebhookRequest{Id: registered.GetWebhook().GetId()})
+	require.NoError(t, err)
+	_, err = webhookServer.RedeliverWebhook(ctx, &pb.RedeliverWebhookRequest{DeliveryId: deliveries[0].GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = webhookServer.ListWebhookDeliveries(ctx, &pb.ListWebhookDeliveriesRequest{WebhookId: registered.GetWebhook().GetId()})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestRegisterWebhookValidation(t *testing.T) {
+	t.Parallel()
+
+	webhookServer, _ := newTestWebhookServer(t, startWebhookReceiver(t))
+	ctx := adminContext()
+
+	for _, req := range []*pb.RegisterWebhookRequest{
+		{Url: "http://partner.example.com/hooks", EventTypes: []string{service.WebhookLaptopCreated}},
+		{Url: "/hooks", EventTypes: []string{service.WebhookLaptopCreated}},
+		{Url: "https://partner.example.com/hooks"},
+		{Url: "https://partner.example.com/hooks", EventTypes: []string{"laptop.deleted"}},
+	} {
+		_, err := webhookServer.RegisterWebhook(ctx, req)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), req.String())
+	}
+
+	_, err := webhookServer.RegisterWebhook(context.Background(), &pb.RegisterWebhookRequest{
+		Url:        "https://partner.example.com/hooks",
+		EventTypes: []string{service.WebhookLaptopCreated},
+	})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	res, err := webhookServer.ListWebhooks(ctx, &pb.ListWebhooksRequest{})
+	require.NoError(t, err)
+	require.Empty(t, res.GetWebhooks())
+}
+
+func TestWebhookRedirect(t *testing.T) {
+	t.Parallel()
+
+	// o endpoint redireciona o payload assinado para http, o redirecionamento não é seguido
+	receiver := startWebhookReceiver(t, http.StatusFound)
+	webhookServer, dispatcher := newTestWebhookServer(t, receiver)
+
+	registered, err := webhookServer.RegisterWebhook(adminContext(), &pb.RegisterWebhookRequest{
+		Url:        receiver.server.URL + "/hooks",
+		EventTypes: []string{service.WebhookLaptopCreated},
+	})
+	require.NoError(t, err)
+
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopCreated})
+
+	deliveries := waitDeliveries(t, webhookServer, registered.GetWebhook().GetId(), 1)
+	require.Equal(t, pb.WebhookDelivery_FAILED, deliveries[0].GetStatus())
+	require.Len(t, deliveries[0].GetAttempts(), 1)
+	require.EqualValues(t, http.StatusFound, deliveries[0].GetAttempts()[0].GetStatusCode())
+	require.Equal(t, 1, receiver.received())
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	t.Parallel()
+
+	receiver := startWebhookReceiver(t)
+	store := service.NewInMemoryWebhookStore()
+	dispatcher := service.NewWebhookDispatcher(store, service.WithWebhookRetries(3, time.Millisecond, 10*time.Millisecond))
+	t.Cleanup(dispatcher.Close)
+	webhookServer := service.NewWebhookServer(store, dispatcher)
+	ctx := adminContext()
+
+	for _, url := range []string{
+		receiver.server.URL,
+		"https://localhost/hooks",
+		"https://10.0.0.1/hooks",
+		"https://192.168.1.1/hooks",
+		"https://169.254.169.254/latest/meta-data",
+		"https://[::1]/hooks",
+		"https://[fe80::1]/hooks",
+	} {
+		_, err := webhookServer.RegisterWebhook(ctx, &pb.RegisterWebhookRequest{
+			Url:        url,
+			EventTypes: []string{service.WebhookLaptopCreated},
+		})
+		require.Equal(t, codes.InvalidArgument, status.Code(err), url)
+	}
+
+	// um nome pode apontar para um endereço privado depois do registro, o endereço é verificado na conexão
+	webhook, err := service.NewWebhook(receiver.server.URL, []string{service.WebhookLaptopCreated}, "admin1", "")
+	require.NoError(t, err)
+	require.NoError(t, store.Save(webhook))
+
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopCreated})
+
+	deliveries := waitDeliveries(t, webhookServer, webhook.ID, 1)
+	require.Equal(t, pb.WebhookDelivery_FAILED, deliveries[0].GetStatus())
+	require.Len(t, deliveries[0].GetAttempts(), 1)
+	require.Contains(t, deliveries[0].GetAttempts()[0].GetError(), service.ErrWebhookAddressNotAllowed.Error())
+	require.Zero(t, receiver.received())
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	t.Parallel()
+
+	// o endpoint segura a primeira entrega até o fim do teste
+	received := make(chan struct{}, 3)
+	release := make(chan struct{})
+	receiver := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	t.Cleanup(receiver.Close)
+	t.Cleanup(func() { close(release) })
+
+	store := service.NewInMemoryWebhookStore()
+	dispatcher := service.NewWebhookDispatcher(
+		store,
+		service.WithWebhookHTTPClient(receiver.Client()),
+		service.WithWebhookPrivateNetworks(),
+		service.WithWebhookWorkers(1, 1),
+	)
+	webhookServer := service.NewWebhookServer(store, dispatcher)
+
+	registered, err := webhookServer.RegisterWebhook(adminContext(), &pb.RegisterWebhookRequest{
+		Url:        receiver.URL,
+		EventTypes: []string{service.WebhookLaptopCreated},
+	})
+	require.NoError(t, err)
+	webhookID := registered.GetWebhook().GetId()
+
+	// o único worker fica ocupado com a primeira entrega, a segunda espera na fila e a terceira falha
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopCreated})
+	<-received
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopCreated})
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopCreated})
+
+	res, err := webhookServer.ListWebhookDeliveries(adminContext(), &pb.ListWebhookDeliveriesRequest{WebhookId: webhookID})
+	require.NoError(t, err)
+	require.Len(t, res.GetDeliveries(), 3)
+
+	var failed *pb.WebhookDelivery
+	statuses := map[pb.WebhookDelivery_Status]int{}
+	for _, delivery := range res.GetDeliveries() {
+		statuses[delivery.GetStatus()]++
+		if delivery.GetStatus() == pb.WebhookDelivery_FAILED {
+			failed = delivery
+		}
+	}
+	require.Equal(t, map[pb.WebhookDelivery_Status]int{pb.WebhookDelivery_PENDING: 2, pb.WebhookDelivery_FAILED: 1}, statuses)
+	require.Equal(t, service.ErrWebhookQueueFull.Error(), failed.GetAttempts()[0].GetError())
+
+	// a entrega que falhou só pode ser repetida quando houver espaço na fila
+	_, err = webhookServer.RedeliverWebhook(adminContext(), &pb.RedeliverWebhookRequest{DeliveryId: failed.GetId()})
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	dispatcher.Close()
+}
+
+func TestWebhookOrganizations(t *testing.T) {
+	t.Parallel()
+
+	receiver := startWebhookReceiver(t, http.StatusNotFound)
+	webhookServer, dispatcher := newTestWebhookServer(t, receiver)
+
+	owner := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin1", Role: "admin", Organization: "pcbook"})
+	colleague := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin2", Role: "admin", Organization: "pcbook"})
+	stranger := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "admin3", Role: "admin", Organization: "outra"})
+	superadmin := service.ContextWithUserClaims(context.Background(), &service.UserClaims{Username: "root", Role: service.SuperadminRole})
+
+	registered, err := webhookServer.RegisterWebhook(owner, &pb.RegisterWebhookRequest{
+		Url:        receiver.server.URL,
+		EventTypes: []string{service.WebhookLaptopCreated},
+	})
+	require.NoError(t, err)
+	webhookID := registered.GetWebhook().GetId()
+
+	dispatcher.Notify(&pb.WebhookEvent{Type: service.WebhookLaptopCreated})
+	deliveries := waitDeliveries(t, webhookServer, webhookID, 1)
+	deliveryID := deliveries[0].GetId()
+
+	// os webhooks de outra organização não são listados nem encontrados
+	res, err := webhookServer.ListWebhooks(stranger, &pb.ListWebhooksRequest{})
+	require.NoError(t, err)
+	require.Empty(t, res.GetWebhooks())
+
+	_, err = webhookServer.ListWebhookDeliveries(stranger, &pb.ListWebhookDeliveriesRequest{WebhookId: webhookID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = webhookServer.RedeliverWebhook(stranger, &pb.RedeliverWebhookRequest{DeliveryId: deliveryID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = webhookServer.DeleteWebhook(stranger, &pb.DeleteWebhookRequest{Id: webhookID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Equal(t, 1, receiver.received())
+
+	// os colegas da organização gerenciam o webhook
+	res, err = webhookServer.ListWebhooks(colleague, &pb.ListWebhooksRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.GetWebhooks(), 1)
+
+	_, err = webhookServer.RedeliverWebhook(colleague, &pb.RedeliverWebhookRequest{DeliveryId: deliveryID})
+	require.NoError(t, err)
+	waitDeliveries(t, webhookServer, webhookID, 2)
+
+	// o superadmin gerencia todos os webhooks
+	res, err = webhookServer.ListWebhooks(superadmin, &pb.ListWebhooksRequest{})
+	require.NoError(t, err)
+	require.Len(t, res.GetWebhooks(), 1)
+
+	_, err = webhookServer.DeleteWebhook(superadmin, &pb.DeleteWebhookRequest{Id: webhookID})
+	require.NoError(t, err)
+}
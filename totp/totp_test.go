@@ -0,0 +1,55 @@
+package totp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pcbook-go/totp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+
+	// vetores de teste do RFC 6238 para SHA1, truncados para 6 dígitos
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	testCases := []struct {
+		time int64
+		code string
+	}{
+		{time: 59, code: "287082"},
+		{time: 1111111109, code: "081804"},
+		{time: 1234567890, code: "005924"},
+		{time: 20000000000, code: "353130"},
+	}
+
+	for _, tc := range testCases {
+		code, err := totp.Code(secret, time.Unix(tc.time, 0))
+		require.NoError(t, err)
+		require.Equal(t, tc.code, code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	code, err := totp.Code(secret, now)
+	require.NoError(t, err)
+
+	// o código do período anterior ainda é aceito, para a diferença dos relógios
+	counter, ok := totp.Validate(secret, code, now.Add(totp.Period), 0)
+	require.True(t, ok)
+
+	// mas um código não pode ser usado duas vezes
+	_, ok = totp.Validate(secret, code, now, counter)
+	require.False(t, ok)
+
+	_, ok = totp.Validate(secret, code, now.Add(3*totp.Period), 0)
+	require.False(t, ok)
+	_, ok = totp.Validate("not base32!", code, now, 0)
+	require.False(t, ok)
+}
@@ -0,0 +1,261 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const instrumentationName = "github.com/pcbook-go/tracing"
+
+// propagator reads and writes the W3C traceparent and tracestate headers
+var propagator = propagation.TraceContext{}
+
+// metadataCarrier adapts the gRPC metadata to the propagation API
+type metadataCarrier metadata.MD
+
+func (carrier metadataCarrier) Get(key string) string {
+	values := metadata.MD(carrier).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+func (carrier metadataCarrier) Set(key string, value string) {
+	metadata.MD(carrier).Set(key, value)
+}
+
+func (carrier metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// ServerInterceptor is a server interceptor continuing the trace of the client with a span for each call.
+// It must be the first interceptor of the chain, so that the others run inside the span.
+type ServerInterceptor struct {
+	tracer trace.Tracer
+}
+
+// NewServerInterceptor returns a new server tracing interceptor
+func NewServerInterceptor(provider trace.TracerProvider) *ServerInterceptor {
+	return &ServerInterceptor{provider.Tracer(instrumentationName)}
+}
+
+func (interceptor *ServerInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, span := interceptor.start(ctx, info.FullMethod)
+		defer span.End()
+
+		res, err := handler(ctx, req)
+
+		endSpan(span, err, isServerError)
+		return res, err
+	}
+}
+
+func (interceptor *ServerInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := interceptor.start(stream.Context(), info.FullMethod)
+		defer span.End()
+
+		err := handler(srv, &serverStream{stream, ctx})
+
+		endSpan(span, err, isServerError)
+		return err
+	}
+}
+
+// start extracts the trace context sent by the client and starts the server span of the call
+func (interceptor *ServerInterceptor) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagator.Extract(ctx, metadataCarrier(md))
+
+	return interceptor.tracer.Start(
+		ctx,
+		spanName(method),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(rpcAttributes(method)...),
+	)
+}
+
+// serverStream replaces the context of a stream with the one carrying the span
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *serverStream) Context() context.Context {
+	return stream.ctx
+}
+
+// ClientInterceptor is a client interceptor starting a span for each call and sending its trace context
+type ClientInterceptor struct {
+	tracer trace.Tracer
+}
+
+// NewClientInterceptor returns a new client tracing interceptor
+func NewClientInterceptor(provider trace.TracerProvider) *ClientInterceptor {
+	return &ClientInterceptor{provider.Tracer(instrumentationName)}
+}
+
+func (interceptor *ClientInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, span := interceptor.start(ctx, method)
+		defer span.End()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		endSpan(span, err, isClientError)
+		return err
+	}
+}
+
+func (interceptor *ClientInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, span := interceptor.start(ctx, method)
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			endSpan(span, err, isClientError)
+			span.End()
+			return nil, err
+		}
+
+		return &clientStream{ClientStream: stream, desc: desc, span: span}, nil
+	}
+}
+
+// start starts the client span of the call and attaches its trace context to the outgoing metadata
+func (interceptor *ClientInterceptor) start(ctx context.Context, method string) (context.Context, trace.Span) {
+	ctx, span := interceptor.tracer.Start(
+		ctx,
+		spanName(method),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(rpcAttributes(method)...),
+	)
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+
+	propagator.Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+// clientStream ends the span of a stream when the stream ends
+type clientStream struct {
+	grpc.ClientStream
+	desc    *grpc.StreamDesc
+	span    trace.Span
+	endOnce sync.Once
+}
+
+func (stream *clientStream) RecvMsg(m interface{}) error {
+	err := stream.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil && !stream.desc.ServerStreams:
+		// a client streaming call ends with its single response
+		stream.end(nil)
+	case err != nil:
+		stream.end(err)
+	}
+
+	return err
+}
+
+func (stream *clientStream) end(err error) {
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	stream.endOnce.Do(func() {
+		endSpan(stream.span, err, isClientError)
+		stream.span.End()
+	})
+}
+
+// endSpan records the status code of the call on the span, marking it as failed if isError is true for the code
+func endSpan(span trace.Span, err error, isError func(code codes.Code) bool) {
+	code := status.Code(err)
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+
+	if isError(code) {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+}
+
+// isServerError checks if the code means the server failed, rather than the client
+func isServerError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented,
+		codes.Internal, codes.Unavailable, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+func isClientError(code codes.Code) bool {
+	return code != codes.OK
+}
+
+// spanName returns the span name of a full method like "/pcbook.LaptopService/SearchLaptop"
+func spanName(method string) string {
+	return strings.TrimPrefix(method, "/")
+}
+
+func rpcAttributes(method string) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{semconv.RPCSystemGRPC}
+
+	service, name, ok := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	if ok {
+		attributes = append(attributes, semconv.RPCService(service), semconv.RPCMethod(name))
+	}
+
+	return attributes
+}
@@ -0,0 +1,95 @@
+// Package tracing configures the OpenTelemetry traces of the server and the client,
+// and propagates the trace context through the gRPC metadata.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	// NoExporter disables the traces
+	NoExporter = "none"
+	// StdoutExporter writes the spans as JSON objects to the standard output
+	StdoutExporter = "stdout"
+	// FileExporter writes the spans as JSON objects to a file, one per line
+	FileExporter = "file"
+)
+
+// NewExporter returns the span exporter of the given kind, or nil for NoExporter.
+// The file of FileExporter is created if needed and closed when the exporter is shut down.
+func NewExporter(kind string, filename string) (sdktrace.SpanExporter, error) {
+	switch kind {
+	case NoExporter, "":
+		return nil, nil
+	case StdoutExporter:
+		return NewWriterExporter(os.Stdout)
+	case FileExporter:
+		if filename == "" {
+			return nil, fmt.Errorf("the file exporter needs a file name")
+		}
+
+		file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open trace file: %w", err)
+		}
+
+		exporter, err := NewWriterExporter(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+
+		return &fileExporter{exporter, file}, nil
+	default:
+		return nil, fmt.Errorf("unknown trace exporter: %s", kind)
+	}
+}
+
+// NewWriterExporter returns an exporter writing the spans as JSON objects to w, one per line
+func NewWriterExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create trace exporter: %w", err)
+	}
+
+	return exporter, nil
+}
+
+// fileExporter closes its file once the spans are exported
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func (exporter *fileExporter) Shutdown(ctx context.Context) error {
+	err := exporter.SpanExporter.Shutdown(ctx)
+	closeErr := exporter.file.Close()
+	if err != nil {
+		return err
+	}
+
+	return closeErr
+}
+
+// NewTracerProvider returns a tracer provider sending the spans of the service to the exporter in batches.
+// Without exporter, the spans are still created and propagated, but never exported.
+// The provider must be shut down to export the last spans.
+func NewTracerProvider(serviceName string, exporter sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+	}
+
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+
+	return sdktrace.NewTracerProvider(options...)
+}
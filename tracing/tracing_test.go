@@ -0,0 +1,139 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/pcbook-go/tracing"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// exportedSpan holds the fields of the spans written by the file exporter used by the tests
+type exportedSpan struct {
+	Name        string
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+	}
+	Parent struct {
+		TraceID string
+		SpanID  string
+	}
+	Status struct {
+		Code string
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	t.Parallel()
+
+	traceFile := filepath.Join(t.TempDir(), "traces.json")
+	exporter, err := tracing.NewExporter(tracing.FileExporter, traceFile)
+	require.NoError(t, err)
+	provider := tracing.NewTracerProvider("pcbook-test", exporter)
+
+	laptopStore := service.NewTracedLaptopStore(service.NewInMemoryLaptopStore(), provider)
+	laptop := sample.NewLaptop()
+	laptop.PriceUsd = 1000
+	require.NoError(t, laptopStore.Save(context.Background(), laptop))
+
+	serverInterceptor := tracing.NewServerInterceptor(provider)
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(serverInterceptor.Unary()),
+		grpc.StreamInterceptor(serverInterceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	clientInterceptor := tracing.NewClientInterceptor(provider)
+	conn, err := grpc.Dial(
+		listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(clientInterceptor.Unary()),
+		grpc.WithStreamInterceptor(clientInterceptor.Stream()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	laptopClient := pb.NewLaptopServiceClient(conn)
+
+	stream, err := laptopClient.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 2000}})
+	require.NoError(t, err)
+	for {
+		_, err = stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	_, err = laptopClient.FindLaptop(context.Background(), &pb.FindLaptopRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// o encerramento do provider exporta os últimos spans e fecha o arquivo
+	require.NoError(t, provider.Shutdown(context.Background()))
+
+	file, err := os.Open(traceFile)
+	require.NoError(t, err)
+	defer file.Close()
+
+	spans := make(map[string][]exportedSpan)
+	decoder := json.NewDecoder(file)
+	for decoder.More() {
+		var span exportedSpan
+		require.NoError(t, decoder.Decode(&span))
+		spans[span.Name] = append(spans[span.Name], span)
+	}
+
+	// o span da loja é filho do span do servidor, que continua o trace do cliente
+	search := spans["pcbook.LaptopService/SearchLaptop"]
+	require.Len(t, search, 2)
+	client, server := search[0], search[1]
+	if server.Parent.SpanID != client.SpanContext.SpanID {
+		client, server = server, client
+	}
+	require.Equal(t, client.SpanContext.SpanID, server.Parent.SpanID)
+	require.Equal(t, client.SpanContext.TraceID, server.SpanContext.TraceID)
+
+	storeSearch := spans["LaptopStore.Search"]
+	require.Len(t, storeSearch, 1)
+	require.Equal(t, server.SpanContext.SpanID, storeSearch[0].Parent.SpanID)
+	require.Equal(t, client.SpanContext.TraceID, storeSearch[0].SpanContext.TraceID)
+
+	// um laptop inexistente é um erro para o cliente, mas não para o servidor
+	find := spans["pcbook.LaptopService/FindLaptop"]
+	require.Len(t, find, 2)
+	require.ElementsMatch(t, []string{"Error", "Unset"}, []string{find[0].Status.Code, find[1].Status.Code})
+	require.Len(t, spans["LaptopStore.Find"], 1)
+	require.Len(t, spans["LaptopStore.Save"], 1)
+}
+
+func TestNewExporter(t *testing.T) {
+	t.Parallel()
+
+	exporter, err := tracing.NewExporter(tracing.NoExporter, "")
+	require.NoError(t, err)
+	require.Nil(t, exporter)
+
+	_, err = tracing.NewExporter(tracing.FileExporter, "")
+	require.Error(t, err)
+
+	_, err = tracing.NewExporter("jaeger", "")
+	require.Error(t, err)
+}
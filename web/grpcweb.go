@@ -0,0 +1,42 @@
+// Package web serves the gRPC services to browsers with the gRPC-Web protocol,
+// so that the generated gRPC-Web stubs can call the server without the REST gateway.
+package web
+
+import (
+	"net/http"
+
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+)
+
+// AnyOrigin allows the cross-origin calls from any website
+const AnyOrigin = "*"
+
+// NewGRPCWebHandler returns an HTTP handler translating the gRPC-Web calls, unary and server streaming,
+// to calls of the gRPC server, which go through all its interceptors.
+// The browsers can call it from the allowed origins, like "https://pcbook.example.com", or from any origin with AnyOrigin.
+// The other HTTP requests are not found.
+func NewGRPCWebHandler(server *grpc.Server, allowedOrigins []string) http.Handler {
+	wrapped := grpcweb.WrapServer(server, grpcweb.WithOriginFunc(OriginAllowed(allowedOrigins)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wrapped.IsGrpcWebRequest(r) || wrapped.IsAcceptableGrpcCorsRequest(r) {
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+
+		http.NotFound(w, r)
+	})
+}
+
+// OriginAllowed returns the CORS check of the allowed origins
+func OriginAllowed(allowedOrigins []string) func(origin string) bool {
+	allowed := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		allowed[origin] = true
+	}
+
+	return func(origin string) bool {
+		return allowed[AnyOrigin] || allowed[origin]
+	}
+}
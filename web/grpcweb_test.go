@@ -0,0 +1,168 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pcbook-go/pb"
+	"github.com/pcbook-go/sample"
+	"github.com/pcbook-go/service"
+	"github.com/pcbook-go/web"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+const allowedOrigin = "http://localhost:3000"
+
+func startTestGRPCWebServer(t *testing.T, laptops int) string {
+	keySet, err := service.NewKeySet(service.NewHMACKey("", []byte("secret")))
+	require.NoError(t, err)
+
+	policy, err := service.LoadPolicyFile("../policy.yaml")
+	require.NoError(t, err)
+
+	jwtManager := service.NewJWTManager(keySet, time.Minute, time.Hour, service.NewInMemoryRevocationStore())
+	interceptor := service.NewAuthInterceptor(jwtManager, nil, policy)
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	for i := 0; i < laptops; i++ {
+		require.NoError(t, laptopStore.Save(context.Background(), sample.NewLaptop()))
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.Unary()),
+		grpc.StreamInterceptor(interceptor.Stream()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+
+	httpServer := httptest.NewServer(web.NewGRPCWebHandler(grpcServer, []string{allowedOrigin}))
+	t.Cleanup(httpServer.Close)
+
+	return httpServer.URL
+}
+
+// grpcWebResponse holds the messages and the trailers of a gRPC-Web response
+type grpcWebResponse struct {
+	messages [][]byte
+	trailers http.Header
+}
+
+// callGRPCWeb sends a gRPC-Web call with a single request message, like the browser stubs do
+func callGRPCWeb(t *testing.T, url string, method string, req proto.Message) *grpcWebResponse {
+	data, err := proto.Marshal(req)
+	require.NoError(t, err)
+
+	body := &bytes.Buffer{}
+	body.WriteByte(0)
+	require.NoError(t, binary.Write(body, binary.BigEndian, uint32(len(data))))
+	body.Write(data)
+
+	httpReq, err := http.NewRequest(http.MethodPost, url+method, body)
+	require.NoError(t, err)
+	httpReq.Header.Set("Content-Type", "application/grpc-web+proto")
+	httpReq.Header.Set("X-Grpc-Web", "1")
+
+	httpRes, err := http.DefaultClient.Do(httpReq)
+	require.NoError(t, err)
+	defer httpRes.Body.Close()
+	require.Equal(t, http.StatusOK, httpRes.StatusCode)
+
+	// a call failing at once has its status in the headers, without a trailer frame
+	res := &grpcWebResponse{trailers: httpRes.Header.Clone()}
+	for {
+		header := make([]byte, 5)
+		_, err := io.ReadFull(httpRes.Body, header)
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		frame := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		_, err = io.ReadFull(httpRes.Body, frame)
+		require.NoError(t, err)
+
+		if header[0]&0x80 == 0 {
+			res.messages = append(res.messages, frame)
+			continue
+		}
+
+		// the trailer frame holds the status as "key:value" lines
+		for _, line := range strings.Split(string(frame), "\r\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if ok {
+				res.trailers.Set(key, strings.TrimSpace(value))
+			}
+		}
+	}
+
+	return res
+}
+
+func TestGRPCWebCalls(t *testing.T) {
+	t.Parallel()
+
+	url := startTestGRPCWebServer(t, 3)
+
+	// o SearchLaptop envia um laptop por mensagem, seguidos do status
+	res := callGRPCWeb(t, url, "/pcbook.LaptopService/SearchLaptop", &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 1e9},
+	})
+	require.Equal(t, "0", res.trailers.Get("Grpc-Status"))
+	require.Len(t, res.messages, 3)
+	for _, message := range res.messages {
+		laptop := &pb.SearchLaptopResponse{}
+		require.NoError(t, proto.Unmarshal(message, laptop))
+		require.NotEmpty(t, laptop.GetLaptop().GetId())
+	}
+
+	res = callGRPCWeb(t, url, "/pcbook.LaptopService/FindLaptop", &pb.FindLaptopRequest{Id: "unknown"})
+	require.Equal(t, "5", res.trailers.Get("Grpc-Status"))
+	require.Empty(t, res.messages)
+
+	// as chamadas passam pelos interceptors do servidor gRPC
+	res = callGRPCWeb(t, url, "/pcbook.LaptopService/CreateLaptop", &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Equal(t, "16", res.trailers.Get("Grpc-Status"))
+}
+
+func TestGRPCWebCORS(t *testing.T) {
+	t.Parallel()
+
+	url := startTestGRPCWebServer(t, 0)
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, url+"/pcbook.LaptopService/SearchLaptop", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,authorization")
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	res := preflight(allowedOrigin)
+	require.Equal(t, allowedOrigin, res.Header.Get("Access-Control-Allow-Origin"))
+	require.Contains(t, strings.ToLower(res.Header.Get("Access-Control-Allow-Headers")), "authorization")
+
+	// os outros sites não podem chamar o servidor
+	res = preflight("http://evil.example.com")
+	require.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+
+	res, err := http.Get(url + "/pcbook.LaptopService/SearchLaptop")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	require.True(t, web.OriginAllowed([]string{web.AnyOrigin})("http://any.example.com"))
+	require.False(t, web.OriginAllowed(nil)(allowedOrigin))
+}